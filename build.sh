@@ -15,6 +15,6 @@ cd -
 # Build the container
 docker build --tag 'mpdroog/docker-iqfeed:latest' -f Dockerfile .
 # Run it
-docker run -p 9100:9101 -p 8080:8080 --cap-drop ALL --security-opt no-new-privileges --memory=256m --cpus=1 --rm --env-file iqfeed.env mpdroog/docker-iqfeed
+docker run -p 9100:9101 -p 5009:5010 -p 8080:8080 --cap-drop ALL --security-opt no-new-privileges --memory=256m --cpus=1 --rm --env-file iqfeed.env mpdroog/docker-iqfeed
 # Deploy it
 # docker push mpdroog/docker-iqfeed:latest
\ No newline at end of file
@@ -3,14 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"log/slog"
-	"net"
 	"os"
 	"time"
 )
 
+// killProcess sends SIGKILL to the running process name
 func killProcess(name string) error {
-	v, ok := Running.Load("iqfeed")
+	v, ok := Running.Load(name)
 	if !ok {
 		// nothing to kill, not running probably
 		return nil
@@ -26,11 +27,31 @@ func killProcess(name string) error {
 		return e
 	}
 	//if Verbose {
-	slog.Info("admin[readlineT] kill iqfeed", "pid", pid)
+	slog.Info("admin[readlineT] kill", "name", name, "pid", pid)
 	//}\
 	return nil
 }
 
+// adminCmd sends cmd (S,CONNECT or S,DISCONNECT) over a new admin conn (9300)
+func adminCmd(cmd string) error {
+	if _, ok := Running.Load("iqfeed"); !ok {
+		return fmt.Errorf("iqfeed not running")
+	}
+	conn, e := upstream.Dial(upstream.Admin)
+	if e != nil {
+		return e
+	}
+	defer conn.Close()
+	if e := conn.SetDeadline(time.Now().Add(defaultConnectTimeout)); e != nil {
+		return e
+	}
+	if _, e := conn.Write([]byte(cmd + "\r\n")); e != nil {
+		return e
+	}
+	slog.Info("admin[adminCmd]", "cmd", cmd)
+	return nil
+}
+
 func initConn(dur time.Duration) (*PoolConn, error) {
 	// is running?
 	{
@@ -48,7 +69,7 @@ func initConn(dur time.Duration) (*PoolConn, error) {
 		slog.Info("admin[connect]")
 	}
 	// Keep alive conn
-	conn, e := net.DialTimeout("tcp", "127.0.0.1:9300", defaultConnectTimeout)
+	conn, e := upstream.Dial(upstream.Admin)
 	if e != nil {
 		return nil, e
 	}
@@ -136,6 +157,7 @@ func admin() {
 
 			// S,STATS,,,0,0,1,0,0,0,,,Not Connected,6.2.0.25,\"490914\",0,0.0,0.0,0.08,0.08,0.08,
 			if bytes.HasPrefix(bin, []byte("S,STATS")) {
+				iqfeedStatsUpdate(bin)
 				tok := bytes.SplitN(bin, []byte(","), 16)
 				if bytes.Equal(tok[12], []byte("Not Connected")) {
 					Running.Delete("admin")
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/** maxInterval is the largest interval we accept (1 week) */
+const maxInterval = 7 * 24 * 60 * 60
+
+/** MaxAggLines is the maximum of minute bars or ticks one aggregated request reads */
+var MaxAggLines = 1000000
+
+// parseInterval reads an interval in seconds (60) or with unit (90s, 7m, 2h)
+func parseInterval(val string) (int, error) {
+	mul := 1
+	switch {
+	case strings.HasSuffix(val, "s"):
+		val = strings.TrimSuffix(val, "s")
+	case strings.HasSuffix(val, "m"):
+		val = strings.TrimSuffix(val, "m")
+		mul = 60
+	case strings.HasSuffix(val, "h"):
+		val = strings.TrimSuffix(val, "h")
+		mul = 60 * 60
+	}
+	n, e := strconv.Atoi(val)
+	if e != nil {
+		return 0, fmt.Errorf("invalid interval, expected seconds or 90s|7m|2h")
+	}
+	n *= mul
+	if n < 1 || n > maxInterval {
+		return 0, fmt.Errorf("interval out of range 1s..%ds", maxInterval)
+	}
+	return n, nil
+}
+
+// Session is the part of the trading day bars are aligned to
+type Session struct {
+	Name     string // rth|eth, empty is the whole calendar day (no filter)
+	Exchange *Exchange
+}
+
+// length returns the duration of a regular session
+func (s Session) length() time.Duration {
+	if s.Name == "" {
+		return 24 * time.Hour
+	}
+	return s.Exchange.Length(s.Name)
+}
+
+// filterTimes returns the Begin/EndFilterTime of the session (empty for the whole day)
+func (s Session) filterTimes() (string, string) {
+	if s.Name == "" {
+		return "", ""
+	}
+	return s.Exchange.FilterTimes(s.Name)
+}
+
+// at returns the clock-time d on the day of t, DST-safe
+func at(t time.Time, d time.Duration) time.Time {
+	y, m, day := t.Date()
+	return time.Date(y, m, day, int(d/time.Hour), int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second), 0, t.Location())
+}
+
+// bucket returns the end (label) of the bar t belongs to, ok=false if t is outside the session
+func (s Session) bucket(t time.Time, interval time.Duration) (time.Time, bool) {
+	if s.Name == "" {
+		// midnight to midnight like IQFeed, weekends included (futures)
+		day := at(t.In(s.Exchange.loc), 0)
+		y, m, d := day.Date()
+		end := day.Add((t.Sub(day)/interval + 1) * interval)
+		if next := time.Date(y, m, d+1, 0, 0, 0, 0, day.Location()); end.After(next) {
+			end = next
+		}
+		return end, true
+	}
+
+	open, close, ok := s.Exchange.Hours(t, s.Name)
+	if !ok || t.Before(open) || !t.Before(close) {
+		return time.Time{}, false
+	}
+
+	end := open.Add((t.Sub(open)/interval + 1) * interval)
+	if end.After(close) {
+		// last bar of the session is shorter
+		end = close
+	}
+	return end, true
+}
+
+// Aggregator builds interval bars up to the session length from minute bars
+// or ticks, aligned to the session open and labeled at the end of the bar (like IQFeed)
+type Aggregator struct {
+	Interval time.Duration
+	Session  Session
+
+	cur *IntervalBar
+	out []IntervalBar
+}
+
+// add merges one base bar/trade into the current bar
+func (a *Aggregator) add(end time.Time, high, low, open, close float64, totalVolume, volume, trades int64) {
+	if a.cur != nil && !a.cur.Datetime.Equal(end) {
+		a.out = append(a.out, *a.cur)
+		a.cur = nil
+	}
+	if a.cur == nil {
+		a.cur = &IntervalBar{Datetime: end, High: high, Low: low, Open: open}
+	}
+	if high > a.cur.High {
+		a.cur.High = high
+	}
+	if low < a.cur.Low {
+		a.cur.Low = low
+	}
+	a.cur.Close = close
+	// TotalVolume is cumulative for the day, keep the last
+	a.cur.TotalVolume = totalVolume
+	a.cur.PeriodVolume += volume
+	a.cur.NumberOfTrades += trades
+}
+
+// AddBar merges a minute bar (oldest-first), the bar is labeled at its end
+func (a *Aggregator) AddBar(b IntervalBar) {
+	end, ok := a.Session.bucket(b.Datetime.Add(-time.Minute), a.Interval)
+	if !ok {
+		return
+	}
+	a.add(end, b.High, b.Low, b.Open, b.Close, b.TotalVolume, b.PeriodVolume, b.NumberOfTrades)
+}
+
+// AddTick merges a trade (oldest-first), ticks without size are quotes
+func (a *Aggregator) AddTick(t Tick) {
+	if t.LastSize == 0 {
+		return
+	}
+	end, ok := a.Session.bucket(t.Datetime, a.Interval)
+	if !ok {
+		return
+	}
+	a.add(end, t.Last, t.Last, t.Last, t.Last, t.TotalVolume, t.LastSize, 1)
+}
+
+// Bars returns all bars oldest-first (including the one still forming)
+func (a *Aggregator) Bars() []IntervalBar {
+	if a.cur != nil {
+		a.out = append(a.out, *a.cur)
+		a.cur = nil
+	}
+	return a.out
+}
+
+// AggReq describes an /ohlc-intervals request IQFeed can't serve directly
+type AggReq struct {
+	Asset    string
+	Interval int // seconds
+	Session  Session
+	Ticks    bool   // build from HTT/HTD instead of minute bars
+	Last     int    // keep the last N bars (0=all)
+	Days     int    // days to fetch when Begin is empty
+	Begin    string // iqDatetime
+	End      string
+	Oldest   bool
+}
+
+// days returns the calendar days of base data needed for the last N bars
+func (a *AggReq) days() int {
+	x := a.Session.Exchange
+	perDay := math.Ceil(float64(a.Session.length()) / float64(time.Duration(a.Interval)*time.Second))
+	now := time.Now()
+	from := x.TradingDaysBack(int(math.Ceil(float64(a.Last)/perDay))+1, now)
+	days := int(now.Sub(from)/(24*time.Hour)) + 1
+	if a.Days > 0 && a.Days < days {
+		// never read more days than Last needs
+		return a.Days
+	}
+	return days
+}
+
+// baseCmd returns the HIT/HID/HTT/HTD cmd for the base data oldest-first
+func (a *AggReq) baseCmd() []byte {
+	bf, ef := a.Session.filterTimes()
+	if a.Ticks {
+		if a.Begin != "" {
+			// HTT,[Symbol],[BeginDate Time],[EndDate Time],[MaxDatapoints],[BeginFilterTime],[EndFilterTime],[DataDirection]
+			return []byte(fmt.Sprintf("HTT,%s,%s,%s,,%s,%s,1", a.Asset, a.Begin, a.End, bf, ef))
+		}
+		// HTD,[Symbol],[Days],[MaxDatapoints],[BeginFilterTime],[EndFilterTime],[DataDirection]
+		return []byte(fmt.Sprintf("HTD,%s,%d,,%s,%s,1", a.Asset, a.days(), bf, ef))
+	}
+	if a.Begin != "" {
+		return []byte(fmt.Sprintf("HIT,%s,60,%s,%s,,%s,%s,1", a.Asset, a.Begin, a.End, bf, ef))
+	}
+	return []byte(fmt.Sprintf("HID,%s,60,%d,,%s,%s,1", a.Asset, a.days(), bf, ef))
+}
+
+// cacheReq returns the minute bars request the cache can answer (nil=uncached)
+func (a *AggReq) cacheReq() *CacheReq {
+	if a.Ticks || a.Begin == "" {
+		return nil
+	}
+	creq := &CacheReq{Asset: a.Asset, Interval: 60, Oldest: true}
+	creq.From, _ = time.ParseInLocation("20060102 150405", a.Begin, exchangeTZ)
+	creq.To = time.Now()
+	if a.End != "" {
+		creq.To, _ = time.ParseInLocation("20060102 150405", a.End, exchangeTZ)
+	}
+	return creq
+}
+
+// aggregated builds the bars of areq from minute bars or ticks
+func aggregated(w http.ResponseWriter, r *http.Request, areq *AggReq) {
+	agg := &Aggregator{Interval: time.Duration(areq.Interval) * time.Second, Session: areq.Session}
+	cmd := areq.baseCmd()
+
+	var e error
+	if areq.Ticks {
+		e = proxy(cmd, MaxAggLines, func(bin []byte) error {
+			tick, e := parseTick(bin)
+			if e != nil {
+				return e
+			}
+			agg.AddTick(tick)
+			return nil
+		})
+	} else {
+		e = cachedProxy(w, r, areq.cacheReq(), cmd, MaxAggLines, func(bin []byte) error {
+			bar, e := parseIntervalBar(bin)
+			if e != nil {
+				return e
+			}
+			agg.AddBar(bar)
+			return nil
+		})
+	}
+	if e != nil && strings.HasPrefix(e.Error(), "CRIT: loopLimit") {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d upstream lines, please use less datapoints/days or source=minute", MaxAggLines)}); e != nil {
+			slog.Error("HTTP[aggregated] WriteMaxLines", "e", e.Error())
+		}
+		return
+	}
+	if e != nil && e.Error() != "!NO_DATA!" {
+		slog.Error("HTTP[aggregated] proxy", "e", e.Error())
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Upstream error", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[aggregated] WriteUpstreamError", "e", e.Error())
+		}
+		return
+	}
+
+	out := agg.Bars()
+	if areq.Last > 0 && len(out) > areq.Last {
+		out = out[len(out)-areq.Last:]
+	}
+	if len(out) == 0 {
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "No data"}); e != nil {
+			slog.Error("HTTP[aggregated] WriteNoData", "e", e.Error())
+		}
+		return
+	}
+	if !areq.Oldest {
+		// IQFeed default is newest-first
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+
+	source, session := "minute", areq.Session.Name
+	if areq.Ticks {
+		source = "tick"
+	}
+	if session == "" {
+		session = "day"
+	}
+	w.Header().Set("X-Aggregated", source+","+session)
+	if e := writer.Encode(w, r, 200, out); e != nil {
+		slog.Error("HTTP[aggregated] WriteEncode", "e", e.Error())
+	}
+}
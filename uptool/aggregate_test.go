@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	for val, exp := range map[string]int{"60": 60, "90s": 90, "7m": 420, "4h": 14400} {
+		n, e := parseInterval(val)
+		if e != nil || n != exp {
+			t.Errorf("parseInterval(%s)=%d,%v expected %d", val, n, e, exp)
+		}
+	}
+	for _, val := range []string{"", "0", "-5m", "1d", "abc"} {
+		if _, e := parseInterval(val); e == nil {
+			t.Errorf("parseInterval(%s) accepted", val)
+		}
+	}
+}
+
+func TestAggregateBars(t *testing.T) {
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, exchangeTZ)
+	agg := &Aggregator{Interval: 2 * time.Hour, Session: Session{Name: "rth", Exchange: defaultCalendar().Exchanges["NYSE"]}}
+
+	// pre-market bar, skipped in RTH
+	agg.AddBar(IntervalBar{Datetime: at(day, 9*time.Hour+30*time.Minute), High: 99, Low: 1, Open: 50, Close: 50, TotalVolume: 100, PeriodVolume: 100, NumberOfTrades: 1})
+	// 09:30-11:30
+	agg.AddBar(IntervalBar{Datetime: at(day, 9*time.Hour+31*time.Minute), High: 11, Low: 9, Open: 10, Close: 10.5, TotalVolume: 150, PeriodVolume: 50, NumberOfTrades: 2})
+	agg.AddBar(IntervalBar{Datetime: at(day, 11*time.Hour+30*time.Minute), High: 12, Low: 10, Open: 10.5, Close: 11, TotalVolume: 180, PeriodVolume: 30, NumberOfTrades: 3})
+	// 15:30-16:00 is the short last bar
+	agg.AddBar(IntervalBar{Datetime: at(day, 16*time.Hour), High: 13, Low: 12, Open: 12, Close: 13, TotalVolume: 200, PeriodVolume: 20, NumberOfTrades: 1})
+
+	bars := agg.Bars()
+	if len(bars) != 2 {
+		t.Fatalf("bars=%+v", bars)
+	}
+	b := bars[0]
+	if !b.Datetime.Equal(at(day, 11*time.Hour+30*time.Minute)) || b.Open != 10 || b.High != 12 || b.Low != 9 || b.Close != 11 {
+		t.Errorf("bar[0] OHLC=%+v", b)
+	}
+	if b.PeriodVolume != 80 || b.TotalVolume != 180 || b.NumberOfTrades != 5 {
+		t.Errorf("bar[0] volume=%+v", b)
+	}
+	if !bars[1].Datetime.Equal(at(day, 16*time.Hour)) {
+		t.Errorf("bar[1] Datetime=%s", bars[1].Datetime)
+	}
+}
+
+func TestAggregateTicks(t *testing.T) {
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, exchangeTZ)
+	agg := &Aggregator{Interval: 7 * time.Minute, Session: Session{Name: "eth", Exchange: defaultCalendar().Exchanges["NYSE"]}}
+
+	agg.AddTick(Tick{Datetime: at(day, 4*time.Hour+time.Second), Last: 10, LastSize: 5, TotalVolume: 5})
+	agg.AddTick(Tick{Datetime: at(day, 4*time.Hour+2*time.Minute), Last: 0, LastSize: 0, TotalVolume: 5})
+	agg.AddTick(Tick{Datetime: at(day, 4*time.Hour+6*time.Minute), Last: 9, LastSize: 10, TotalVolume: 15})
+	agg.AddTick(Tick{Datetime: at(day, 4*time.Hour+7*time.Minute), Last: 11, LastSize: 1, TotalVolume: 16})
+
+	bars := agg.Bars()
+	if len(bars) != 2 {
+		t.Fatalf("bars=%+v", bars)
+	}
+	b := bars[0]
+	if !b.Datetime.Equal(at(day, 4*time.Hour+7*time.Minute)) || b.Open != 10 || b.Low != 9 || b.Close != 9 {
+		t.Errorf("bar[0]=%+v", b)
+	}
+	if b.PeriodVolume != 15 || b.NumberOfTrades != 2 || b.TotalVolume != 15 {
+		t.Errorf("bar[0] volume=%+v", b)
+	}
+}
+
+func TestAggregateNoSession(t *testing.T) {
+	day := time.Date(2024, 5, 12, 0, 0, 0, 0, exchangeTZ) // Sunday
+	agg := &Aggregator{Interval: 7 * time.Hour, Session: Session{Exchange: defaultCalendar().Exchanges["NYSE"]}}
+
+	// overnight futures trading is kept, the last bar ends at midnight
+	agg.AddTick(Tick{Datetime: at(day, 2*time.Hour), Last: 10, LastSize: 1, TotalVolume: 1})
+	agg.AddTick(Tick{Datetime: at(day, 22*time.Hour), Last: 11, LastSize: 1, TotalVolume: 2})
+
+	bars := agg.Bars()
+	if len(bars) != 2 {
+		t.Fatalf("bars=%+v", bars)
+	}
+	if !bars[0].Datetime.Equal(at(day, 7*time.Hour)) {
+		t.Errorf("bar[0] Datetime=%s", bars[0].Datetime)
+	}
+	if !bars[1].Datetime.Equal(at(day, 24*time.Hour)) {
+		t.Errorf("bar[1] Datetime=%s", bars[1].Datetime)
+	}
+}
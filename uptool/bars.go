@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+	_ "time/tzdata" // Alpine has no zoneinfo
+)
+
+/** exchangeTZ is the timezone IQFeed uses for all timestamps */
+var exchangeTZ *time.Location
+
+func init() {
+	var e error
+	exchangeTZ, e = time.LoadLocation("America/New_York")
+	if e != nil {
+		panic("DevErr: " + e.Error())
+	}
+}
+
+// LH,2023-05-25,288.8400,272.8500,287.9100,280.9900,878367,0,
+type DailyBar struct {
+	Datetime     time.Time
+	High         float64
+	Low          float64
+	Open         float64
+	Close        float64
+	PeriodVolume int64
+	OpenInterest int64
+}
+
+// LH,2024-05-10 05:32:00,184.7600,184.7500,184.7500,184.7600,32048,250,3,
+type IntervalBar struct {
+	Datetime       time.Time
+	High           float64
+	Low            float64
+	Open           float64
+	Close          float64
+	TotalVolume    int64
+	PeriodVolume   int64
+	NumberOfTrades int64
+}
+
+// parseFloat reads an upstream decimal, empty fields are 0
+func parseFloat(bin []byte) (float64, error) {
+	if len(bin) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(string(bin), 64)
+}
+
+// parseInt reads an upstream integer, empty fields are 0
+func parseInt(bin []byte) (int64, error) {
+	if len(bin) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(bin), 10, 64)
+}
+
+// formatFloat writes prices the shortest way without exponent
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (b DailyBar) CSVHeader() []string {
+	return []string{"DateStamp", "High", "Low", "Open", "Close", "PeriodVolume", "OpenInterest"}
+}
+func (b DailyBar) CSVRecord() []string {
+	return []string{
+		b.Datetime.Format("2006-01-02"),
+		formatFloat(b.High), formatFloat(b.Low), formatFloat(b.Open), formatFloat(b.Close),
+		strconv.FormatInt(b.PeriodVolume, 10), strconv.FormatInt(b.OpenInterest, 10),
+	}
+}
+
+func (b IntervalBar) CSVHeader() []string {
+	return []string{"TimeStamp", "High", "Low", "Open", "Close", "TotalVolume", "PeriodVolume", "NumberOfTrades"}
+}
+func (b IntervalBar) CSVRecord() []string {
+	return []string{
+		b.Datetime.Format("2006-01-02 15:04:05"),
+		formatFloat(b.High), formatFloat(b.Low), formatFloat(b.Open), formatFloat(b.Close),
+		strconv.FormatInt(b.TotalVolume, 10), strconv.FormatInt(b.PeriodVolume, 10), strconv.FormatInt(b.NumberOfTrades, 10),
+	}
+}
+
+// parseOHLC reads the High,Low,Open,Close fields shared by all bars
+func parseOHLC(buf [][]byte) (high, low, open, close float64, e error) {
+	if high, e = parseFloat(buf[0]); e != nil {
+		return
+	}
+	if low, e = parseFloat(buf[1]); e != nil {
+		return
+	}
+	if open, e = parseFloat(buf[2]); e != nil {
+		return
+	}
+	close, e = parseFloat(buf[3])
+	return
+}
+
+// parseDailyBar converts a HDX/HWX/HMX/HDT line into a DailyBar
+func parseDailyBar(bin []byte) (DailyBar, error) {
+	var (
+		b DailyBar
+		e error
+	)
+	buf := bytes.Split(bin, []byte(","))
+	if len(buf) < 8 {
+		return b, fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	}
+
+	if b.Datetime, e = time.ParseInLocation("2006-01-02", string(buf[1]), exchangeTZ); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing DateStamp line=%s\n", bin)
+	}
+	if b.High, b.Low, b.Open, b.Close, e = parseOHLC(buf[2:6]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing OHLC line=%s\n", bin)
+	}
+	if b.PeriodVolume, e = parseInt(buf[6]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing PeriodVolume line=%s\n", bin)
+	}
+	if b.OpenInterest, e = parseInt(buf[7]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing OpenInterest line=%s\n", bin)
+	}
+	return b, nil
+}
+
+// parseIntervalBar converts a HIX/HID/HIT line into an IntervalBar
+func parseIntervalBar(bin []byte) (IntervalBar, error) {
+	var (
+		b IntervalBar
+		e error
+	)
+	buf := bytes.Split(bin, []byte(","))
+	if len(buf) < 9 {
+		return b, fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	}
+
+	if b.Datetime, e = time.ParseInLocation("2006-01-02 15:04:05", string(buf[1]), exchangeTZ); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing TimeStamp line=%s\n", bin)
+	}
+	if b.High, b.Low, b.Open, b.Close, e = parseOHLC(buf[2:6]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing OHLC line=%s\n", bin)
+	}
+	if b.TotalVolume, e = parseInt(buf[6]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing TotalVolume line=%s\n", bin)
+	}
+	if b.PeriodVolume, e = parseInt(buf[7]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing PeriodVolume line=%s\n", bin)
+	}
+	if b.NumberOfTrades, e = parseInt(buf[8]); e != nil {
+		return b, fmt.Errorf("WARN: Failed parsing NumberOfTrades line=%s\n", bin)
+	}
+	return b, nil
+}
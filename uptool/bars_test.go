@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBars(t *testing.T) {
+	d, e := parseDailyBar([]byte("LH,2023-05-25,288.8400,272.8500,287.9100,280.9900,878367,12,"))
+	if e != nil {
+		t.Fatalf("parseDailyBar e=%s", e.Error())
+	}
+	if d.High != 288.84 || d.Close != 280.99 || d.PeriodVolume != 878367 || d.OpenInterest != 12 {
+		t.Errorf("parseDailyBar=%+v", d)
+	}
+	if !d.Datetime.Equal(time.Date(2023, 5, 25, 0, 0, 0, 0, exchangeTZ)) {
+		t.Errorf("parseDailyBar Datetime=%s", d.Datetime)
+	}
+
+	i, e := parseIntervalBar([]byte("LH,2024-05-10 05:32:00,184.7600,184.7500,184.7500,184.7600,32048,250,3,"))
+	if e != nil {
+		t.Fatalf("parseIntervalBar e=%s", e.Error())
+	}
+	if i.TotalVolume != 32048 || i.PeriodVolume != 250 || i.NumberOfTrades != 3 {
+		t.Errorf("parseIntervalBar volumes=%+v", i)
+	}
+	if i.Datetime.Location() != exchangeTZ || i.Datetime.Hour() != 5 || i.Datetime.Minute() != 32 {
+		t.Errorf("parseIntervalBar Datetime=%s", i.Datetime)
+	}
+
+	if _, e := parseIntervalBar([]byte("LH,2024-05-10 05:32:00,abc,184.7500,184.7500,184.7600,32048,250,3,")); e == nil {
+		t.Errorf("parseIntervalBar accepted invalid price")
+	}
+}
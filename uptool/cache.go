@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/** cacheRecheck is the minimum time between two tail-fetches of the same key */
+const cacheRecheck = 60 * time.Second
+
+var cacheMeta = []byte("meta")
+
+// CacheReq describes a bar request the cache can answer
+type CacheReq struct {
+	Asset    string
+	Interval int // 0=daily bars, else interval in seconds
+	Last     int // > 0 means the last N bars, else From/To
+	From     time.Time
+	To       time.Time
+	Max      int  // MaxDatapoints for From/To (0=all)
+	Oldest   bool // oldest-first instead of newest-first
+}
+
+// key returns the bucket name
+func (c *CacheReq) key() []byte {
+	if c.Interval == 0 {
+		return []byte("D:" + c.Asset)
+	}
+	return []byte(fmt.Sprintf("I%d:%s", c.Interval, c.Asset))
+}
+
+// barDuration returns the length of a single bar
+func (c *CacheReq) barDuration() time.Duration {
+	if c.Interval == 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+// ts returns the bar time of an upstream line
+func (c *CacheReq) ts(bin []byte) (time.Time, error) {
+	if c.Interval == 0 {
+		b, e := parseDailyBar(bin)
+		return b.Datetime, e
+	}
+	b, e := parseIntervalBar(bin)
+	return b.Datetime, e
+}
+
+// rangeCmd returns the HDT/HIT cmd for [from, to] oldest-first
+func (c *CacheReq) rangeCmd(from, to time.Time) []byte {
+	from = from.In(exchangeTZ)
+	to = to.In(exchangeTZ)
+	if c.Interval == 0 {
+		return []byte(fmt.Sprintf("HDT,%s,%s,%s,,1", c.Asset, from.Format("20060102"), to.Format("20060102")))
+	}
+	return []byte(fmt.Sprintf("HIT,%s,%d,%s,%s,,,,1", c.Asset, c.Interval, from.Format("20060102 150405"), to.Format("20060102 150405")))
+}
+
+// lastCmd returns the HDX/HIX cmd for the last n bars oldest-first
+func (c *CacheReq) lastCmd(n int) []byte {
+	if c.Interval == 0 {
+		return []byte(fmt.Sprintf("HDX,%s,%d,1", c.Asset, n))
+	}
+	return []byte(fmt.Sprintf("HIX,%s,%d,%d,1", c.Asset, c.Interval, n))
+}
+
+// coverage is the contiguous range we have in cache for a key
+type coverage struct {
+	From    time.Time
+	To      time.Time
+	Checked time.Time // last tail-fetch
+}
+
+func (c coverage) encode() []byte {
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint64(buf[0:], uint64(c.From.Unix()))
+	binary.BigEndian.PutUint64(buf[8:], uint64(c.To.Unix()))
+	binary.BigEndian.PutUint64(buf[16:], uint64(c.Checked.Unix()))
+	return buf
+}
+
+func decodeCoverage(buf []byte) (coverage, bool) {
+	if len(buf) != 24 {
+		return coverage{}, false
+	}
+	return coverage{
+		From:    time.Unix(int64(binary.BigEndian.Uint64(buf[0:])), 0),
+		To:      time.Unix(int64(binary.BigEndian.Uint64(buf[8:])), 0),
+		Checked: time.Unix(int64(binary.BigEndian.Uint64(buf[16:])), 0),
+	}, true
+}
+
+// tsKey returns the sortable bucket key of a bar
+func tsKey(t time.Time) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(t.Unix()))
+	return buf
+}
+
+// BarCache keeps upstream bar lines on disk so we only
+// need to fetch the missing tail/gap from IQFeed.
+type BarCache struct {
+	db    *bolt.DB
+	locks sync.Map // key => *sync.Mutex
+
+	Hits    atomic.Int64
+	Partial atomic.Int64
+	Misses  atomic.Int64
+}
+
+var barCache *BarCache
+
+// BarCacheInit opens (or creates) the cache at path
+func BarCacheInit(path string) error {
+	db, e := bolt.Open(path, 0600, &bolt.Options{Timeout: defaultConnectTimeout})
+	if e != nil {
+		return e
+	}
+	if e := db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists(cacheMeta)
+		return e
+	}); e != nil {
+		db.Close()
+		return e
+	}
+	barCache = &BarCache{db: db}
+	return nil
+}
+
+// lock serializes fills of the same key
+func (c *BarCache) lock(key []byte) func() {
+	v, _ := c.locks.LoadOrStore(string(key), new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// coverage returns what we have for key
+func (c *BarCache) coverage(key []byte) (cov coverage, ok bool, e error) {
+	e = c.db.View(func(tx *bolt.Tx) error {
+		cov, ok = decodeCoverage(tx.Bucket(cacheMeta).Get(key))
+		return nil
+	})
+	return
+}
+
+// fetch proxies cmd and stores all lines, returns the time of the oldest and newest bar
+func (c *BarCache) fetch(req *CacheReq, cmd []byte) (oldest, newest time.Time, n int, e error) {
+	type bar struct {
+		ts   time.Time
+		line []byte
+	}
+	var bars []bar
+	if e := proxy(cmd, -1, func(bin []byte) error {
+		ts, e := req.ts(bin)
+		if e != nil {
+			return e
+		}
+		bars = append(bars, bar{ts: ts, line: bytes.Clone(bin)})
+		return nil
+	}); e != nil && e.Error() != "!NO_DATA!" {
+		return oldest, newest, 0, e
+	}
+
+	e = c.db.Update(func(tx *bolt.Tx) error {
+		b, e := tx.CreateBucketIfNotExists(req.key())
+		if e != nil {
+			return e
+		}
+		for _, bar := range bars {
+			if e := b.Put(tsKey(bar.ts), bar.line); e != nil {
+				return e
+			}
+			if bar.ts.After(newest) {
+				newest = bar.ts
+			}
+			if oldest.IsZero() || bar.ts.Before(oldest) {
+				oldest = bar.ts
+			}
+		}
+		return nil
+	})
+	return oldest, newest, len(bars), e
+}
+
+// storeCoverage saves cov for key
+func (c *BarCache) storeCoverage(key []byte, cov coverage) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheMeta).Put(key, cov.encode())
+	})
+}
+
+// settledTo returns until when [from, to] can be marked as covered, the newest
+// bar is still forming so the next tail-fetch needs to start at it again
+func settledTo(req *CacheReq, from, to, newest time.Time) time.Time {
+	if to.Before(time.Now().Add(-req.barDuration())) {
+		return to
+	}
+	if newest.IsZero() {
+		return from
+	}
+	return newest
+}
+
+// fill fetches [from, to] and grows the coverage of key
+func (c *BarCache) fill(req *CacheReq, cov coverage, ok bool, from, to time.Time) (coverage, int, error) {
+	_, newest, n, e := c.fetch(req, req.rangeCmd(from, to))
+	if e != nil {
+		return cov, n, e
+	}
+	to = settledTo(req, from, to, newest)
+	if !ok || from.Before(cov.From) {
+		cov.From = from
+	}
+	if !ok || to.After(cov.To) {
+		cov.To = to
+	}
+	return cov, n, c.storeCoverage(req.key(), cov)
+}
+
+// read returns all cached lines of key in [from, to] oldest-first
+func (c *BarCache) read(key []byte, from, to time.Time) ([][]byte, error) {
+	var out [][]byte
+	e := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(key)
+		if b == nil {
+			return nil
+		}
+		max := tsKey(to)
+		cur := b.Cursor()
+		for k, v := cur.Seek(tsKey(from)); k != nil && bytes.Compare(k, max) <= 0; k, v = cur.Next() {
+			out = append(out, bytes.Clone(v))
+		}
+		return nil
+	})
+	return out, e
+}
+
+// Get answers req from cache and fetches the missing tail/gap,
+// status is HIT (cache only), PARTIAL (gap fetched) or MISS (full fetch)
+func (c *BarCache) Get(req *CacheReq) (lines [][]byte, status string, e error) {
+	key := req.key()
+	defer c.lock(key)()
+
+	cov, ok, e := c.coverage(key)
+	if e != nil {
+		return nil, "", e
+	}
+	status = "HIT"
+
+	if req.Last > 0 {
+		// Last N bars, bring the tail up to date
+		if ok && time.Since(cov.Checked) > cacheRecheck {
+			var n int
+			if cov, n, e = c.fill(req, cov, ok, cov.To, time.Now()); e != nil {
+				return nil, "", e
+			}
+			if n > 0 {
+				status = "PARTIAL"
+			}
+		}
+
+		if ok {
+			lines, e = c.read(key, cov.From, time.Now())
+			if e != nil {
+				return nil, "", e
+			}
+		}
+		if len(lines) < req.Last {
+			// Not enough history, fetch it all
+			status = "MISS"
+			oldest, newest, n, e := c.fetch(req, req.lastCmd(req.Last))
+			if e != nil {
+				return nil, "", e
+			}
+			if n == 0 {
+				// nothing upstream (and nothing to remember)
+				c.Misses.Add(1)
+				return nil, status, nil
+			}
+			// only extend when contiguous with what we had
+			if !ok || cov.To.Before(oldest) || oldest.Before(cov.From) {
+				cov.From = oldest
+			}
+			if !ok || newest.After(cov.To) {
+				cov.To = newest
+			}
+			if lines, e = c.read(key, cov.From, time.Now()); e != nil {
+				return nil, "", e
+			}
+		}
+		if status != "HIT" {
+			cov.Checked = time.Now()
+			if e := c.storeCoverage(key, cov); e != nil {
+				return nil, "", e
+			}
+		}
+		if len(lines) > req.Last {
+			lines = lines[len(lines)-req.Last:]
+		}
+
+	} else {
+		// Range, only fetch what's outside the coverage
+		if !ok {
+			status = "MISS"
+			if cov, _, e = c.fill(req, cov, ok, req.From, req.To); e != nil {
+				return nil, "", e
+			}
+		} else {
+			if req.From.Before(cov.From) {
+				status = "PARTIAL"
+				if cov, _, e = c.fill(req, cov, ok, req.From, cov.From); e != nil {
+					return nil, "", e
+				}
+			}
+			if req.To.After(cov.To) {
+				status = "PARTIAL"
+				if cov, _, e = c.fill(req, cov, ok, cov.To, req.To); e != nil {
+					return nil, "", e
+				}
+			}
+		}
+
+		if lines, e = c.read(key, req.From, req.To); e != nil {
+			return nil, "", e
+		}
+		if req.Max > 0 && len(lines) > req.Max {
+			// MaxDatapoints keeps the newest
+			lines = lines[len(lines)-req.Max:]
+		}
+	}
+
+	switch status {
+	case "HIT":
+		c.Hits.Add(1)
+	case "PARTIAL":
+		c.Partial.Add(1)
+	default:
+		c.Misses.Add(1)
+	}
+	if Verbose {
+		slog.Info("cache(Get)", "key", string(key), "status", status, "lines", len(lines))
+	}
+
+	if !req.Oldest {
+		// IQFeed default is newest-first
+		for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+	}
+	return lines, status, nil
+}
+
+// cachedProxy calls cb on every line of creq from cache, or proxies
+// cmd when the request can't be cached (creq=nil) or asks for ?cache=bypass
+func cachedProxy(w http.ResponseWriter, r *http.Request, creq *CacheReq, cmd []byte, lineLimit int, cb LineFunc) error {
+	if r.URL.Query().Get("cache") == "bypass" {
+		creq = nil
+	}
+	status, e := cachedLines(creq, cmd, lineLimit, cb)
+	if status != "" {
+		w.Header().Set("X-Cache", status)
+	}
+	return e
+}
+
+// cachedLines calls cb on every line of creq from cache, or proxies cmd
+// when creq=nil, returns the cache status (HIT|PARTIAL|MISS|BYPASS)
+func cachedLines(creq *CacheReq, cmd []byte, lineLimit int, cb LineFunc) (string, error) {
+	if creq == nil || barCache == nil {
+		return "BYPASS", proxy(cmd, lineLimit, cb)
+	}
+
+	lines, status, e := barCache.Get(creq)
+	if e != nil {
+		return "", e
+	}
+	if len(lines) == 0 {
+		return status, fmt.Errorf("!NO_DATA!")
+	}
+	for _, line := range lines {
+		if e := cb(line); e != nil {
+			return status, e
+		}
+	}
+	return status, nil
+}
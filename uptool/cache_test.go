@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBarCacheHit(t *testing.T) {
+	if e := BarCacheInit(filepath.Join(t.TempDir(), "bars.db")); e != nil {
+		t.Fatalf("BarCacheInit e=%s", e.Error())
+	}
+	defer func() {
+		barCache.db.Close()
+		barCache = nil
+	}()
+
+	req := &CacheReq{Asset: "AAPL"}
+	lines := []string{
+		"LH,2024-01-02,187.1500,183.8900,187.1500,185.6400,82488700,0,",
+		"LH,2024-01-03,185.8800,183.4300,184.2200,184.2500,58414500,0,",
+		"LH,2024-01-04,183.0900,180.8800,182.1500,181.9100,71983600,0,",
+	}
+	if e := barCache.db.Update(func(tx *bolt.Tx) error {
+		b, e := tx.CreateBucketIfNotExists(req.key())
+		if e != nil {
+			return e
+		}
+		for _, line := range lines {
+			ts, e := req.ts([]byte(line))
+			if e != nil {
+				return e
+			}
+			if e := b.Put(tsKey(ts), []byte(line)); e != nil {
+				return e
+			}
+		}
+		return nil
+	}); e != nil {
+		t.Fatalf("Update e=%s", e.Error())
+	}
+	cov := coverage{
+		From: time.Date(2024, 1, 1, 0, 0, 0, 0, exchangeTZ),
+		To:   time.Date(2024, 1, 5, 0, 0, 0, 0, exchangeTZ),
+	}
+	if e := barCache.storeCoverage(req.key(), cov); e != nil {
+		t.Fatalf("storeCoverage e=%s", e.Error())
+	}
+
+	// Inside coverage, nothing upstream
+	req.From = time.Date(2024, 1, 3, 0, 0, 0, 0, exchangeTZ)
+	req.To = time.Date(2024, 1, 4, 0, 0, 0, 0, exchangeTZ)
+	res, status, e := barCache.Get(req)
+	if e != nil {
+		t.Fatalf("Get e=%s", e.Error())
+	}
+	if status != "HIT" || len(res) != 2 {
+		t.Fatalf("Get status=%s lines=%d", status, len(res))
+	}
+	// newest-first by default
+	if string(res[0]) != lines[2] || string(res[1]) != lines[1] {
+		t.Errorf("Get order=%s", res)
+	}
+	if barCache.Hits.Load() != 1 {
+		t.Errorf("Hits=%d", barCache.Hits.Load())
+	}
+}
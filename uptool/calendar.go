@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// Exchange is the trading calendar of one exchange, times are HH:MM in Timezone
+type Exchange struct {
+	Timezone    string            `json:"timezone"`
+	Pre         string            `json:"pre"`   // pre-market open
+	Open        string            `json:"open"`  // regular open
+	Close       string            `json:"close"` // regular close
+	Post        string            `json:"post"`  // post-market close
+	Holidays    []string          `json:"holidays"`
+	EarlyCloses map[string]string `json:"earlyCloses"` // date => regular close
+
+	loc                    *time.Location
+	pre, open, close, post time.Duration
+	holidays               map[string]struct{}
+	early                  map[string]time.Duration
+}
+
+// parseClock reads HH:MM into the duration since midnight
+func parseClock(val string) (time.Duration, error) {
+	t, e := time.Parse("15:04", val)
+	if e != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", val)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// init validates the config and fills the parsed fields
+func (x *Exchange) init() error {
+	var e error
+	if x.loc, e = time.LoadLocation(x.Timezone); e != nil {
+		return fmt.Errorf("timezone: %w", e)
+	}
+	for _, v := range []struct {
+		dst *time.Duration
+		val string
+	}{{&x.pre, x.Pre}, {&x.open, x.Open}, {&x.close, x.Close}, {&x.post, x.Post}} {
+		if *v.dst, e = parseClock(v.val); e != nil {
+			return e
+		}
+	}
+	if !(x.pre <= x.open && x.open < x.close && x.close <= x.post) {
+		return fmt.Errorf("expected pre <= open < close <= post")
+	}
+
+	x.holidays = make(map[string]struct{}, len(x.Holidays))
+	for _, day := range x.Holidays {
+		if _, e := time.Parse("2006-01-02", day); e != nil {
+			return fmt.Errorf("holiday %q, expected YYYY-MM-DD", day)
+		}
+		x.holidays[day] = struct{}{}
+	}
+	x.early = make(map[string]time.Duration, len(x.EarlyCloses))
+	for day, val := range x.EarlyCloses {
+		if _, e := time.Parse("2006-01-02", day); e != nil {
+			return fmt.Errorf("earlyClose %q, expected YYYY-MM-DD", day)
+		}
+		if x.early[day], e = parseClock(val); e != nil {
+			return fmt.Errorf("earlyClose %s: %w", day, e)
+		}
+	}
+	return nil
+}
+
+// TradingDay returns if the exchange is open on the day of t
+func (x *Exchange) TradingDay(t time.Time) bool {
+	t = t.In(x.loc)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	_, ok := x.holidays[t.Format("2006-01-02")]
+	return !ok
+}
+
+// Hours returns the rth (regular) or eth (pre+regular+post) session
+// on the day of t, ok=false when the exchange is closed that day
+func (x *Exchange) Hours(t time.Time, session string) (open, close time.Time, ok bool) {
+	t = t.In(x.loc)
+	if !x.TradingDay(t) {
+		return open, close, false
+	}
+
+	openAt, closeAt := x.open, x.close
+	if session == "eth" {
+		openAt, closeAt = x.pre, x.post
+	}
+	if early, ok := x.early[t.Format("2006-01-02")]; ok {
+		// post-market shifts along with the early close
+		closeAt = early + (closeAt - x.close)
+	}
+	return at(t, openAt), at(t, closeAt), true
+}
+
+// Length returns the duration of a regular (non-early close) session
+func (x *Exchange) Length(session string) time.Duration {
+	if session == "eth" {
+		return x.post - x.pre
+	}
+	return x.close - x.open
+}
+
+// FilterTimes returns the regular session as HHmmSS Begin/EndFilterTime
+func (x *Exchange) FilterTimes(session string) (string, string) {
+	day := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if session == "eth" {
+		return at(day, x.pre).Format("150405"), at(day, x.post).Format("150405")
+	}
+	return at(day, x.open).Format("150405"), at(day, x.close).Format("150405")
+}
+
+// TradingDaysBack returns the midnight of the n-th trading day counting back from now,
+// the day of now only counts once the exchange opened (no bars before the open)
+func (x *Exchange) TradingDaysBack(n int, now time.Time) time.Time {
+	day := at(now.In(x.loc), 0)
+	if open, _, ok := x.Hours(now, "rth"); ok && now.Before(open) {
+		y, m, d := day.Date()
+		day = time.Date(y, m, d-1, 0, 0, 0, 0, x.loc)
+	}
+	for {
+		if x.TradingDay(day) {
+			n--
+			if n <= 0 {
+				return day
+			}
+		}
+		y, m, d := day.Date()
+		day = time.Date(y, m, d-1, 0, 0, 0, 0, x.loc)
+	}
+}
+
+// Calendar holds the exchanges from the calendar file
+type Calendar struct {
+	Default   string               `json:"default"`
+	Exchanges map[string]*Exchange `json:"exchanges"`
+}
+
+var calendar = defaultCalendar()
+
+// defaultCalendar is used without calendar file, NYSE hours without holidays
+func defaultCalendar() *Calendar {
+	c := &Calendar{Default: "NYSE", Exchanges: map[string]*Exchange{
+		"NYSE": {Timezone: "America/New_York", Pre: "04:00", Open: "09:30", Close: "16:00", Post: "20:00"},
+	}}
+	if e := c.init(); e != nil {
+		panic("DevErr: " + e.Error())
+	}
+	return c
+}
+
+// init validates all exchanges
+func (c *Calendar) init() error {
+	if len(c.Exchanges) == 0 {
+		return fmt.Errorf("no exchanges")
+	}
+	if _, ok := c.Exchanges[c.Default]; !ok {
+		return fmt.Errorf("default exchange %q not found", c.Default)
+	}
+	for name, x := range c.Exchanges {
+		if e := x.init(); e != nil {
+			return fmt.Errorf("exchange %s: %w", name, e)
+		}
+	}
+	return nil
+}
+
+// Exchange returns the exchange by name (empty=default)
+func (c *Calendar) Exchange(name string) (*Exchange, bool) {
+	if name == "" {
+		name = c.Default
+	}
+	x, ok := c.Exchanges[name]
+	return x, ok
+}
+
+// Names returns all exchange names sorted
+func (c *Calendar) Names() []string {
+	var out []string
+	for name := range c.Exchanges {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// loadCalendar reads a JSON calendar
+func loadCalendar(r io.Reader) (*Calendar, error) {
+	c := new(Calendar)
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if e := dec.Decode(c); e != nil {
+		return nil, e
+	}
+	if e := c.init(); e != nil {
+		return nil, e
+	}
+	return c, nil
+}
+
+// CalendarInit loads the calendar at path, a missing file keeps the default
+func CalendarInit(path string) error {
+	f, e := os.Open(path)
+	if errors.Is(e, fs.ErrNotExist) {
+		slog.Warn("calendar(Init) file not found, using NYSE hours without holidays", "path", path)
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	c, e := loadCalendar(f)
+	if e != nil {
+		return fmt.Errorf("%s: %w", path, e)
+	}
+	calendar = c
+	return nil
+}
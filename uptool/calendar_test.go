@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar(t *testing.T) {
+	f, e := os.Open("../contrib/calendar.json")
+	if e != nil {
+		t.Fatalf("open e=%s", e.Error())
+	}
+	defer f.Close()
+	c, e := loadCalendar(f)
+	if e != nil {
+		t.Fatalf("loadCalendar e=%s", e.Error())
+	}
+	x, ok := c.Exchange("")
+	if !ok {
+		t.Fatalf("default exchange missing")
+	}
+
+	// Thanksgiving and the early close after it
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 12, 0, 0, 0, exchangeTZ) }
+	if x.TradingDay(day(2026, 11, 26)) || x.TradingDay(day(2026, 11, 28)) || !x.TradingDay(day(2026, 11, 27)) {
+		t.Errorf("TradingDay around thanksgiving")
+	}
+	open, close, ok := x.Hours(day(2026, 11, 27), "rth")
+	if !ok || open.Hour() != 9 || open.Minute() != 30 || close.Hour() != 13 {
+		t.Errorf("Hours(rth) early close=%s-%s", open, close)
+	}
+	if _, close, _ := x.Hours(day(2026, 11, 27), "eth"); close.Hour() != 17 {
+		t.Errorf("Hours(eth) early close=%s", close)
+	}
+
+	// Mon 2026-11-30 back 3 trading days skips weekend and Thanksgiving
+	if from := x.TradingDaysBack(3, day(2026, 11, 30)); !from.Equal(time.Date(2026, 11, 25, 0, 0, 0, 0, exchangeTZ)) {
+		t.Errorf("TradingDaysBack=%s", from)
+	}
+	// Before the open today has no bars yet, also on the early close day
+	if from := x.TradingDaysBack(1, time.Date(2026, 11, 30, 8, 0, 0, 0, exchangeTZ)); !from.Equal(time.Date(2026, 11, 27, 0, 0, 0, 0, exchangeTZ)) {
+		t.Errorf("TradingDaysBack pre-open=%s", from)
+	}
+	if from := x.TradingDaysBack(1, time.Date(2026, 11, 27, 8, 0, 0, 0, exchangeTZ)); !from.Equal(time.Date(2026, 11, 25, 0, 0, 0, 0, exchangeTZ)) {
+		t.Errorf("TradingDaysBack pre-open early close=%s", from)
+	}
+	if from := x.TradingDaysBack(1, time.Date(2026, 11, 27, 9, 30, 0, 0, exchangeTZ)); !from.Equal(time.Date(2026, 11, 27, 0, 0, 0, 0, exchangeTZ)) {
+		t.Errorf("TradingDaysBack at open=%s", from)
+	}
+
+	// Bars on a holiday are skipped, the early close shortens the last bar
+	s := Session{Name: "rth", Exchange: x}
+	if _, ok := s.bucket(day(2026, 11, 26), time.Hour); ok {
+		t.Errorf("bucket on holiday")
+	}
+	if end, ok := s.bucket(time.Date(2026, 11, 27, 12, 45, 0, 0, exchangeTZ), 2*time.Hour); !ok || end.Hour() != 13 || end.Minute() != 0 {
+		t.Errorf("bucket early close=%s", end)
+	}
+
+	if _, e := loadCalendar(strings.NewReader(`{"default":"X","exchanges":{"X":{"timezone":"America/New_York","pre":"09:30","open":"09:30","close":"09:00","post":"20:00"}}}`)); e == nil {
+		t.Errorf("loadCalendar accepted close before open")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// optionMonths are the CEO month codes, calls A-L and puts M-X
+const (
+	callMonths = "ABCDEFGHIJKL"
+	putMonths  = "MNOPQRSTUVWX"
+)
+
+// futureMonths are the CFU/CFO/CFS month codes Jan..Dec
+const futureMonths = "FGHJKMNQUVXZ"
+
+var (
+	// AAPL2417A190 = AAPL 2024-01-17 call 190
+	reOption = regexp.MustCompile(`^(.+?)(\d{2})(\d{2})([A-X])(\d+(?:\.\d+)?)$`)
+	// @ESM24C5000 = @ES June 2024 call 5000
+	reFutureOption = regexp.MustCompile(`^(.+?)([FGHJKMNQUVXZ])(\d{2})([CP])(\d+(?:\.\d+)?)$`)
+	// @ESM24 = @ES June 2024
+	reFuture = regexp.MustCompile(`^(.+?)([FGHJKMNQUVXZ])(\d{2})$`)
+)
+
+// Contract is a decoded chain symbol
+type Contract struct {
+	Symbol string
+	Root   string   `json:",omitempty"`
+	Expiry string   `json:",omitempty"` // YYYY-MM-DD (options) or YYYY-MM (futures)
+	Right  string   `json:",omitempty"` // C|P
+	Strike float64  `json:",omitempty"`
+	Legs   []string `json:",omitempty"` // spreads
+}
+
+func (c Contract) CSVHeader() []string {
+	return []string{"Symbol", "Root", "Expiry", "Right", "Strike", "Legs"}
+}
+func (c Contract) CSVRecord() []string {
+	strike := ""
+	if c.Strike != 0 {
+		strike = formatFloat(c.Strike)
+	}
+	return []string{c.Symbol, c.Root, c.Expiry, c.Right, strike, strings.Join(c.Legs, "-")}
+}
+
+// decodeOption decodes an equity option symbol (CEO)
+func decodeOption(sym string) (Contract, error) {
+	c := Contract{Symbol: sym}
+	m := reOption.FindStringSubmatch(sym)
+	if m == nil {
+		return c, fmt.Errorf("invalid option symbol=%s", sym)
+	}
+	c.Root = m[1]
+
+	idx := strings.IndexByte(callMonths, m[4][0])
+	c.Right = "C"
+	if idx == -1 {
+		idx = strings.IndexByte(putMonths, m[4][0])
+		c.Right = "P"
+	}
+	expiry, e := time.Parse("06-1-02", fmt.Sprintf("%s-%d-%s", m[2], idx+1, m[3]))
+	if e != nil {
+		return c, fmt.Errorf("invalid option expiry=%s: %w", sym, e)
+	}
+	c.Expiry = expiry.Format("2006-01-02")
+	if c.Strike, e = strconv.ParseFloat(m[5], 64); e != nil {
+		return c, fmt.Errorf("invalid option strike=%s: %w", sym, e)
+	}
+	return c, nil
+}
+
+// futureExpiry returns YYYY-MM of a future month code and 2-digit year
+func futureExpiry(month, year string) string {
+	return fmt.Sprintf("20%s-%02d", year, strings.Index(futureMonths, month)+1)
+}
+
+// decodeFuture decodes a future symbol (CFU)
+func decodeFuture(sym string) (Contract, error) {
+	c := Contract{Symbol: sym}
+	m := reFuture.FindStringSubmatch(sym)
+	if m == nil {
+		return c, fmt.Errorf("invalid future symbol=%s", sym)
+	}
+	c.Root = m[1]
+	c.Expiry = futureExpiry(m[2], m[3])
+	return c, nil
+}
+
+// decodeFutureOption decodes a future option symbol (CFO)
+func decodeFutureOption(sym string) (Contract, error) {
+	c := Contract{Symbol: sym}
+	m := reFutureOption.FindStringSubmatch(sym)
+	if m == nil {
+		return c, fmt.Errorf("invalid future option symbol=%s", sym)
+	}
+	c.Root = m[1]
+	c.Expiry = futureExpiry(m[2], m[3])
+	c.Right = m[4]
+	var e error
+	if c.Strike, e = strconv.ParseFloat(m[5], 64); e != nil {
+		return c, fmt.Errorf("invalid future option strike=%s: %w", sym, e)
+	}
+	return c, nil
+}
+
+// decodeSpread decodes a future spread symbol (CFS), @ESM24-@ESU24
+func decodeSpread(sym string) (Contract, error) {
+	c := Contract{Symbol: sym, Legs: strings.Split(sym, "-")}
+	if len(c.Legs) < 2 {
+		return c, fmt.Errorf("invalid spread symbol=%s", sym)
+	}
+	if leg, e := decodeFuture(c.Legs[0]); e == nil {
+		c.Root = leg.Root
+		c.Expiry = leg.Expiry
+	}
+	return c, nil
+}
+
+// chainSymbols splits a chain reply into symbols
+// LC,AAPL2417A190:AAPL2417A195:...:,
+func chainSymbols(bin []byte) []string {
+	bin = bytes.TrimPrefix(bin, []byte("LC,"))
+	var out []string
+	for _, sym := range bytes.FieldsFunc(bin, func(r rune) bool { return r == ':' || r == ',' }) {
+		if sym := strings.TrimSpace(string(sym)); sym != "" {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// monthCodes converts months (1-12 or codes) into month codes of alphabet
+func monthCodes(vals []string, alphabet string) (string, error) {
+	var out strings.Builder
+	for _, val := range vals {
+		if n, e := strconv.Atoi(val); e == nil {
+			if n < 1 || n > 12 {
+				return "", fmt.Errorf("month %d out of range 1..12", n)
+			}
+			out.WriteByte(alphabet[n-1])
+			continue
+		}
+		if len(val) != 1 || !strings.Contains(alphabet, strings.ToUpper(val)) {
+			return "", fmt.Errorf("month %q invalid, expected 1..12 or one of %s", val, alphabet)
+		}
+		out.WriteString(strings.ToUpper(val))
+	}
+	return out.String(), nil
+}
+
+// yearCodes converts years (2024 or 24 or 4) into the last digits IQFeed expects
+func yearCodes(vals []string) (string, error) {
+	var out strings.Builder
+	for _, val := range vals {
+		if _, e := strconv.Atoi(val); e != nil || len(val) == 0 {
+			return "", fmt.Errorf("year %q invalid", val)
+		}
+		out.WriteByte(val[len(val)-1])
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeContracts(t *testing.T) {
+	c, e := decodeOption("AAPL2417A190")
+	if e != nil || c.Root != "AAPL" || c.Expiry != "2024-01-17" || c.Right != "C" || c.Strike != 190 {
+		t.Errorf("decodeOption(call)=%+v e=%v", c, e)
+	}
+	c, e = decodeOption("SPY2420X512.5")
+	if e != nil || c.Root != "SPY" || c.Expiry != "2024-12-20" || c.Right != "P" || c.Strike != 512.5 {
+		t.Errorf("decodeOption(put)=%+v e=%v", c, e)
+	}
+	if _, e := decodeOption("AAPL"); e == nil {
+		t.Errorf("decodeOption accepted AAPL")
+	}
+
+	c, e = decodeFuture("@ESM24")
+	if e != nil || c.Root != "@ES" || c.Expiry != "2024-06" {
+		t.Errorf("decodeFuture=%+v e=%v", c, e)
+	}
+	c, e = decodeFutureOption("@ESM24P5000")
+	if e != nil || c.Root != "@ES" || c.Expiry != "2024-06" || c.Right != "P" || c.Strike != 5000 {
+		t.Errorf("decodeFutureOption=%+v e=%v", c, e)
+	}
+	c, e = decodeSpread("@ESM24-@ESU24")
+	if e != nil || len(c.Legs) != 2 || c.Root != "@ES" || c.Expiry != "2024-06" {
+		t.Errorf("decodeSpread=%+v e=%v", c, e)
+	}
+
+	syms := chainSymbols([]byte("LC,@ESH24:@ESM24:@ESU24:,"))
+	if len(syms) != 3 || syms[2] != "@ESU24" {
+		t.Errorf("chainSymbols=%v", syms)
+	}
+
+	if codes, e := monthCodes([]string{"3", "M"}, futureMonths); e != nil || codes != "HM" {
+		t.Errorf("monthCodes=%s e=%v", codes, e)
+	}
+	if _, e := monthCodes([]string{"13"}, futureMonths); e == nil {
+		t.Errorf("monthCodes accepted 13")
+	}
+	if years, e := yearCodes([]string{"2024", "25"}); e != nil || years != "45" {
+		t.Errorf("yearCodes=%s e=%v", years, e)
+	}
+}
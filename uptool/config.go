@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything that used to be hardcoded in main, loaded from
+// a YAML file with IQAPI_* environment overrides
+type Config struct {
+	Listen struct {
+		HTTP   string `yaml:"http"`   // :8080
+		Lookup string `yaml:"lookup"` // :9101
+		Level1 string `yaml:"level1"` // :5010
+	} `yaml:"listen"`
+	Upstream struct {
+		Lookup string `yaml:"lookup"` // 127.0.0.1:9100
+		Level1 string `yaml:"level1"` // 127.0.0.1:5009
+		Level2 string `yaml:"level2"` // 127.0.0.1:9200
+		Admin  string `yaml:"admin"`  // 127.0.0.1:9300
+	} `yaml:"upstream"`
+	DeadlineCmd   time.Duration `yaml:"deadlineCmd"`
+	MaxDatapoints int           `yaml:"maxDatapoints"`
+	Pool          struct {
+		MaxReuse  int           `yaml:"maxReuse"`
+		KeepAlive time.Duration `yaml:"keepAlive"`
+	} `yaml:"pool"`
+
+	Admin struct {
+		Token string `yaml:"token"` // Bearer token of /admin, empty disables it
+	} `yaml:"admin"`
+
+	// Cmds are the supervised processes, ${VAR} in cmd/args is read from the environment
+	Cmds map[string]CmdInfo `yaml:"cmds"`
+}
+
+// DefaultConfig is the Docker image setup
+func DefaultConfig() *Config {
+	c := new(Config)
+	c.Listen.HTTP = ":8080"
+	c.Listen.Lookup = ":9101"
+	c.Listen.Level1 = ":5010"
+	u := DefaultUpstream()
+	c.Upstream.Lookup, c.Upstream.Level1, c.Upstream.Level2, c.Upstream.Admin = u.Lookup, u.Level1, u.Level2, u.Admin
+	c.DeadlineCmd = deadlineCmd
+	c.MaxDatapoints = MaxDatapoints
+	c.Pool.MaxReuse = PoolMaxReuse
+	c.Pool.KeepAlive = PoolKeepAlive
+	c.Cmds = map[string]CmdInfo{
+		"xvfb": CmdInfo{Dep: "", Cmd: "/usr/bin/Xvfb", Args: []string{":0", "-screen", "0", "1024x768x24", "-noreset"}, Ready: &Probe{X11: ":0"}},
+		"iqfeed": CmdInfo{Dep: "xvfb", Cmd: "wine64", Args: []string{
+			"/home/wine/.wine/drive_c/Program Files/DTN/IQFeed/iqconnect.exe",
+			"-product", "${PROD}",
+			"-version", "IQFEED_LAUNCHER",
+			"-login", "${LOGIN}",
+			"-password", "${PASS}",
+			"-autoconnect",
+		}, Ready: &Probe{TCP: "127.0.0.1:9300", Timeout: 2 * time.Minute},
+			LogFormat: "iqconnect",
+			CrashLog:  CrashLog{Dir: "/home/wine/crash", Files: []string{"/home/wine/.wine/drive_c/users/wine/Documents/DTN/IQFeed/IQConnectLog.txt"}}},
+	}
+	return c
+}
+
+// loadConfig reads r on top of the defaults, unknown keys are an error
+func loadConfig(r io.Reader) (*Config, error) {
+	c := DefaultConfig()
+	defaults := c.Cmds
+	// cmds replace the defaults instead of merging into them
+	c.Cmds = nil
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if e := dec.Decode(c); e != nil && e != io.EOF {
+		return nil, e
+	}
+	if c.Cmds == nil {
+		c.Cmds = defaults
+	}
+	return c, nil
+}
+
+// LoadConfig reads path (a missing file keeps the defaults), applies the
+// environment overrides and validates the result
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	bin, e := os.ReadFile(path)
+	if errors.Is(e, fs.ErrNotExist) {
+		slog.Warn("config(Load) file not found, using defaults", "path", path)
+	} else if e != nil {
+		return nil, e
+	} else if c, e = loadConfig(bytes.NewReader(bin)); e != nil {
+		return nil, fmt.Errorf("%s: %w", path, e)
+	}
+
+	if e := c.applyEnv(os.LookupEnv); e != nil {
+		return nil, e
+	}
+	if e := c.Validate(); e != nil {
+		return nil, e
+	}
+	return c, nil
+}
+
+// applyEnv overrides the scalar settings with IQAPI_* environment variables
+func (c *Config) applyEnv(lookup func(key string) (string, bool)) error {
+	for key, dst := range map[string]*string{
+		"IQAPI_LISTEN_HTTP":     &c.Listen.HTTP,
+		"IQAPI_LISTEN_LOOKUP":   &c.Listen.Lookup,
+		"IQAPI_LISTEN_LEVEL1":   &c.Listen.Level1,
+		"IQAPI_UPSTREAM_LOOKUP": &c.Upstream.Lookup,
+		"IQAPI_UPSTREAM_LEVEL1": &c.Upstream.Level1,
+		"IQAPI_UPSTREAM_LEVEL2": &c.Upstream.Level2,
+		"IQAPI_UPSTREAM_ADMIN":  &c.Upstream.Admin,
+		"IQAPI_ADMIN_TOKEN":     &c.Admin.Token,
+	} {
+		if val, ok := lookup(key); ok {
+			*dst = val
+		}
+	}
+	for key, dst := range map[string]*time.Duration{
+		"IQAPI_DEADLINE_CMD":   &c.DeadlineCmd,
+		"IQAPI_POOL_KEEPALIVE": &c.Pool.KeepAlive,
+	} {
+		if val, ok := lookup(key); ok {
+			d, e := time.ParseDuration(val)
+			if e != nil {
+				return fmt.Errorf("env.%s invalid duration: %w", key, e)
+			}
+			*dst = d
+		}
+	}
+	for key, dst := range map[string]*int{
+		"IQAPI_MAX_DATAPOINTS": &c.MaxDatapoints,
+		"IQAPI_POOL_MAX_REUSE": &c.Pool.MaxReuse,
+	} {
+		if val, ok := lookup(key); ok {
+			n, e := strconv.Atoi(val)
+			if e != nil {
+				return fmt.Errorf("env.%s not a number", key)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// Validate checks all settings and the dependencies between the cmds
+func (c *Config) Validate() error {
+	var errs []error
+	for name, addr := range map[string]string{
+		"listen.http":     c.Listen.HTTP,
+		"listen.lookup":   c.Listen.Lookup,
+		"listen.level1":   c.Listen.Level1,
+		"upstream.lookup": c.Upstream.Lookup,
+		"upstream.level1": c.Upstream.Level1,
+		"upstream.level2": c.Upstream.Level2,
+		"upstream.admin":  c.Upstream.Admin,
+	} {
+		if _, _, e := net.SplitHostPort(addr); e != nil {
+			errs = append(errs, fmt.Errorf("%s=%q invalid, expected host:port", name, addr))
+		}
+	}
+	for name, d := range map[string]time.Duration{"deadlineCmd": c.DeadlineCmd, "pool.keepAlive": c.Pool.KeepAlive} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s=%s must be positive", name, d))
+		}
+	}
+	for name, n := range map[string]int{"maxDatapoints": c.MaxDatapoints, "pool.maxReuse": c.Pool.MaxReuse} {
+		if n <= 0 {
+			errs = append(errs, fmt.Errorf("%s=%d must be positive", name, n))
+		}
+	}
+
+	if c.Admin.Token != "" && len(c.Admin.Token) < 16 {
+		errs = append(errs, fmt.Errorf("admin.token too short, use at least 16 chars"))
+	}
+
+	names := make([]string, 0, len(c.Cmds))
+	for name := range c.Cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := c.Cmds[name]
+		if info.Cmd == "" {
+			errs = append(errs, fmt.Errorf("cmds.%s.cmd missing", name))
+		}
+		if _, ok := c.Cmds[info.Dep]; info.Dep != "" && !ok {
+			errs = append(errs, fmt.Errorf("cmds.%s.dep=%s unknown, possible=%s", name, info.Dep, strings.Join(names, "|")))
+		}
+		errs = append(errs, info.Ready.validate("cmds."+name+".ready")...)
+		errs = append(errs, info.Restart.validate("cmds."+name+".restart")...)
+		if info.LogFormat != "" && info.LogFormat != "iqconnect" {
+			errs = append(errs, fmt.Errorf("cmds.%s.logFormat=%s unknown, possible=iqconnect", name, info.LogFormat))
+		}
+		if info.CrashLog.Keep < 0 {
+			errs = append(errs, fmt.Errorf("cmds.%s.crashLog.keep=%d must be positive", name, info.CrashLog.Keep))
+		}
+	}
+	if cycle := c.depCycle(names); cycle != nil {
+		errs = append(errs, fmt.Errorf("cmds dependency cycle %s", strings.Join(cycle, " > ")))
+	}
+	return errors.Join(errs...)
+}
+
+// depCycle returns the first dependency cycle (a > b > a) or nil
+func (c *Config) depCycle(names []string) []string {
+	for _, start := range names {
+		seen := map[string]struct{}{}
+		path := []string{}
+		for name := start; name != ""; name = c.Cmds[name].Dep {
+			if _, ok := seen[name]; ok {
+				// drop the path that leads into the cycle
+				for i := range path {
+					if path[i] == name {
+						return append(path[i:], name)
+					}
+				}
+			}
+			if _, ok := c.Cmds[name]; !ok {
+				// unknown dep, reported by Validate
+				break
+			}
+			seen[name] = struct{}{}
+			path = append(path, name)
+		}
+	}
+	return nil
+}
+
+// Commands returns the cmds with ${VAR} replaced, unset variables are an error
+func (c *Config) Commands() (map[string]CmdInfo, error) {
+	var errs []error
+	expand := func(name, val string) string {
+		return os.Expand(val, func(key string) string {
+			v, ok := os.LookupEnv(key)
+			if !ok || v == "" {
+				errs = append(errs, fmt.Errorf("Missing env.%s (used by cmds.%s)", key, name))
+			}
+			return v
+		})
+	}
+
+	out := make(map[string]CmdInfo, len(c.Cmds))
+	for name, info := range c.Cmds {
+		info.Cmd = expand(name, info.Cmd)
+		info.PostCmd = expand(name, info.PostCmd)
+		info.Args = append([]string{}, info.Args...)
+		for i := range info.Args {
+			info.Args[i] = expand(name, info.Args[i])
+		}
+		info.CrashLog.Dir = expand(name, info.CrashLog.Dir)
+		info.CrashLog.Files = append([]string{}, info.CrashLog.Files...)
+		for i := range info.CrashLog.Files {
+			info.CrashLog.Files[i] = expand(name, info.CrashLog.Files[i])
+		}
+		info.PostArgs = append([]string{}, info.PostArgs...)
+		for i := range info.PostArgs {
+			info.PostArgs[i] = expand(name, info.PostArgs[i])
+		}
+		if info.Ready != nil {
+			ready := *info.Ready
+			ready.Cmd = expand(name, ready.Cmd)
+			ready.Args = append([]string{}, ready.Args...)
+			for i := range ready.Args {
+				ready.Args[i] = expand(name, ready.Args[i])
+			}
+			info.Ready = &ready
+		}
+		out[name] = info
+	}
+	return out, errors.Join(errs...)
+}
+
+// Apply sets the package settings
+func (c *Config) Apply() {
+	upstream.Lookup = c.Upstream.Lookup
+	upstream.Level1 = c.Upstream.Level1
+	upstream.Level2 = c.Upstream.Level2
+	upstream.Admin = c.Upstream.Admin
+	deadlineCmd = c.DeadlineCmd
+	MaxDatapoints = c.MaxDatapoints
+	PoolMaxReuse = c.Pool.MaxReuse
+	PoolKeepAlive = c.Pool.KeepAlive
+	AdminToken = c.Admin.Token
+	if AdminToken == "" {
+		slog.Warn("config(Apply) admin.token not set, /admin is disabled")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, e := loadConfig(strings.NewReader(`
+listen:
+  http: ":8081"
+deadlineCmd: 30s
+pool:
+  maxReuse: 10
+cmds:
+  xvfb:
+    cmd: /usr/bin/Xvfb
+    args: [":0", "-screen", "0", "1920x1080x24"]
+    restart:
+      jitter: 0
+`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if c.Listen.HTTP != ":8081" || c.Listen.Lookup != ":9101" || c.DeadlineCmd != 30*time.Second || c.Pool.MaxReuse != 10 {
+		t.Errorf("config=%+v", c)
+	}
+	if _, ok := c.Cmds["iqfeed"]; ok || len(c.Cmds) != 1 || c.Cmds["xvfb"].Args[3] != "1920x1080x24" {
+		t.Errorf("cmds not replaced=%+v", c.Cmds)
+	}
+	if j := c.Cmds["xvfb"].Restart.withDefaults().Jitter; j == nil || *j != 0 {
+		t.Errorf("jitter: 0 replaced by the default")
+	}
+	if e := c.Validate(); e != nil {
+		t.Error(e)
+	}
+
+	env := map[string]string{"IQAPI_LISTEN_LOOKUP": ":9102", "IQAPI_MAX_DATAPOINTS": "500", "IQAPI_POOL_KEEPALIVE": "1m"}
+	if e := c.applyEnv(func(key string) (string, bool) { v, ok := env[key]; return v, ok }); e != nil {
+		t.Fatal(e)
+	}
+	if c.Listen.Lookup != ":9102" || c.MaxDatapoints != 500 || c.Pool.KeepAlive != time.Minute {
+		t.Errorf("env not applied=%+v", c)
+	}
+	env["IQAPI_DEADLINE_CMD"] = "17"
+	if e := c.applyEnv(func(key string) (string, bool) { v, ok := env[key]; return v, ok }); e == nil {
+		t.Errorf("invalid duration accepted")
+	}
+
+	if _, e := loadConfig(strings.NewReader("listen:\n  htp: \":8080\"\n")); e == nil {
+		t.Errorf("unknown key accepted")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if e := DefaultConfig().Validate(); e != nil {
+		t.Errorf("defaults invalid: %s", e)
+	}
+
+	c := DefaultConfig()
+	c.Cmds["iqfeed"] = CmdInfo{Cmd: "wine64", Dep: "xvfbb"}
+	if e := c.Validate(); e == nil || !strings.Contains(e.Error(), "cmds.iqfeed.dep=xvfbb unknown") {
+		t.Errorf("e=%v", e)
+	}
+
+	c = DefaultConfig()
+	c.Cmds["a"] = CmdInfo{Cmd: "a", Dep: "b"}
+	c.Cmds["b"] = CmdInfo{Cmd: "b", Dep: "c"}
+	c.Cmds["c"] = CmdInfo{Cmd: "c", Dep: "b"}
+	if e := c.Validate(); e == nil || !strings.Contains(e.Error(), "cycle b > c > b") {
+		t.Errorf("e=%v", e)
+	}
+
+	c = DefaultConfig()
+	c.Listen.HTTP = "8080"
+	c.MaxDatapoints = 0
+	e := c.Validate()
+	if e == nil || !strings.Contains(e.Error(), "listen.http") || !strings.Contains(e.Error(), "maxDatapoints") {
+		t.Errorf("e=%v", e)
+	}
+}
+
+func TestConfigCommands(t *testing.T) {
+	t.Setenv("PROD", "MYPROD")
+	t.Setenv("LOGIN", "")
+	t.Setenv("PASS", "secret")
+	_, e := DefaultConfig().Commands()
+	if e == nil || !strings.Contains(e.Error(), "Missing env.LOGIN") {
+		t.Errorf("e=%v", e)
+	}
+
+	t.Setenv("LOGIN", "me")
+	cmds, e := DefaultConfig().Commands()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if args := strings.Join(cmds["iqfeed"].Args, " "); !strings.Contains(args, "-product MYPROD") || !strings.Contains(args, "-login me") {
+		t.Errorf("args=%s", args)
+	}
+}
+
+func TestConfigExample(t *testing.T) {
+	f, e := os.Open("../contrib/iqapi.example.yaml")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	c, e := loadConfig(f)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(c, DefaultConfig()) {
+		t.Errorf("example differs from the defaults\nexample=%+v\ndefault=%+v", c, DefaultConfig())
+	}
+}
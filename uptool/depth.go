@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** depthSubBuffer is the amount of msgs a depth subscriber may lag behind before it's dropped */
+const depthSubBuffer = 4096
+
+/** depthSettle is the silence after which we consider the summary burst done */
+const depthSettle = 250 * time.Millisecond
+
+// BookEntry is a single order (WOR) or price level (WPL) in a Book
+type BookEntry struct {
+	OrderID  int64  `json:",omitempty"`
+	MMID     string `json:",omitempty"`
+	Price    float64
+	Size     int64
+	Orders   int64 `json:",omitempty"` // order count of a price level
+	Priority int64 `json:",omitempty"`
+	Time     string
+}
+
+// BookSnapshot is a sorted copy of a Book, best price first
+type BookSnapshot struct {
+	Symbol  string
+	Type    string // WOR=orders, WPL=price levels
+	Bids    []BookEntry
+	Asks    []BookEntry
+	Updated time.Time
+}
+
+// DepthMsg is a single incremental book change
+// Type=snapshot|add|update|delete|reset
+type DepthMsg struct {
+	Type   string
+	Symbol string
+	Side   string        `json:",omitempty"` // A=ask, B=bid
+	Entry  *BookEntry    `json:",omitempty"`
+	Book   *BookSnapshot `json:",omitempty"`
+	Error  string        `json:",omitempty"` // Type=error, last msg of the stream
+}
+
+// Event names the msg for SSE
+func (m DepthMsg) Event() string {
+	return m.Type
+}
+
+// Err returns why the stream ended for Type=error
+func (m DepthMsg) Err() string {
+	return m.Error
+}
+
+// Book is the in-memory book of one symbol, keyed by orderID or price
+type Book struct {
+	symbol  string
+	typ     string
+	bids    map[string]BookEntry
+	asks    map[string]BookEntry
+	updated time.Time
+	ready   chan struct{} // closed on first message
+	live    bool          // received a non-summary msg
+	err     string        // symbol not found
+}
+
+func newBook(typ, symbol string) *Book {
+	return &Book{
+		symbol: symbol,
+		typ:    typ,
+		bids:   make(map[string]BookEntry),
+		asks:   make(map[string]BookEntry),
+		ready:  make(chan struct{}),
+	}
+}
+
+// markReady signals waiting snapshots
+func (b *Book) markReady() {
+	select {
+	case <-b.ready:
+	default:
+		close(b.ready)
+	}
+}
+
+// side returns the entries of side A(sk) or B(id)
+func (b *Book) side(side string) map[string]BookEntry {
+	if side == "A" {
+		return b.asks
+	}
+	return b.bids
+}
+
+// snapshot returns the book sorted best price first
+func (b *Book) snapshot() *BookSnapshot {
+	s := &BookSnapshot{Symbol: b.symbol, Type: b.typ, Updated: b.updated}
+	s.Bids = make([]BookEntry, 0, len(b.bids))
+	for _, entry := range b.bids {
+		s.Bids = append(s.Bids, entry)
+	}
+	s.Asks = make([]BookEntry, 0, len(b.asks))
+	for _, entry := range b.asks {
+		s.Asks = append(s.Asks, entry)
+	}
+	sort.Slice(s.Bids, func(i, j int) bool {
+		if s.Bids[i].Price == s.Bids[j].Price {
+			return s.Bids[i].Priority < s.Bids[j].Priority
+		}
+		return s.Bids[i].Price > s.Bids[j].Price
+	})
+	sort.Slice(s.Asks, func(i, j int) bool {
+		if s.Asks[i].Price == s.Asks[j].Price {
+			return s.Asks[i].Priority < s.Asks[j].Priority
+		}
+		return s.Asks[i].Price < s.Asks[j].Price
+	})
+	return s
+}
+
+// apply processes a single Level 2 msg and returns the change
+//
+// Orders (WOR): 3=add, 4=update, 5=delete, 6=summary
+// [Type],[Symbol],[OrderID],[MMID],[Side],[Price],[Size],[Priority],[Precision],[Time],[Date],
+// Price levels (WPL): 7=summary, 8=update, 9=delete
+// [Type],[Symbol],[Side],[Price],[Size],[OrderCount],[Precision],[Time],[Date],
+func (b *Book) apply(tok []string) (DepthMsg, error) {
+	var (
+		key   string
+		entry BookEntry
+		e     error
+	)
+	msg := DepthMsg{Symbol: b.symbol}
+
+	switch tok[0] {
+	case "3", "4", "5", "6":
+		if len(tok) < 5 || (tok[0] != "5" && len(tok) < 10) {
+			return msg, fmt.Errorf("WARN: Failed parsing order line=%s", strings.Join(tok, ","))
+		}
+		key = tok[2]
+		msg.Side = tok[4]
+		if entry.OrderID, e = parseInt([]byte(tok[2])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing OrderID line=%s", strings.Join(tok, ","))
+		}
+		entry.MMID = tok[3]
+		if tok[0] == "5" {
+			break
+		}
+		if entry.Price, e = parseFloat([]byte(tok[5])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing Price line=%s", strings.Join(tok, ","))
+		}
+		if entry.Size, e = parseInt([]byte(tok[6])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing Size line=%s", strings.Join(tok, ","))
+		}
+		if entry.Priority, e = parseInt([]byte(tok[7])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing Priority line=%s", strings.Join(tok, ","))
+		}
+		entry.Time = tok[9]
+
+	case "7", "8", "9":
+		if len(tok) < 4 || (tok[0] != "9" && len(tok) < 8) {
+			return msg, fmt.Errorf("WARN: Failed parsing level line=%s", strings.Join(tok, ","))
+		}
+		key = tok[3]
+		msg.Side = tok[2]
+		if entry.Price, e = parseFloat([]byte(tok[3])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing Price line=%s", strings.Join(tok, ","))
+		}
+		if tok[0] == "9" {
+			break
+		}
+		if entry.Size, e = parseInt([]byte(tok[4])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing Size line=%s", strings.Join(tok, ","))
+		}
+		if entry.Orders, e = parseInt([]byte(tok[5])); e != nil {
+			return msg, fmt.Errorf("WARN: Failed parsing OrderCount line=%s", strings.Join(tok, ","))
+		}
+		entry.Time = tok[7]
+
+	default:
+		return msg, fmt.Errorf("WARN: Unknown depth msg line=%s", strings.Join(tok, ","))
+	}
+	if msg.Side != "A" && msg.Side != "B" {
+		return msg, fmt.Errorf("WARN: Failed parsing Side line=%s", strings.Join(tok, ","))
+	}
+
+	entries := b.side(msg.Side)
+	switch tok[0] {
+	case "5", "9":
+		msg.Type = "delete"
+		if prev, ok := entries[key]; ok {
+			entry = prev
+		}
+		delete(entries, key)
+	default:
+		msg.Type = "update"
+		if _, ok := entries[key]; !ok {
+			msg.Type = "add"
+		}
+		entries[key] = entry
+	}
+	if tok[0] != "6" && tok[0] != "7" {
+		b.live = true
+	}
+	msg.Entry = &entry
+	b.updated = time.Now()
+	b.markReady()
+	return msg, nil
+}
+
+// DepthSub is a single subscriber of one book, C is closed when dropped
+type DepthSub struct {
+	C      chan DepthMsg
+	key    string
+	closed bool
+}
+
+// Depth keeps one shared upstream conn to the Level 2 port and
+// an in-memory book for every watched symbol.
+type Depth struct {
+	addr string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	queue   []string                          // cmds for the writer, sent outside mu
+	wake    chan struct{}                     // queue not empty
+	watches map[string]int                    // WPL:AAPL => subscriber count
+	books   map[string]*Book                  // WPL:AAPL => book
+	subs    map[string]map[*DepthSub]struct{} // WPL:AAPL => subscribers
+}
+
+var depth *Depth
+
+func newDepth(addr string) *Depth {
+	return &Depth{
+		addr:    addr,
+		wake:    make(chan struct{}, 1),
+		watches: make(map[string]int),
+		books:   make(map[string]*Book),
+		subs:    make(map[string]map[*DepthSub]struct{}),
+	}
+}
+
+// DepthInit starts the shared Level 2 conn in the background
+func DepthInit(addr string) {
+	depth = newDepth(addr)
+	go depth.run()
+}
+
+// depthKey returns the internal key of a watch
+func depthKey(typ, symbol string) string {
+	return typ + ":" + symbol
+}
+
+// write queues cmd for the writer, does nothing when not connected (watches
+// are replayed on connect). Caller must hold d.mu
+func (d *Depth) write(cmd string) {
+	if d.conn == nil {
+		return
+	}
+	d.queue = append(d.queue, cmd)
+	select {
+	case d.wake <- struct{}{}:
+	default:
+		// writer already woken
+	}
+}
+
+// writer sends the queued cmds to conn until stop is closed, a stalled
+// write only blocks the writer and not dispatch
+func (d *Depth) writer(conn net.Conn, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.wake:
+		}
+
+		d.mu.Lock()
+		cmds := d.queue
+		d.queue = nil
+		d.mu.Unlock()
+		for _, cmd := range cmds {
+			if e := conn.SetWriteDeadline(time.Now().Add(deadlineCmd)); e != nil {
+				slog.Error("depth(writer) setDeadline", "e", e.Error())
+				return
+			}
+			if _, e := conn.Write([]byte(cmd + "\r\n")); e != nil {
+				// the reader fails too and session reconnects
+				slog.Error("depth(writer)", "cmd", cmd, "e", e.Error())
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// connect marks conn connected, resets all books (stale after a reconnect)
+// and replays their watches, call stop when the session ends
+func (d *Depth) connect(conn net.Conn) (stop func()) {
+	d.mu.Lock()
+	d.conn = conn
+	d.queue = nil
+	for key, book := range d.books {
+		d.books[key] = newBook(book.typ, book.symbol)
+		for sub := range d.subs[key] {
+			d.send(sub, DepthMsg{Type: "reset", Symbol: book.symbol})
+		}
+		d.write(watchCmd(key))
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go d.writer(conn, done)
+	return func() {
+		close(done)
+		d.mu.Lock()
+		d.conn = nil
+		d.queue = nil
+		d.mu.Unlock()
+	}
+}
+
+// watchCmd returns the upstream watch cmd for key
+func watchCmd(key string) string {
+	typ, symbol, _ := strings.Cut(key, ":")
+	return fmt.Sprintf("%s,%s", typ, symbol)
+}
+
+// unwatchCmd returns the upstream unwatch cmd for key
+func unwatchCmd(key string) string {
+	typ, symbol, _ := strings.Cut(key, ":")
+	if typ == "WOR" {
+		return "ROR," + symbol
+	}
+	return "RPL," + symbol
+}
+
+// Subscribe watches symbol, typ is WOR (orders) or WPL (price levels)
+func (d *Depth) Subscribe(typ, symbol string) *DepthSub {
+	key := depthKey(typ, symbol)
+	sub := &DepthSub{C: make(chan DepthMsg, depthSubBuffer), key: key}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subs[key]; !ok {
+		d.subs[key] = make(map[*DepthSub]struct{})
+	}
+	d.subs[key][sub] = struct{}{}
+	d.watches[key]++
+	if d.watches[key] == 1 {
+		d.books[key] = newBook(typ, symbol)
+		d.write(watchCmd(key))
+	} else if book := d.books[key]; book != nil {
+		// existing book, start with what we have
+		select {
+		case <-book.ready:
+			sub.C <- DepthMsg{Type: "snapshot", Symbol: symbol, Book: book.snapshot()}
+		default:
+		}
+	}
+	return sub
+}
+
+// Unsubscribe removes sub and unwatches the book if nobody is interested anymore
+func (d *Depth) Unsubscribe(sub *DepthSub) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drop(sub)
+}
+
+// drop removes sub, caller must hold d.mu
+func (d *Depth) drop(sub *DepthSub) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.C)
+
+	delete(d.subs[sub.key], sub)
+	d.watches[sub.key]--
+	if d.watches[sub.key] > 0 {
+		return
+	}
+	delete(d.watches, sub.key)
+	delete(d.subs, sub.key)
+	delete(d.books, sub.key)
+	d.write(unwatchCmd(sub.key))
+}
+
+// Snapshot returns the book of sub once the summary arrived or the timeout passed,
+// queued is the amount of msgs in sub.C that are already part of the snapshot
+func (d *Depth) Snapshot(sub *DepthSub, timeout time.Duration) (book *BookSnapshot, queued int, e error) {
+	d.mu.Lock()
+	b := d.books[sub.key]
+	d.mu.Unlock()
+	if b == nil {
+		return nil, 0, fmt.Errorf("book not watched")
+	}
+
+	deadline := time.Now().Add(timeout)
+	select {
+	case <-b.ready:
+	case <-time.After(timeout):
+		return nil, 0, fmt.Errorf("timeout waiting for book")
+	}
+
+	// Summary msgs arrive as a burst, wait until it's done
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for !b.live && time.Since(b.updated) < depthSettle && time.Now().Before(deadline) {
+		d.mu.Unlock()
+		time.Sleep(depthSettle / 5)
+		d.mu.Lock()
+	}
+	if b.err != "" {
+		return nil, 0, fmt.Errorf("%s", b.err)
+	}
+	return b.snapshot(), len(sub.C), nil
+}
+
+// send delivers msg to sub or drops sub when it's lagging, caller must hold d.mu
+func (d *Depth) send(sub *DepthSub, msg DepthMsg) {
+	select {
+	case sub.C <- msg:
+	default:
+		slog.Warn("depth(send) subscriber too slow, dropping", "key", sub.key)
+		d.drop(sub)
+	}
+}
+
+// dispatch handles a single upstream line
+func (d *Depth) dispatch(bin []byte) {
+	tok := strings.Split(strings.TrimSuffix(string(bin), ","), ",")
+	if len(tok) < 2 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch tok[0] {
+	case "3", "4", "5", "6":
+		d.apply(depthKey("WOR", tok[1]), tok)
+	case "7", "8", "9":
+		d.apply(depthKey("WPL", tok[1]), tok)
+	case "n":
+		// n,AAPL
+		for _, typ := range []string{"WOR", "WPL"} {
+			if book := d.books[depthKey(typ, tok[1])]; book != nil {
+				book.err = "symbol not found"
+				book.markReady()
+			}
+		}
+	}
+}
+
+// apply updates the book of key and notifies the subscribers, caller must hold d.mu
+func (d *Depth) apply(key string, tok []string) {
+	book := d.books[key]
+	if book == nil {
+		// msg for something we stopped watching
+		return
+	}
+	msg, e := book.apply(tok)
+	if e != nil {
+		slog.Warn("depth(apply)", "e", e.Error())
+		return
+	}
+	for sub := range d.subs[key] {
+		d.send(sub, msg)
+	}
+}
+
+// session prepares conn, rebuilds all books and reads from it until it fails
+func (d *Depth) session(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	if e := conn.SetWriteDeadline(time.Now().Add(deadlineCmd)); e != nil {
+		return e
+	}
+	for _, cmd := range []string{"S,SET PROTOCOL,6.2", "S,SET CLIENT NAME,IQAPI"} {
+		if _, e := conn.Write([]byte(cmd + "\r\n")); e != nil {
+			return e
+		}
+	}
+
+	// Mark connected, books start over from a fresh summary
+	stop := d.connect(conn)
+	defer stop()
+
+	for {
+		if e := conn.SetReadDeadline(time.Now().Add(l1ReadTimeout)); e != nil {
+			return e
+		}
+		bin, e := r.ReadBytes(byte('\n'))
+		if e != nil {
+			return e
+		}
+		bin = bytes.TrimSpace(bin)
+		if Verbose {
+			slog.Info("depth(session)", "stream", bin)
+		}
+		d.dispatch(bin)
+	}
+}
+
+// run is a blocking func that keeps the upstream conn open
+func (d *Depth) run() {
+	if d.addr == "" {
+		slog.Warn("depth(run) no upstream, not connecting")
+		return
+	}
+	for {
+		// Always delay 1sec so we don't flood upstream on failure
+		time.Sleep(time.Second * 1)
+
+		if e := upstream.Ready(); e != nil {
+			continue
+		}
+
+		conn, e := upstream.Dial(d.addr)
+		if e != nil {
+			slog.Error("depth(run) dial", "e", e.Error())
+			continue
+		}
+		slog.Info("depth(run) connected", "addr", d.addr)
+
+		if e := d.session(conn); e != nil {
+			slog.Error("depth(run) session", "e", e.Error())
+		}
+
+		if e := conn.Close(); e != nil {
+			slog.Error("depth(run) close", "e", e.Error())
+		}
+	}
+}
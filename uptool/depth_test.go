@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBookApply(t *testing.T) {
+	b := newBook("WPL", "AAPL")
+	lines := []string{
+		"7,AAPL,B,187.14,300,3,4,09:30:00.000001,2024-01-02",
+		"7,AAPL,B,187.15,100,1,4,09:30:00.000001,2024-01-02",
+		"7,AAPL,A,187.16,200,2,4,09:30:00.000001,2024-01-02",
+		"8,AAPL,B,187.15,500,2,4,09:30:00.000002,2024-01-02",
+		"9,AAPL,B,187.14",
+	}
+	for _, line := range lines {
+		if _, e := b.apply(strings.Split(line, ",")); e != nil {
+			t.Fatalf("apply(%s) e=%s", line, e.Error())
+		}
+	}
+	if !b.live {
+		t.Errorf("book not live after update")
+	}
+
+	s := b.snapshot()
+	if len(s.Bids) != 1 || s.Bids[0].Price != 187.15 || s.Bids[0].Size != 500 || s.Bids[0].Orders != 2 {
+		t.Errorf("bids=%+v", s.Bids)
+	}
+	if len(s.Asks) != 1 || s.Asks[0].Price != 187.16 {
+		t.Errorf("asks=%+v", s.Asks)
+	}
+
+	if _, e := b.apply(strings.Split("8,AAPL,X,187.15,500,2,4,09:30:00.000002,2024-01-02", ",")); e == nil {
+		t.Errorf("apply accepted invalid side")
+	}
+}
+
+func TestStreamSSEError(t *testing.T) {
+	c := make(chan DepthMsg, 2)
+	c <- DepthMsg{Type: "error", Symbol: "AAPL", Error: "No data: timeout"}
+	c <- DepthMsg{Type: "snapshot", Symbol: "AAPL"}
+
+	res := httptest.NewRecorder()
+	streamSSE(res, httptest.NewRequest("GET", "/depth?asset=AAPL&mode=stream", nil), c)
+	body := res.Body.String()
+	if !strings.Contains(body, "event: error\ndata: ") || !strings.Contains(body, `"Error":"No data: timeout"`) || strings.Contains(body, "snapshot") {
+		t.Errorf("body=%s", body)
+	}
+}
+
+func TestDepthBookInvalidAsset(t *testing.T) {
+	res := httptest.NewRecorder()
+	depthBook(res, httptest.NewRequest("GET", "/depth?asset=AAPL%0D%0ARPL,MSFT", nil))
+	if res.Code != 400 {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+}
+
+func TestDepthWatches(t *testing.T) {
+	up, down := net.Pipe()
+	defer up.Close()
+	defer down.Close()
+
+	d := newDepth("")
+	a := d.Subscribe("WPL", "AAPL")
+	stop := d.connect(up)
+	defer stop()
+	r := bufio.NewReader(down)
+	expect := func(cmd string) {
+		t.Helper()
+		down.SetReadDeadline(time.Now().Add(time.Second))
+		line, e := r.ReadString('\n')
+		if e != nil || line != cmd+"\r\n" {
+			t.Fatalf("expected cmd=%s got=%q e=%v", cmd, line, e)
+		}
+	}
+
+	// replayed on connect
+	expect("WPL,AAPL")
+	if msg := <-a.C; msg.Type != "reset" {
+		t.Errorf("a received=%+v", msg)
+	}
+	b := d.Subscribe("WOR", "MSFT")
+	expect("WOR,MSFT")
+	d.Unsubscribe(b)
+	expect("ROR,MSFT")
+	d.Unsubscribe(a)
+	expect("RPL,AAPL")
+}
+
+func TestDepthStalledWrite(t *testing.T) {
+	// nobody reads down so every write blocks
+	up, down := net.Pipe()
+	defer up.Close()
+	defer down.Close()
+	d := newDepth("")
+	stop := d.connect(up)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a := d.Subscribe("WPL", "AAPL")
+		b := d.Subscribe("WPL", "MSFT")
+		d.dispatch([]byte("7,AAPL,B,187.14,300,3,4,09:30:00.000001,2024-01-02"))
+		d.Unsubscribe(a)
+		d.Unsubscribe(b)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stalled upstream write blocks subscribers")
+	}
+}
@@ -2,28 +2,37 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sync"
 	"time"
 )
 
 /** CmdInfo is the command information to run a binary as child */
 type CmdInfo struct {
-	Cmd  string
-	Args []string
-	Dep  string
+	Cmd  string   `yaml:"cmd"`
+	Args []string `yaml:"args"`
+	Dep  string   `yaml:"dep"`
 
 	// Post-processing
-	PostCmd  string
-	PostArgs []string
+	PostCmd  string   `yaml:"postCmd"`
+	PostArgs []string `yaml:"postArgs"`
+
+	Ready   *Probe        `yaml:"ready"`
+	Restart RestartPolicy `yaml:"restart"`
+
+	// Output
+	LogFormat string   `yaml:"logFormat"` // "" or iqconnect (splits the IQConnectLog timestamp/level)
+	CrashLog  CrashLog `yaml:"crashLog"`
 }
 
-/** run executes a command and stores if it's running after 1sec in the Running-map */
-func run(name, path string, flags []string) error {
+/** run executes a command and stores it in the Running-map once its ready probe passes */
+func run(name, path string, flags []string, ready *Probe) error {
 	base := filepath.Dir(path)
 	if e := os.Chdir(base); e != nil {
 		return e
@@ -32,87 +41,253 @@ func run(name, path string, flags []string) error {
 	ctxb := context.Background()
 	// DevNote: yes no context timeout as we want to run as long as possible
 	cmd := exec.CommandContext(ctxb, path, flags...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stdout
+	ring := ProcessLog(name)
+	var logs *logMatcher
+	var onLine func(line string)
+	if ready != nil && ready.Log != "" {
+		// validated by the config
+		logs = newLogMatcher(regexp.MustCompile(ready.Log))
+		onLine = logs.Line
+	}
+	cmd.Stdout = ring.Writer("stdout", onLine)
+	cmd.Stderr = ring.Writer("stderr", onLine)
+	// don't wait on grandchildren that inherited the output pipe
+	cmd.WaitDelay = time.Second
 
-	// Now some dark magic to only
-	// mark it as running after 1min to prevent flip-flopping
-	var wg sync.WaitGroup
-	wg.Add(1)
+	if e := cmd.Start(); e != nil {
+		return e
+	}
+	pid := cmd.Process.Pid
+	ring.add("supervisor", fmt.Sprintf("started %s pid=%d", path, pid))
 
 	var e error
-	run := true
+	exited := make(chan struct{})
 	go func() {
 		// Blocking action in separate go-routine
-		e = cmd.Run()
-		run = false
-		wg.Done()
+		e = cmd.Wait()
+		close(exited)
 	}()
 
-	// Wait X-sec before marking as running
-	time.Sleep(time.Second * 1)
+	// Only mark it as running when it's ready so dependents
+	// don't start too early
+	ctx, cancel := context.WithTimeout(ctxb, ready.timeout())
+	eReady := ready.wait(ctx, exited, logs)
+	cancel()
 	if Verbose {
-		slog.Info("exec[run] wakeup", "name", name)
+		slog.Info("exec[run] wakeup", "name", name, "probe", ready.String())
 	}
 
-	if run {
+	switch {
+	case eReady == nil:
 		// Save state
-		Running.Store(name, cmd.Process.Pid)
+		Running.Store(name, pid)
+		setProcState(name, ProcRunning, func(p *ProcState) { p.Pid = pid })
 		if Verbose {
-			slog.Info("exec[run] running", "name", name, "pid", cmd.Process.Pid)
+			slog.Info("exec[run] running", "name", name, "pid", pid)
+		}
+	case eReady != errExited:
+		slog.Error("exec[run] not ready, killing", "name", name, "pid", pid, "e", eReady.Error())
+		if e := cmd.Process.Kill(); e != nil {
+			slog.Error("exec[run] kill", "name", name, "e", e.Error())
 		}
+		<-exited
+		return fmt.Errorf("[%s] not ready: %w", name, eReady)
 	}
 
-	wg.Wait()
+	<-exited
 	Running.Delete(name)
 
 	if e == nil && cmd.ProcessState.ExitCode() != 0 {
 		e = fmt.Errorf("[%s] exited with exit=%d", name, cmd.ProcessState.ExitCode())
 	}
+	ring.add("supervisor", fmt.Sprintf("exited pid=%d %s", pid, cmd.ProcessState.String()))
 
 	return e
 }
 
+/** runPost executes the post-processing command of name */
+func runPost(name, path string, flags []string) error {
+	if e := os.Chdir(filepath.Dir(path)); e != nil {
+		return e
+	}
+	cmd := exec.Command(path, flags...)
+	ring := ProcessLog(name)
+	cmd.Stdout = ring.Writer("stdout", nil)
+	cmd.Stderr = ring.Writer("stderr", nil)
+	if e := cmd.Run(); e != nil {
+		return fmt.Errorf("[%s] %s: %w", name, path, e)
+	}
+	return nil
+}
+
+/** stopSupervisor is closed on shutdown so ensureRunning stops restarting */
+var stopSupervisor = make(chan struct{})
+
+// sleepOrStop sleeps d and returns false when the supervisor is stopped meanwhile
+func sleepOrStop(d time.Duration) bool {
+	select {
+	case <-stopSupervisor:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+/** errStopped is returned by waitDep when the supervisor is stopped */
+var errStopped = errors.New("stopped")
+
+// waitDep blocks until dep is running, every timeout without it an
+// error is logged. It returns an error when dep is marked failed.
+func waitDep(name, dep string, timeout time.Duration) error {
+	since := time.Now()
+	for {
+		if _, ok := Running.Load(dep); ok == true {
+			// Service avail
+			if Verbose {
+				slog.Info("exec[ensureRunning] depAvail", "name", name)
+			}
+			return nil
+		}
+
+		state, _ := Process(dep)
+		if state.State == ProcFailed {
+			return fmt.Errorf("[%s] dep %s failed: %s", name, dep, state.LastError)
+		}
+		if time.Since(since) >= timeout {
+			e := fmt.Errorf("[%s] dep %s not ready after %s (state=%s, e=%s)", name, dep, timeout, state.State, state.LastError)
+			slog.Error("exec[ensureRunning] await", "name", name, "dep", dep, "e", e.Error())
+			setProcState(name, ProcWaiting, func(p *ProcState) { p.LastError = e.Error() })
+			since = time.Now()
+		} else if Verbose {
+			slog.Info("exec[ensureRunning] await", "name", name, "dep", dep)
+		}
+
+		if !sleepOrStop(time.Millisecond * 250) { // 0.25sec
+			return errStopped
+		}
+	}
+}
+
 // ensureRunning ensures all given cmds are running
-// and else it respawns them in the order given
+// and else it respawns them in the order given with the
+// backoff of their RestartPolicy until they are marked failed.
+// RestartProcess skips the backoff or revives a failed process.
 func ensureRunning(wg *sync.WaitGroup, cmds map[string]CmdInfo) {
 	for name, info := range cmds {
+		restartReq := restartChan(name)
 		go func(name string, info CmdInfo) {
 			defer wg.Done()
-			//var lastSleep int64
+			restart := newBackoff(info.Restart)
+			ProcessLog(name).SetFormat(info.LogFormat)
+
+			// awaitRestart blocks a failed process until RestartProcess, false on shutdown
+			awaitRestart := func() bool {
+				select {
+				case <-restartReq:
+					slog.Info("exec[ensureRunning] restart requested", "name", name)
+					restart.reset()
+					return true
+				case <-stopSupervisor:
+					setProcState(name, ProcStopped, nil)
+					return false
+				}
+			}
 
 			for {
 				if info.Dep != "" {
-					for {
-						if _, ok := Running.Load(info.Dep); ok == true {
-							// Service avail
-							if Verbose {
-								slog.Info("exec[ensureRunning] depAvail", "name", name)
-							}
-							break
-						}
-						if Verbose {
-							slog.Info("exec[ensureRunning] await", "name", name, "dep", info.Dep)
+					setProcState(name, ProcWaiting, nil)
+					if e := waitDep(name, info.Dep, cmds[info.Dep].Ready.timeout()); e == errStopped {
+						setProcState(name, ProcStopped, nil)
+						return
+					} else if e != nil {
+						slog.Error("exec[ensureRunning] giving up", "name", name, "e", e.Error())
+						setProcState(name, ProcFailed, func(p *ProcState) { p.LastError = e.Error() })
+						if !awaitRestart() {
+							return
 						}
-						time.Sleep(time.Millisecond * 250) // 0.25sec
+						continue
 					}
 				}
-				e := run(name, info.Cmd, info.Args)
-				slog.Error("exec[ensureRunning] process.Stop", "name", name, "e", e.Error())
 
-				if len(info.PostCmd) > 0 {
-					// Run something after the process stopped
-					if e := run(name, info.PostCmd, info.PostArgs); e != nil {
-						slog.Error("exec[ensureRunning] PostCmd", "name", name, "e", e.Error())
+				start := time.Now()
+				setProcState(name, ProcStarting, func(p *ProcState) { p.Started = start })
+				e := run(name, info.Cmd, info.Args, info.Ready)
+				uptime := time.Since(start)
+				code := exitCode(e)
+				if e == nil {
+					// children are supposed to run forever
+					e = fmt.Errorf("[%s] exited", name)
+				}
+				select {
+				case <-stopSupervisor:
+					slog.Info("exec[ensureRunning] stopped", "name", name, "e", e.Error())
+					setProcState(name, ProcStopped, func(p *ProcState) {
+						p.Pid = 0
+						p.LastExit = code
+					})
+					return
+				default:
+				}
+
+				select {
+				case <-restartReq:
+					// RestartProcess stopped it, no crash
+					slog.Info("exec[ensureRunning] restart requested", "name", name, "e", e.Error())
+					restart.reset()
+					setProcState(name, ProcBackoff, func(p *ProcState) {
+						p.Pid = 0
+						p.LastExit = code
+						p.NextStart = time.Now()
+					})
+
+				default:
+					slog.Error("exec[ensureRunning] process.Stop", "name", name, "uptime", uptime.Round(time.Second).String(), "e", e.Error())
+					if e := info.CrashLog.Save(ProcessLog(name), time.Now()); e != nil {
+						slog.Error("exec[ensureRunning] CrashLog", "name", name, "e", e.Error())
+					}
+
+					if len(info.PostCmd) > 0 {
+						// Run something after the process stopped
+						if e := runPost(name, info.PostCmd, info.PostArgs); e != nil {
+							slog.Error("exec[ensureRunning] PostCmd", "name", name, "e", e.Error())
+						}
+					}
+
+					delay, eGiveUp := restart.next(uptime, time.Now())
+					if eGiveUp != nil {
+						setProcState(name, ProcFailed, func(p *ProcState) {
+							p.Pid = 0
+							p.LastExit = code
+							p.LastError = e.Error()
+						})
+						slog.Error("exec[ensureRunning] giving up", "name", name, "e", eGiveUp.Error())
+						if !awaitRestart() {
+							return
+						}
+						break // out of the select
+					}
+					setProcState(name, ProcBackoff, func(p *ProcState) {
+						p.Pid = 0
+						p.LastExit = code
+						p.LastError = e.Error()
+						p.NextStart = time.Now().Add(delay)
+					})
+					if Verbose {
+						slog.Info("exec[ensureRunning] backoff", "name", name, "delay", delay.Round(time.Millisecond).String())
+					}
+					select {
+					case <-restartReq:
+						slog.Info("exec[ensureRunning] restart requested", "name", name)
+						restart.reset()
+					case <-stopSupervisor:
+						setProcState(name, ProcStopped, nil)
+						return
+					case <-time.After(delay):
 					}
 				}
 
-				/*if time.Now().Unix()-lastSleep < 10 {
-					// prevent hammering less than 10sec ago, sleep!
-					fmt.Printf("[%s] (sleep 5sec)\n", name)
-					time.Sleep(time.Second * 5)
-				}*/
-				//lastSleep = time.Now().Unix()
+				setProcState(name, ProcBackoff, func(p *ProcState) { p.Restarts++ })
+				processRestarts.Inc(name)
 				if Verbose {
 					slog.Info("exec[ensureRunning] forNext", "name", name)
 				}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** fakeStatsInterval is how often the FakeFeed admin port sends S,STATS (IQFeed does every second) */
+const fakeStatsInterval = time.Second
+
+// FakeFeed is a scriptable IQFeed that speaks the lookup (9100) and
+// admin (9300) protocol so everything can run without Wine.
+// Unknown lookup cmds are answered with E,!NO_DATA!
+type FakeFeed struct {
+	lookup net.Listener
+	admin  net.Listener
+
+	mu        sync.Mutex
+	replies   map[string][]string // cmd => reply lines (without EOM)
+	connected bool                // S,STATS status
+	dials     int                 // accepted lookup conns
+	cmds      []string            // received lookup cmds
+	conns     map[net.Conn]struct{}
+}
+
+// NewFakeFeed listens on random localhost ports
+func NewFakeFeed() (*FakeFeed, error) {
+	f := &FakeFeed{
+		replies:   make(map[string][]string),
+		connected: true,
+		conns:     make(map[net.Conn]struct{}),
+	}
+	var e error
+	if f.lookup, e = net.Listen("tcp", "127.0.0.1:0"); e != nil {
+		return nil, e
+	}
+	if f.admin, e = net.Listen("tcp", "127.0.0.1:0"); e != nil {
+		f.lookup.Close()
+		return nil, e
+	}
+	go f.accept(f.lookup, f.serveLookup)
+	go f.accept(f.admin, f.serveAdmin)
+	return f, nil
+}
+
+// Upstream returns an Upstream that dials this FakeFeed and is always ready
+func (f *FakeFeed) Upstream() *Upstream {
+	u := DefaultUpstream()
+	u.Lookup = f.lookup.Addr().String()
+	u.Admin = f.admin.Addr().String()
+	u.Ready = func() error { return nil }
+	return u
+}
+
+// Reply scripts the reply lines of cmd, EOM is added by the FakeFeed
+func (f *FakeFeed) Reply(cmd string, lines ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[cmd] = lines
+}
+
+// Fail scripts cmd to reply with E,msg
+func (f *FakeFeed) Fail(cmd string, msg string) {
+	f.Reply(cmd, "E,"+msg+",")
+}
+
+// SetConnected changes the status the admin port reports
+func (f *FakeFeed) SetConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected = connected
+}
+
+// Dials returns the amount of accepted lookup conns
+func (f *FakeFeed) Dials() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dials
+}
+
+// Cmds returns all received lookup cmds (without S,TEST and S,SET)
+func (f *FakeFeed) Cmds() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.cmds...)
+}
+
+// Close stops listening and drops all conns
+func (f *FakeFeed) Close() error {
+	e := f.lookup.Close()
+	if e2 := f.admin.Close(); e == nil {
+		e = e2
+	}
+	f.mu.Lock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+	return e
+}
+
+// accept calls serve for every conn until ln is closed
+func (f *FakeFeed) accept(ln net.Listener, serve func(conn net.Conn)) {
+	for {
+		conn, e := ln.Accept()
+		if e != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = struct{}{}
+		f.mu.Unlock()
+
+		go func() {
+			serve(conn)
+			f.mu.Lock()
+			delete(f.conns, conn)
+			f.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// fakeWrite writes lines with the IQFeed line ending
+func fakeWrite(conn net.Conn, lines ...string) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line + "\r\n")
+	}
+	_, e := conn.Write(buf.Bytes())
+	return e
+}
+
+// serveLookup answers lookup cmds
+func (f *FakeFeed) serveLookup(conn net.Conn) {
+	f.mu.Lock()
+	f.dials++
+	f.mu.Unlock()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, e := r.ReadString('\n')
+		if e != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+
+		var reply []string
+		switch {
+		case strings.HasPrefix(cmd, "S,SET PROTOCOL,"):
+			reply = []string{"S,CURRENT PROTOCOL," + cmd[len("S,SET PROTOCOL,"):]}
+		case strings.HasPrefix(cmd, "S,SET CLIENT NAME,"):
+			// no reply
+		case cmd == "QUIT":
+			return
+		case strings.HasPrefix(cmd, "S,"):
+			// S,TEST included, the pool uses it to find the end of the buffer
+			reply = []string{"E,!SYNTAX_ERROR!,"}
+		default:
+			f.mu.Lock()
+			f.cmds = append(f.cmds, cmd)
+			lines, ok := f.replies[cmd]
+			f.mu.Unlock()
+			if !ok {
+				lines = []string{"E,!NO_DATA!,,"}
+			}
+			reply = append(append(reply, lines...), EOM)
+		}
+
+		if Verbose {
+			slog.Info("fakefeed(lookup)", "cmd", cmd, "lines", len(reply))
+		}
+		if e := fakeWrite(conn, reply...); e != nil {
+			return
+		}
+	}
+}
+
+// stats returns the S,STATS line of the admin port
+func (f *FakeFeed) stats() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := "Not Connected"
+	if f.connected {
+		status = "Connected"
+	}
+	return fmt.Sprintf("S,STATS,127.0.0.1,60002,1300,0,1,0,0,0,%s,%s,%s,6.2.0.25,FAKE,0,0.0,0.0,0.0,0.0,0.0,",
+		time.Now().Format("Jan 02 3:04PM"), time.Now().Format("Jan 02 3:04PM"), status)
+}
+
+// serveAdmin sends S,STATS every fakeStatsInterval and handles
+// S,CONNECT and S,DISCONNECT
+func (f *FakeFeed) serveAdmin(conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(fakeStatsInterval)
+		defer t.Stop()
+		for {
+			if e := fakeWrite(conn, f.stats()); e != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, e := r.ReadString('\n')
+		if e != nil {
+			return
+		}
+		switch strings.TrimSpace(line) {
+		case "S,CONNECT":
+			f.SetConnected(true)
+		case "S,DISCONNECT":
+			f.SetConnected(false)
+		}
+	}
+}
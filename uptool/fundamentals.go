@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** fundamentalTimeout is the max time we wait for the F-msg after a watch */
+const fundamentalTimeout = 5 * time.Second
+
+// FundamentalTTL is how long a fundamental snapshot is cached
+var FundamentalTTL = time.Hour
+
+// Fundamental is the typed F-msg, Fields holds everything IQFeed reported
+type Fundamental struct {
+	Symbol            string
+	CompanyName       string
+	ExchangeID        string
+	ListedMarket      string
+	SecurityType      string
+	High52Week        float64
+	High52WeekDate    string `json:",omitempty"`
+	Low52Week         float64
+	Low52WeekDate     string `json:",omitempty"`
+	SharesOutstanding int64
+	EPS               float64 // current year
+	PE                float64
+	DividendYield     float64
+	SIC               int64
+	NAICS             int64
+	ExpirationDate    string  `json:",omitempty"`
+	StrikePrice       float64 `json:",omitempty"`
+	Fields            map[string]string
+	Fetched           time.Time
+}
+
+// fundamentalDate converts MM/DD/YYYY into YYYY-MM-DD (empty stays empty)
+func fundamentalDate(val string) (string, error) {
+	if val == "" {
+		return "", nil
+	}
+	t, e := time.Parse("01/02/2006", val)
+	if e != nil {
+		return "", e
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+// parseFundamental converts the named fields of a F-msg, the field
+// layout comes from S,CURRENT FUNDAMENTAL FIELDNAMES
+func parseFundamental(fields map[string]string) (Fundamental, error) {
+	f := Fundamental{
+		Symbol:       fields["Symbol"],
+		CompanyName:  fields["Company Name"],
+		ExchangeID:   fields["Exchange ID"],
+		ListedMarket: fields["Listed Market"],
+		SecurityType: fields["Security Type"],
+		Fields:       fields,
+	}
+
+	var e error
+	for name, dst := range map[string]*float64{
+		"52 Week High":     &f.High52Week,
+		"52 Week Low":      &f.Low52Week,
+		"Current Year EPS": &f.EPS,
+		"PE":               &f.PE,
+		"Dividend Yield":   &f.DividendYield,
+		"Strike Price":     &f.StrikePrice,
+	} {
+		if *dst, e = parseFloat([]byte(fields[name])); e != nil {
+			return f, fmt.Errorf("%s: %w", name, e)
+		}
+	}
+	for name, dst := range map[string]*int64{
+		"Common Shares Outstanding": &f.SharesOutstanding,
+		"SIC":                       &f.SIC,
+		"NAICS":                     &f.NAICS,
+	} {
+		if *dst, e = parseInt([]byte(fields[name])); e != nil {
+			return f, fmt.Errorf("%s: %w", name, e)
+		}
+	}
+	for name, dst := range map[string]*string{
+		"52 Week High Date": &f.High52WeekDate,
+		"52 Week Low Date":  &f.Low52WeekDate,
+		"Expiration Date":   &f.ExpirationDate,
+	} {
+		if *dst, e = fundamentalDate(fields[name]); e != nil {
+			return f, fmt.Errorf("%s: %w", name, e)
+		}
+	}
+	return f, nil
+}
+
+// Fundamental watches asset until IQFeed sends the F-msg
+func (l *Level1) Fundamental(asset string) (Fundamental, error) {
+	sub := l.Subscribe([]string{asset})
+	defer l.Unsubscribe(sub)
+
+	timeout := time.NewTimer(fundamentalTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return Fundamental{}, fmt.Errorf("level1 dropped the watch")
+			}
+			switch msg.Type {
+			case "n":
+				return Fundamental{}, fmt.Errorf("!NO_DATA!")
+			case "F":
+				return parseFundamental(msg.Fields)
+			}
+		case <-timeout.C:
+			return Fundamental{}, fmt.Errorf("level1 no fundamental msg within %s", fundamentalTimeout)
+		}
+	}
+}
+
+// FundamentalCache keeps fundamental snapshots for FundamentalTTL
+type FundamentalCache struct {
+	mu   sync.Mutex
+	rows map[string]Fundamental
+}
+
+var fundamentals = &FundamentalCache{rows: make(map[string]Fundamental)}
+
+// Get returns the cached snapshot of asset or fetches it, hit reports if it came from cache
+func (c *FundamentalCache) Get(asset string, bypass bool) (f Fundamental, hit bool, e error) {
+	asset = strings.ToUpper(asset)
+	c.mu.Lock()
+	f, ok := c.rows[asset]
+	c.mu.Unlock()
+	if ok && !bypass && time.Since(f.Fetched) < FundamentalTTL {
+		return f, true, nil
+	}
+
+	if f, e = level1.Fundamental(asset); e != nil {
+		return f, false, e
+	}
+	f.Fetched = time.Now()
+	if Verbose {
+		slog.Info("fundamentals(Get) fetched", "asset", asset)
+	}
+
+	c.mu.Lock()
+	// drop expired snapshots so the map doesn't grow forever
+	for sym, row := range c.rows {
+		if time.Since(row.Fetched) >= FundamentalTTL {
+			delete(c.rows, sym)
+		}
+	}
+	c.rows[asset] = f
+	c.mu.Unlock()
+	return f, false, nil
+}
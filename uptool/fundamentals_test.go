@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFundamental(t *testing.T) {
+	f, e := parseFundamental(map[string]string{
+		"Symbol":                    "AAPL",
+		"Company Name":              "APPLE INC",
+		"Exchange ID":               "5",
+		"52 Week High":              "199.62",
+		"52 Week High Date":         "12/14/2023",
+		"52 Week Low":               "124.17",
+		"52 Week Low Date":          "01/05/2023",
+		"Common Shares Outstanding": "15552752000",
+		"Current Year EPS":          "6.13",
+		"PE":                        "30.5",
+		"Dividend Yield":            "0.5",
+		"SIC":                       "3571",
+		"NAICS":                     "334111",
+		"Expiration Date":           "",
+		"Strike Price":              "",
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if f.Symbol != "AAPL" || f.CompanyName != "APPLE INC" || f.High52Week != 199.62 || f.High52WeekDate != "2023-12-14" {
+		t.Errorf("parsed=%+v", f)
+	}
+	if f.SharesOutstanding != 15552752000 || f.SIC != 3571 || f.NAICS != 334111 || f.EPS != 6.13 {
+		t.Errorf("parsed=%+v", f)
+	}
+	if f.ExpirationDate != "" || f.StrikePrice != 0 {
+		t.Errorf("parsed=%+v", f)
+	}
+
+	if _, e := parseFundamental(map[string]string{"52 Week Low Date": "2023-01-05"}); e == nil {
+		t.Errorf("invalid date accepted")
+	}
+}
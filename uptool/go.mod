@@ -5,11 +5,14 @@ go 1.21
 toolchain go1.23.0
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/hashicorp/go-reap v0.0.0-20230117204525-bf69c61a7b71
 	github.com/hokaccha/go-prettyjson v0.0.0-20211117102719-0474bc63780f
 	github.com/itshosted/webutils v0.0.0-20230120094721-d656b0c12463
 	github.com/maurice2k/tcpserver v1.2.0
 	github.com/vmihailenco/msgpack v4.0.4+incompatible
+	go.etcd.io/bbolt v1.3.9
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,25 +18,220 @@ import (
 var (
 	mux muxdoc.MuxDoc
 	ln  net.Listener
+	// httpShutdown is closed on server.Shutdown to end the streams
+	httpShutdown = make(chan struct{})
 )
 
-// LH,2023-05-25,288.8400,272.8500,287.9100,280.9900,878367,0,
-type OHLC struct {
-	Datetime string
-	High     string
-	Low      string
-	Open     string
-	Close    string
-	Volume   string
-}
-
 type SearchLine struct {
 	Ticker      string
 	MarketId    string
 	Description string
 	Type        string
+	MarketName  string `json:",omitempty"` // ?names=1
+	TypeName    string `json:",omitempty"` // ?names=1
+}
+
+// iqDatetimeLayouts are the datetime formats we accept from clients
+var iqDatetimeLayouts = []string{
+	"20060102 150405",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"20060102",
+}
+
+// parseDatetime reads a client datetime in any of iqDatetimeLayouts
+func parseDatetime(val string) (time.Time, error) {
+	for _, layout := range iqDatetimeLayouts {
+		t, e := time.ParseInLocation(layout, val, exchangeTZ)
+		if e == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid datetime=%s, expected CCYYMMDD HHmmSS or CCYY-MM-DD HH:MM:SS", val)
+}
+
+// iqDatetime converts a client datetime into the CCYYMMDD HHmmSS format IQFeed expects
+func iqDatetime(val string) (string, error) {
+	t, e := parseDatetime(val)
+	if e != nil {
+		return "", e
+	}
+	return t.Format("20060102 150405"), nil
+}
+
+// iqDate converts a client date into the CCYYMMDD format IQFeed expects
+func iqDate(val string) (string, error) {
+	t, e := parseDatetime(val)
+	if e != nil {
+		return "", e
+	}
+	return t.Format("20060102"), nil
 }
 
+// iqFilterTime converts a client time-of-day (HH:MM:SS or HHmmSS) into HHmmSS
+func iqFilterTime(val string) (string, error) {
+	for _, layout := range []string{"15:04:05", "150405", "15:04"} {
+		t, e := time.Parse(layout, val)
+		if e == nil {
+			return t.Format("150405"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid time=%s, expected HH:MM:SS or HHmmSS", val)
+}
+
+// iqMaxDatapoints returns the MaxDatapoints field, empty means everything
+func iqMaxDatapoints(dp int) string {
+	if dp > 0 {
+		return strconv.Itoa(dp)
+	}
+	return ""
+}
+
+// HistOpts are the optional fields shared by the HDT/HID/HIT/HTD/HTT commands
+type HistOpts struct {
+	BeginFilter string // HHmmSS
+	EndFilter   string // HHmmSS
+	Direction   string // 0=newest-first, 1=oldest-first
+	PerSend     string // DatapointsPerSend
+}
+
+// parseHistOpts reads ?beginFilter=&endFilter=&direction=newest|oldest&perSend=
+func parseHistOpts(r *http.Request) (HistOpts, *writer.ErrorRes) {
+	var (
+		opts HistOpts
+		e    error
+	)
+	if v := r.URL.Query().Get("beginFilter"); v != "" {
+		if opts.BeginFilter, e = iqFilterTime(v); e != nil {
+			return opts, &writer.ErrorRes{Error: "GET[beginFilter] invalid", Detail: e.Error()}
+		}
+	}
+	if v := r.URL.Query().Get("endFilter"); v != "" {
+		if opts.EndFilter, e = iqFilterTime(v); e != nil {
+			return opts, &writer.ErrorRes{Error: "GET[endFilter] invalid", Detail: e.Error()}
+		}
+	}
+	switch r.URL.Query().Get("direction") {
+	case "":
+	case "newest":
+		opts.Direction = "0"
+	case "oldest":
+		opts.Direction = "1"
+	default:
+		return opts, &writer.ErrorRes{Error: "GET[direction] invalid, possible=newest|oldest"}
+	}
+	if v := r.URL.Query().Get("perSend"); v != "" {
+		n, e := strconv.Atoi(v)
+		if e != nil || n < 1 {
+			return opts, &writer.ErrorRes{Error: "GET[perSend] not a positive number"}
+		}
+		opts.PerSend = v
+	}
+	return opts, nil
+}
+
+// parseExchange returns the calendar of GET[exchange] (empty=default)
+func parseExchange(r *http.Request) (*Exchange, *writer.ErrorRes) {
+	x, ok := calendar.Exchange(r.URL.Query().Get("exchange"))
+	if !ok {
+		return nil, &writer.ErrorRes{Error: "GET[exchange] unknown", Detail: "possible=" + strings.Join(calendar.Names(), "|")}
+	}
+	return x, nil
+}
+
+// typedChunked reports if mode=chunked asked for a format (JSON, msgpack or
+// CSV of the bar types), without an Accept header (or */*) the raw upstream
+// lines with the MessageID column are kept for existing clients
+func typedChunked(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return accept != "" && accept != "*/*"
+}
+
+// ParseFunc converts an upstream line into a typed struct (nil to skip the line)
+type ParseFunc func(bin []byte) (interface{}, error)
+
+// encodedStream proxies cmd and writes every line parsed by fn
+// through writer.ChunkedEncoder (CSV needs writer.CSVMarshaler)
+func encodedStream(w http.ResponseWriter, r *http.Request, cmd []byte, fn ParseFunc) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Could not get Flusher-instance"}); e != nil {
+			slog.Error("HTTP[encodedStream] getFlusher", "e", e.Error())
+		}
+		return
+	}
+	enc := writer.ChunkedEncoder(w, r)
+
+	i := 0
+	if e := proxy(cmd, -1, func(bin []byte) error {
+		line, e := fn(bin)
+		if e != nil {
+			return e
+		}
+		if line == nil {
+			// filtered out
+			return nil
+		}
+
+		if csv, ok := enc.(writer.StringEncoder); ok {
+			row, ok := line.(writer.CSVMarshaler)
+			if !ok {
+				return fmt.Errorf("DevErr: %T has no CSV-representation", line)
+			}
+			if i == 0 {
+				if e := csv.Write(row.CSVHeader()); e != nil {
+					return e
+				}
+			}
+			if e := csv.Write(row.CSVRecord()); e != nil {
+				return e
+			}
+
+			i++
+			if i%100 == 0 {
+				enc.(writer.FlushEncoder).Flush()
+				flusher.Flush()
+			}
+			return nil
+		}
+
+		if e := enc.Encode(line); e != nil {
+			return e
+		}
+
+		i++
+		if i%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+
+	}); e != nil {
+		slog.Error("HTTP[encodedStream] proxy", "e", e.Error())
+		if i == 0 {
+			if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "Read failure", Detail: e.Error()}); e != nil {
+				slog.Error("HTTP[encodedStream] WriteUpstreamError", "e", e.Error())
+			}
+		}
+		return
+	}
+
+	if i == 0 {
+		// Nothing sent to client
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "No data"}); e != nil {
+			slog.Error("HTTP[encodedStream] WriteNoData", "e", e.Error())
+		}
+		return
+	}
+
+	if fenc, ok := enc.(writer.FlushEncoder); ok {
+		fenc.Flush()
+	}
+	flusher.Flush()
+}
+
+// chunkedStream proxies cmd and writes the upstream lines as CSV
+// (mode=chunked unless typedChunked)
 func chunkedStream(w http.ResponseWriter, r *http.Request, cmd []byte, csvHeader []byte) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -97,6 +292,24 @@ func doc(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(mux.String()))
 }
 
+func cacheStats(w http.ResponseWriter, r *http.Request) {
+	if barCache == nil {
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "Cache disabled"}); e != nil {
+			slog.Error("HTTP[cacheStats] WriteDisabled", "e", e.Error())
+		}
+		return
+	}
+
+	stats := map[string]int64{
+		"Hits":    barCache.Hits.Load(),
+		"Partial": barCache.Partial.Load(),
+		"Misses":  barCache.Misses.Load(),
+	}
+	if e := writer.Encode(w, r, 200, stats); e != nil {
+		slog.Error("HTTP[cacheStats] WriteEncode", "e", e.Error())
+	}
+}
+
 func verbose(w http.ResponseWriter, r *http.Request) {
 	msg := `{"success": true, "msg": "Set verbosity to `
 	if Verbose {
@@ -177,6 +390,30 @@ func search(w http.ResponseWriter, r *http.Request) {
 	}
 	enc := writer.ChunkedEncoder(w, r)
 
+	// Resolve the numeric IDs on request
+	var (
+		markets map[int]ListedMarket
+		types   map[int]SecurityType
+	)
+	names := r.URL.Query().Get("names") == "1"
+	if names {
+		var e error
+		if markets, e = refTables.Markets(); e != nil {
+			slog.Warn("HTTP[search] Markets", "e", e.Error())
+		}
+		if types, e = refTables.Types(); e != nil {
+			slog.Warn("HTTP[search] Types", "e", e.Error())
+		}
+	}
+	marketName := func(id string) string {
+		n, _ := strconv.Atoi(id)
+		return markets[n].ShortName
+	}
+	typeName := func(id string) string {
+		n, _ := strconv.Atoi(id)
+		return types[n].ShortName
+	}
+
 	i := 0
 	// Parse lines
 	var line SearchLine
@@ -184,12 +421,19 @@ func search(w http.ResponseWriter, r *http.Request) {
 		csv, ok := enc.(writer.StringEncoder)
 		if ok {
 			if i == 0 {
-				if e := csv.Write([]string{"MessageID", "Symbol", "ListedMarketID", "SecurityTypeID", "Name", ""}); e != nil {
+				header := []string{"MessageID", "Symbol", "ListedMarketID", "SecurityTypeID", "Name", ""}
+				if names {
+					header = append(header, "ListedMarket", "SecurityType")
+				}
+				if e := csv.Write(header); e != nil {
 					return e
 				}
 			}
 			// TODO: use bytes.SplitN and typecast?
 			buf := strings.SplitN(string(bin), ",", 6)
+			if names && len(buf) == 6 {
+				buf = append(buf, marketName(buf[2]), typeName(buf[3]))
+			}
 			if e := csv.Write(buf); e != nil {
 				return e
 			}
@@ -212,6 +456,10 @@ func search(w http.ResponseWriter, r *http.Request) {
 		line.MarketId = string(buf[2])
 		line.Description = string(buf[4])
 		line.Type = string(buf[3])
+		if names {
+			line.MarketName = marketName(line.MarketId)
+			line.TypeName = typeName(line.Type)
+		}
 
 		if e := enc.Encode(line); e != nil {
 			return e
@@ -255,6 +503,7 @@ func data(w http.ResponseWriter, r *http.Request) {
 		cmd  []byte
 		dp   int
 		mode string
+		creq *CacheReq
 	)
 	{
 		asset := r.URL.Query().Get("asset")
@@ -271,68 +520,146 @@ func data(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+		var e error
 		dpStr := r.URL.Query().Get("datapoints")
-		if dpStr == "" {
-			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] missing"}); e != nil {
-				slog.Error("HTTP[data] WriteDatapointsMissing", "e", e.Error())
+		if dpStr != "" {
+			dp, e = strconv.Atoi(dpStr)
+			if e != nil {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] not a number"}); e != nil {
+					slog.Error("HTTP[data] WriteDtatapointNaN", "e", e.Error())
+				}
+				return
+			}
+		}
+		opts, errRes := parseHistOpts(r)
+		if errRes != nil {
+			if e := writer.Err(w, r, 400, *errRes); e != nil {
+				slog.Error("HTTP[data] WriteInvalidOpts", "e", e.Error())
 			}
 			return
 		}
-		var e error
-		dp, e = strconv.Atoi(dpStr)
-		if e != nil {
-			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] not a number"}); e != nil {
-				slog.Error("HTTP[data] WriteDtatapointNaN", "e", e.Error())
+		if opts.BeginFilter != "" || opts.EndFilter != "" {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[beginFilter|endFilter] not possible for range"}); e != nil {
+				slog.Error("HTTP[data] WriteInvalidFilter", "e", e.Error())
 			}
 			return
 		}
 
 		mode = r.URL.Query().Get("mode")
-		if rangeStr == "DAILY" {
-			cmd = []byte(fmt.Sprintf("HDX,%s,%d", asset, dp))
+		begin := r.URL.Query().Get("begin")
+		end := r.URL.Query().Get("end")
+		if tdStr := r.URL.Query().Get("tradingDays"); tdStr != "" {
+			if rangeStr != "DAILY" || begin != "" || end != "" || dpStr != "" {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[tradingDays] only possible with range=DAILY and without datapoints|begin|end"}); e != nil {
+					slog.Error("HTTP[data] WriteInvalidTradingDays", "e", e.Error())
+				}
+				return
+			}
+			td, e := strconv.Atoi(tdStr)
+			if e != nil || td < 1 {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[tradingDays] not a positive number"}); e != nil {
+					slog.Error("HTTP[data] WriteTradingDaysNaN", "e", e.Error())
+				}
+				return
+			}
+			x, errRes := parseExchange(r)
+			if errRes != nil {
+				if e := writer.Err(w, r, 400, *errRes); e != nil {
+					slog.Error("HTTP[data] WriteInvalidExchange", "e", e.Error())
+				}
+				return
+			}
+			// Fetch from the first trading day, the symbol may have less bars (halted/IPO)
+			dp = td
+			from := x.TradingDaysBack(td, time.Now())
+			begin = from.Format("20060102")
+			cmd = []byte(fmt.Sprintf("HDT,%s,%s,,%s,%s,,%s", asset, begin, iqMaxDatapoints(dp), opts.Direction, opts.PerSend))
+			creq = &CacheReq{Asset: asset, From: from, To: time.Now(), Max: dp, Oldest: opts.Direction == "1"}
+
+		} else if begin != "" || end != "" {
+			if rangeStr != "DAILY" {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[begin|end] only possible with range=DAILY"}); e != nil {
+					slog.Error("HTTP[data] WriteInvalidRange", "e", e.Error())
+				}
+				return
+			}
+			if begin != "" {
+				if begin, e = iqDate(begin); e != nil {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[begin] invalid", Detail: e.Error()}); e != nil {
+						slog.Error("HTTP[data] WriteInvalidBegin", "e", e.Error())
+					}
+					return
+				}
+			}
+			if end != "" {
+				if end, e = iqDate(end); e != nil {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[end] invalid", Detail: e.Error()}); e != nil {
+						slog.Error("HTTP[data] WriteInvalidEnd", "e", e.Error())
+					}
+					return
+				}
+			}
+			// HDT,[Symbol],[BeginDate],[EndDate],[MaxDatapoints],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HDT,%s,%s,%s,%s,%s,,%s", asset, begin, end, iqMaxDatapoints(dp), opts.Direction, opts.PerSend))
+			if begin != "" {
+				creq = &CacheReq{Asset: asset, Max: dp, Oldest: opts.Direction == "1"}
+				creq.From, _ = time.ParseInLocation("20060102", begin, exchangeTZ)
+				creq.To = time.Now()
+				if end != "" {
+					creq.To, _ = time.ParseInLocation("20060102", end, exchangeTZ)
+				}
+			}
+		} else if dpStr == "" {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints|begin+end] missing"}); e != nil {
+				slog.Error("HTTP[data] WriteDatapointsMissing", "e", e.Error())
+			}
+			return
+		} else if rangeStr == "DAILY" {
+			cmd = []byte(fmt.Sprintf("HDX,%s,%d,%s,,%s", asset, dp, opts.Direction, opts.PerSend))
+			if dp > 0 {
+				creq = &CacheReq{Asset: asset, Last: dp, Oldest: opts.Direction == "1"}
+			}
 		} else if rangeStr == "WEEKLY" {
-			cmd = []byte(fmt.Sprintf("HWX,%s,%d", asset, dp))
+			cmd = []byte(fmt.Sprintf("HWX,%s,%d,%s,,%s", asset, dp, opts.Direction, opts.PerSend))
 		} else if rangeStr == "MONTHLY" {
-			cmd = []byte(fmt.Sprintf("HMX,%s,%d", asset, dp))
+			cmd = []byte(fmt.Sprintf("HMX,%s,%d,%s,,%s", asset, dp, opts.Direction, opts.PerSend))
 		} else {
 			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[range] not valid, possible=DAILY|WEEKLY|MONTHLY"}); e != nil {
 				slog.Error("HTTP[data] WriteInvalidRange", "e", e.Error())
 			}
 			return
 		}
+		enrichHeaders(w, r, asset)
 	}
 
-	if mode == "chunked" {
+	if mode == "chunked" && !typedChunked(r) {
 		chunkedStream(w, r, cmd, []byte("MessageID, DateStamp, High, Low, Open, Close, PeriodVolume, OpenInterest,"))
 		return
 	}
+	if mode == "chunked" {
+		encodedStream(w, r, cmd, func(bin []byte) (interface{}, error) {
+			return parseDailyBar(bin)
+		})
+		return
+	}
 
-	if dp+100 > MaxDatapoints {
-		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d datapoints, please set mode=chunked", MaxDatapoints)}); e != nil {
+	if dp == 0 || dp+100 > MaxDatapoints {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d datapoints, please set datapoints or mode=chunked", MaxDatapoints)}); e != nil {
 			slog.Error("HTTP[data] WriteMaxDatapoints", "e", e.Error())
 		}
 		return
 	}
 
 	// Parse lines
-	out := make([]OHLC, 0, dp)
+	out := make([]DailyBar, 0, dp)
 	i := 0
-	if e := proxy(cmd, dp+100, func(bin []byte) error {
-		buf := bytes.SplitN(bin, []byte(","), 9)
-		if len(buf) < 7 {
-			return fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	if e := cachedProxy(w, r, creq, cmd, dp+100, func(bin []byte) error {
+		bar, e := parseDailyBar(bin)
+		if e != nil {
+			return e
 		}
-
-		// LH,2023-05-25,288.8400,272.8500,287.9100,280.9900,878367,0,
 		i++
-		out = append(out, OHLC{
-			Datetime: string(buf[1]),
-			High:     string(buf[2]),
-			Low:      string(buf[3]),
-			Open:     string(buf[4]),
-			Close:    string(buf[5]),
-			Volume:   string(buf[6]),
-		})
+		out = append(out, bar)
 		return nil
 
 	}); e != nil {
@@ -363,6 +690,8 @@ func intervals(w http.ResponseWriter, r *http.Request) {
 		interval int
 		dp       int
 		mode     string
+		creq     *CacheReq
+		areq     *AggReq
 	)
 	{
 		asset := r.URL.Query().Get("asset")
@@ -380,41 +709,185 @@ func intervals(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		var e error
-		interval, e = strconv.Atoi(intervalStr)
+		interval, e = parseInterval(intervalStr)
 		if e != nil {
-			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[interval] not a number"}); e != nil {
-				slog.Error("HTTP[intervals] WriteIntervalNaN", "e", e.Error())
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[interval] invalid", Detail: e.Error()}); e != nil {
+				slog.Error("HTTP[intervals] WriteIntervalInvalid", "e", e.Error())
 			}
 			return
 		}
-		// TODO: Something fancy here to validate the interval?
+
+		// Build bars ourselves when asked to align on a session or build from minutes/ticks
+		sessionStr := r.URL.Query().Get("session")
+		source := r.URL.Query().Get("source")
+		if sessionStr != "" || source != "" {
+			x, errRes := parseExchange(r)
+			if errRes != nil {
+				if e := writer.Err(w, r, 400, *errRes); e != nil {
+					slog.Error("HTTP[intervals] WriteInvalidExchange", "e", e.Error())
+				}
+				return
+			}
+			areq = &AggReq{Asset: asset, Interval: interval, Session: Session{Name: sessionStr, Exchange: x}}
+			if sessionStr != "" && sessionStr != "rth" && sessionStr != "eth" {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[session] invalid, possible=rth|eth"}); e != nil {
+					slog.Error("HTTP[intervals] WriteInvalidSession", "e", e.Error())
+				}
+				return
+			}
+			if length := areq.Session.length(); time.Duration(interval)*time.Second > length {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[interval] longer than the session", Detail: fmt.Sprintf("max %s", length)}); e != nil {
+					slog.Error("HTTP[intervals] WriteIntervalSession", "e", e.Error())
+				}
+				return
+			}
+			switch source {
+			case "":
+				// minute bars can only build whole minutes
+				areq.Ticks = interval%60 != 0
+			case "minute":
+				if interval%60 != 0 {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[source] minute needs an interval of whole minutes"}); e != nil {
+						slog.Error("HTTP[intervals] WriteInvalidSource", "e", e.Error())
+					}
+					return
+				}
+			case "tick":
+				areq.Ticks = true
+			default:
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[source] invalid, possible=minute|tick"}); e != nil {
+					slog.Error("HTTP[intervals] WriteInvalidSource", "e", e.Error())
+				}
+				return
+			}
+		}
 
 		dpStr := r.URL.Query().Get("datapoints")
-		if dpStr == "" {
-			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] missing"}); e != nil {
-				slog.Error("HTTP[intervals] WriteDtapointsMissing", "e", e.Error())
+		if dpStr != "" {
+			dp, e = strconv.Atoi(dpStr)
+			if e != nil {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] not a number"}); e != nil {
+					slog.Error("HTTP[intervals] WriteDtapointsMissing", "e", e.Error())
+				}
+				return
+			}
+		}
+		opts, errRes := parseHistOpts(r)
+		if errRes != nil {
+			if e := writer.Err(w, r, 400, *errRes); e != nil {
+				slog.Error("HTTP[intervals] WriteInvalidOpts", "e", e.Error())
 			}
 			return
 		}
-		dp, e = strconv.Atoi(dpStr)
-		if e != nil {
-			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] not a number"}); e != nil {
+		if areq != nil {
+			if opts.BeginFilter != "" || opts.EndFilter != "" {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[beginFilter|endFilter] not possible on aggregated bars, use session=rth|eth"}); e != nil {
+					slog.Error("HTTP[intervals] WriteInvalidFilter", "e", e.Error())
+				}
+				return
+			}
+			areq.Last = dp
+			areq.Oldest = opts.Direction == "1"
+		}
+
+		mode = r.URL.Query().Get("mode")
+		daysStr := r.URL.Query().Get("days")
+		begin := r.URL.Query().Get("begin")
+		end := r.URL.Query().Get("end")
+		if begin != "" || end != "" {
+			if begin != "" {
+				if begin, e = iqDatetime(begin); e != nil {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[begin] invalid", Detail: e.Error()}); e != nil {
+						slog.Error("HTTP[intervals] WriteInvalidBegin", "e", e.Error())
+					}
+					return
+				}
+			}
+			if end != "" {
+				if end, e = iqDatetime(end); e != nil {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[end] invalid", Detail: e.Error()}); e != nil {
+						slog.Error("HTTP[intervals] WriteInvalidEnd", "e", e.Error())
+					}
+					return
+				}
+			}
+			if areq != nil {
+				areq.Begin, areq.End = begin, end
+			}
+			// HIT,[Symbol],[Interval],[BeginDate Time],[EndDate Time],[MaxDatapoints],[BeginFilterTime],[EndFilterTime],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HIT,%s,%d,%s,%s,%s,%s,%s,%s,,%s", asset, interval, begin, end, iqMaxDatapoints(dp), opts.BeginFilter, opts.EndFilter, opts.Direction, opts.PerSend))
+			if areq == nil && begin != "" && opts.BeginFilter == "" && opts.EndFilter == "" {
+				creq = &CacheReq{Asset: asset, Interval: interval, Max: dp, Oldest: opts.Direction == "1"}
+				creq.From, _ = time.ParseInLocation("20060102 150405", begin, exchangeTZ)
+				creq.To = time.Now()
+				if end != "" {
+					creq.To, _ = time.ParseInLocation("20060102 150405", end, exchangeTZ)
+				}
+			}
+		} else if daysStr != "" {
+			days, e := strconv.Atoi(daysStr)
+			if e != nil || days < 1 {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[days] not a positive number"}); e != nil {
+					slog.Error("HTTP[intervals] WriteDaysNaN", "e", e.Error())
+				}
+				return
+			}
+			if areq != nil {
+				areq.Days = days
+			}
+			// HID,[Symbol],[Interval],[Days],[MaxDatapoints],[BeginFilterTime],[EndFilterTime],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HID,%s,%d,%d,%s,%s,%s,%s,,%s", asset, interval, days, iqMaxDatapoints(dp), opts.BeginFilter, opts.EndFilter, opts.Direction, opts.PerSend))
+		} else if dpStr == "" {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints|days|begin+end] missing"}); e != nil {
 				slog.Error("HTTP[intervals] WriteDtapointsMissing", "e", e.Error())
 			}
 			return
+		} else {
+			if opts.BeginFilter != "" || opts.EndFilter != "" {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[beginFilter|endFilter] only possible with days or begin+end"}); e != nil {
+					slog.Error("HTTP[intervals] WriteInvalidFilter", "e", e.Error())
+				}
+				return
+			}
+			// HIX,[Symbol],[Interval],[MaxDatapoints],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HIX,%s,%d,%d,%s,,%s", asset, interval, dp, opts.Direction, opts.PerSend))
+			if dp > 0 && areq == nil {
+				creq = &CacheReq{Asset: asset, Interval: interval, Last: dp, Oldest: opts.Direction == "1"}
+			}
 		}
+		enrichHeaders(w, r, asset)
+	}
 
-		mode = r.URL.Query().Get("mode")
-		cmd = []byte(fmt.Sprintf("HIX,%s,%d,%d", asset, interval, dp))
+	if areq != nil {
+		if mode == "chunked" {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[mode] chunked not possible on aggregated bars"}); e != nil {
+				slog.Error("HTTP[intervals] WriteInvalidMode", "e", e.Error())
+			}
+			return
+		}
+		if dp == 0 || dp+100 > MaxDatapoints {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d datapoints on aggregated bars, please set datapoints", MaxDatapoints)}); e != nil {
+				slog.Error("HTTP[intervals] WriteMaxDatapoints", "e", e.Error())
+			}
+			return
+		}
+		aggregated(w, r, areq)
+		return
 	}
 
-	if mode == "chunked" {
+	if mode == "chunked" && !typedChunked(r) {
 		chunkedStream(w, r, cmd, []byte("MessageID, TimeStamp, High, Low, Open, Close, TotalVolume, PeriodVolume, NumberofTrades,"))
 		return
 	}
+	if mode == "chunked" {
+		encodedStream(w, r, cmd, func(bin []byte) (interface{}, error) {
+			return parseIntervalBar(bin)
+		})
+		return
+	}
 
-	if dp+100 > MaxDatapoints {
-		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d datapoints, please set mode=chunked", MaxDatapoints)}); e != nil {
+	if dp == 0 || dp+100 > MaxDatapoints {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d datapoints, please set datapoints or mode=chunked", MaxDatapoints)}); e != nil {
 			slog.Error("HTTP[intervals] WriteMaxDatapoints", "e", e.Error())
 		}
 		return
@@ -422,23 +895,14 @@ func intervals(w http.ResponseWriter, r *http.Request) {
 
 	// Parse lines
 	i := 0
-	out := make([]OHLC, 0, dp)
-	if e := proxy(cmd, dp+100, func(bin []byte) error {
-		buf := bytes.SplitN(bin, []byte(","), 9)
-		if len(buf) < 7 {
-			return fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	out := make([]IntervalBar, 0, dp)
+	if e := cachedProxy(w, r, creq, cmd, dp+100, func(bin []byte) error {
+		bar, e := parseIntervalBar(bin)
+		if e != nil {
+			return e
 		}
-
-		// LH,2023-05-25,288.8400,272.8500,287.9100,280.9900,878367,0,
 		i++
-		out = append(out, OHLC{
-			Datetime: string(buf[1]),
-			High:     string(buf[2]),
-			Low:      string(buf[3]),
-			Open:     string(buf[4]),
-			Close:    string(buf[5]),
-			Volume:   string(buf[6]),
-		})
+		out = append(out, bar)
 		return nil
 
 	}); e != nil {
@@ -462,16 +926,44 @@ func intervals(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func httpListen(addr string) {
+func httpListen(addr string) *http.Server {
 	// HTTP server
 	mux.Title = "IQ API"
 	mux.Desc = "IQConnect HTTP abstraction"
 	mux.Add("/", doc, "This documentation")
 	mux.Add("/verbose", verbose, "Toggle verbosity-mode")
 
-	mux.Add("/ohlc", data, "Read OHLC ?asset=AAPL&range=DAILY|WEEKLY|MONTHLY&datapoints=10")
-	mux.Add("/ohlc-intervals", intervals, "Read OHLC (interval in seconds) ?asset=AAPL&interval=100&datapoints=10")
-	mux.Add("/search", search, "Search assets ?field=SYMBOL|DESCRIPTION&search=*&type=EQUITY")
+	mux.Add("/ohlc", data, "Read OHLC ?asset=AAPL&range=DAILY|WEEKLY|MONTHLY&datapoints=10 OR range=DAILY&begin=20240102&end=20240201 OR range=DAILY&tradingDays=5 (optional &exchange=NYSE&direction=newest|oldest&perSend=500&cache=bypass&names=1)")
+	mux.Add("/ohlc-intervals", intervals, "Read OHLC (interval in seconds or 90s|7m|2h) ?asset=AAPL&interval=100&datapoints=10 OR &days=5 OR &begin=20240102 093000&end=20240102 160000 (optional &beginFilter=09:30:00&endFilter=16:00:00&direction=newest|oldest&perSend=500&cache=bypass&names=1, &session=rth|eth&source=minute|tick&exchange=NYSE builds the bars server-side on the trading calendar, needs datapoints)")
+	mux.Add("/batch/ohlc", batchOHLC, "POST JSON {\"symbols\":[\"AAPL\",\"MSFT\"],\"range\":\"DAILY\",\"datapoints\":10} (or begin/end/tradingDays, direction, parallel) streams NDJSON or CSV (Accept: text/csv), errors per symbol")
+	mux.Add("/ticks", ticks, "Read ticks ?asset=AAPL&datapoints=10 OR &days=1 OR &begin=20240102 093000&end=20240102 160000 (optional &beginFilter=09:30:00&endFilter=16:00:00&direction=newest|oldest&perSend=500)")
+	mux.Add("/fundamentals", fundamental, "Fundamental snapshot (Level 1 F-msg) ?asset=AAPL (optional &cache=bypass)")
+	mux.Add("/quote", quote, "Snapshot quote (Level 1 P-msg) ?symbols=AAPL,MSFT (optional &fields=Most Recent Trade,Bid,Ask,Total Volume) reports unknown symbols in NotFound")
+	mux.Add("/stream", stream, "Stream Level 1 updates (Q/P/F) as WebSocket or Server-Sent Events ?symbols=AAPL,MSFT")
+	mux.Add("/depth", depthBook, "Level 2 book ?asset=AAPL&type=price|order (optional &mode=stream for incremental updates as WebSocket/SSE)")
+	mux.Add("/chains/options", chainsOptions, "Equity option chain (CEO) ?asset=AAPL (optional &side=calls|puts|both&months=1,2|A,B&near=1&binary=1&strikeFrom=150&strikeTo=200 OR &itm=5&otm=5)")
+	mux.Add("/chains/futures", chainsFutures, "Future chain (CFU) ?asset=@ES (optional &months=3,6|H,M&years=2024,2025&near=4)")
+	mux.Add("/chains/future-options", chainsFutureOptions, "Future option chain (CFO) ?asset=@ES (optional &side=calls|puts|both&months=3,6&years=2024&near=2&strikeFrom=4000&strikeTo=6000)")
+	mux.Add("/chains/spreads", chainsSpreads, "Future spread chain (CFS) ?asset=@ES (optional &months=3,6&years=2024&near=4)")
+	mux.Add("/news/headlines", newsHeadlines, "News headlines (NHL) (optional ?symbols=AAPL,MSFT&sources=DTN&limit=100&date=20240102 OR &from=20240102&to=20240105&format=x|t)")
+	mux.Add("/news/story/", newsStory, "News story (NSS) /news/story/{id} (optional ?format=x|t)")
+	mux.Add("/news/story-count", newsStoryCount, "News story count (NSC) ?symbols=AAPL,MSFT (optional &sources=DTN&from=20240102&to=20240105&format=x|t)")
+	mux.Add("/news/configuration", newsConfiguration, "News sources (NCG)")
+	mux.Add("/ref/markets", refHandler("markets", refTables.markets.List), "Listed markets (SLM)")
+	mux.Add("/ref/security-types", refHandler("security-types", refTables.types.List), "Security types (SST)")
+	mux.Add("/ref/trade-conditions", refHandler("trade-conditions", refTables.conditions.List), "Trade conditions (STC)")
+	mux.Add("/ref/sic", refHandler("sic", refTables.sic.List), "SIC codes (SSC)")
+	mux.Add("/ref/naics", refHandler("naics", refTables.naics.List), "NAICS codes (SNC)")
+	mux.Add("/cache", cacheStats, "Bar cache hit/partial/miss counters")
+	mux.Add("/admin/processes", adminAuth(adminProcesses), "Supervised processes with pid, uptime, restarts and last exit code (Authorization: Bearer <admin.token>)")
+	mux.Add("/admin/processes/", adminAuth(adminProcessRestart), "POST /admin/processes/{name}/restart restarts a supervised process (Authorization: Bearer <admin.token>)")
+	mux.Add("/admin/iqfeed/connect", adminAuth(adminIQFeed("S,CONNECT")), "POST sends S,CONNECT on the admin port (Authorization: Bearer <admin.token>)")
+	mux.Add("/admin/iqfeed/disconnect", adminAuth(adminIQFeed("S,DISCONNECT")), "POST sends S,DISCONNECT on the admin port (Authorization: Bearer <admin.token>)")
+	mux.Add("/admin/logs/", adminAuth(adminLogs), "Output of a supervised process /admin/logs/{name} (optional ?lines=100&follow=1) (Authorization: Bearer <admin.token>)")
+	mux.Add("/search", search, "Search assets ?field=SYMBOL|DESCRIPTION&search=*&type=EQUITY (optional &names=1 adds market/type names)")
+	mux.Add("/symbols", symbols, "Lookup symbols ?by=symbol|description|sic|naics|chain&search=AAP (optional &market=NYSE,NASDAQ&type=EQUITY,INDEX as name or ID)")
+
+	mux.Add("/metrics", metrics, "Prometheus metrics (HTTP/upstream requests, pool, processes, IQFeed S,STATS)")
 
 	// pprof
 	mux.Add("/debug/pprof/", pprof.Index, "performance-profiler")
@@ -484,18 +976,23 @@ func httpListen(addr string) {
 	server := &http.Server{
 		Addr:        addr,
 		TLSConfig:   nil,
-		Handler:     mux.Mux,
+		Handler:     instrument(mux.Mux),
 		ReadTimeout: 5 * time.Second,
 		// WriteTimeout: 10 * time.Second,
 		IdleTimeout: 20 * time.Second,
 	}
 
+	server.RegisterOnShutdown(func() { close(httpShutdown) })
+
 	ln, e = net.Listen("tcp", server.Addr)
 	if e != nil {
 		panic(e)
 	}
 
-	if e := server.Serve(tcpKeepAliveListener{ln.(*net.TCPListener)}); e != nil {
-		panic(e)
-	}
+	go func() {
+		if e := server.Serve(tcpKeepAliveListener{ln.(*net.TCPListener)}); e != nil && e != http.ErrServerClosed {
+			panic(e)
+		}
+	}()
+	return server
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+)
+
+/** defaultLogTail is the amount of lines /admin/logs returns without ?lines */
+const defaultLogTail = 100
+
+// AdminToken is the bearer token of the /admin API, empty disables it
+var AdminToken string
+
+// adminAuth only calls fn with "Authorization: Bearer <AdminToken>"
+func adminAuth(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if AdminToken == "" {
+			if e := writer.Err(w, r, 403, writer.ErrorRes{Error: "Admin API disabled", Detail: "set admin.token or IQAPI_ADMIN_TOKEN"}); e != nil {
+				slog.Error("HTTP[adminAuth] WriteDisabled", "e", e.Error())
+			}
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			slog.Warn("HTTP[adminAuth] denied", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="iqapi admin"`)
+			if e := writer.Err(w, r, 401, writer.ErrorRes{Error: "Authorization: Bearer <admin.token> missing or invalid"}); e != nil {
+				slog.Error("HTTP[adminAuth] WriteDenied", "e", e.Error())
+			}
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// ProcessRes is a supervised process in /admin/processes
+type ProcessRes struct {
+	ProcState
+	Uptime string `json:",omitempty"` // when State=running
+}
+
+// adminProcesses lists the supervised processes
+func adminProcesses(w http.ResponseWriter, r *http.Request) {
+	var res []ProcessRes
+	for _, p := range Processes() {
+		item := ProcessRes{ProcState: p}
+		if p.State == ProcRunning {
+			item.Uptime = time.Since(p.Started).Round(time.Second).String()
+		}
+		res = append(res, item)
+	}
+	if e := writer.Encode(w, r, 200, res); e != nil {
+		slog.Error("HTTP[adminProcesses] Write", "e", e.Error())
+	}
+}
+
+// adminProcessRestart restarts the process in /admin/processes/{name}/restart
+func adminProcessRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		if e := writer.Err(w, r, 405, writer.ErrorRes{Error: "POST only"}); e != nil {
+			slog.Error("HTTP[adminProcessRestart] WriteMethod", "e", e.Error())
+		}
+		return
+	}
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/processes/"), "/restart")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "Expected /admin/processes/{name}/restart"}); e != nil {
+			slog.Error("HTTP[adminProcessRestart] WritePath", "e", e.Error())
+		}
+		return
+	}
+
+	slog.Info("HTTP[adminProcessRestart]", "name", name, "remote", r.RemoteAddr)
+	if e := RestartProcess(name); e == errUnknownProcess {
+		var names []string
+		for _, p := range Processes() {
+			names = append(names, p.Name)
+		}
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "Process unknown", Detail: "possible=" + strings.Join(names, "|")}); e != nil {
+			slog.Error("HTTP[adminProcessRestart] WriteUnknown", "e", e.Error())
+		}
+		return
+	} else if e != nil {
+		if e := writer.Err(w, r, 409, writer.ErrorRes{Error: "Restart failed", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[adminProcessRestart] WriteRestart", "e", e.Error())
+		}
+		return
+	}
+
+	p, _ := Process(name)
+	if e := writer.Encode(w, r, 200, p); e != nil {
+		slog.Error("HTTP[adminProcessRestart] Write", "e", e.Error())
+	}
+}
+
+// adminIQFeed sends S,CONNECT or S,DISCONNECT for /admin/iqfeed/connect|disconnect
+func adminIQFeed(cmd string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			if e := writer.Err(w, r, 405, writer.ErrorRes{Error: "POST only"}); e != nil {
+				slog.Error("HTTP[adminIQFeed] WriteMethod", "e", e.Error())
+			}
+			return
+		}
+		if e := adminCmd(cmd); e != nil {
+			if e := writer.Err(w, r, 502, writer.ErrorRes{Error: "Admin port failed", Detail: e.Error()}); e != nil {
+				slog.Error("HTTP[adminIQFeed] WriteCmd", "e", e.Error())
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, e := w.Write([]byte(`{"success": true, "msg": "Sent ` + cmd + `"}`)); e != nil {
+			slog.Error("HTTP[adminIQFeed] Write", "e", e.Error())
+		}
+	}
+}
+
+// adminLogs returns the output of a supervised process as text,
+// with ?follow=1 new lines are streamed until the client leaves
+func adminLogs(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/admin/logs/")
+	names := ProcessLogNames()
+	found := false
+	for _, n := range names {
+		found = found || n == name
+	}
+	if !found {
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "Process unknown", Detail: "possible=" + strings.Join(names, "|")}); e != nil {
+			slog.Error("HTTP[adminLogs] WriteUnknown", "e", e.Error())
+		}
+		return
+	}
+
+	n := defaultLogTail
+	if val := r.URL.Query().Get("lines"); val != "" {
+		var e error
+		if n, e = strconv.Atoi(val); e != nil || n < 0 {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[lines] not a positive number"}); e != nil {
+				slog.Error("HTTP[adminLogs] WriteLines", "e", e.Error())
+			}
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "1"
+	flusher, ok := w.(http.Flusher)
+	if follow && !ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Could not get Flusher-instance"}); e != nil {
+			slog.Error("HTTP[adminLogs] getFlusher", "e", e.Error())
+		}
+		return
+	}
+
+	ring := ProcessLog(name)
+	// subscribe before the tail so no line is lost in between
+	var lines <-chan LogLine
+	if follow {
+		var stop func()
+		lines, stop = ring.Follow()
+		defer stop()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	var tail []LogLine
+	if n > 0 {
+		tail = ring.Tail(n)
+	}
+	for _, l := range tail {
+		if _, e := w.Write([]byte(l.String() + "\n")); e != nil {
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+	flusher.Flush()
+
+	last := len(tail) - 1
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-httpShutdown:
+			return
+		case l := <-lines:
+			// skip lines the tail already had
+			if last >= 0 && !l.Time.After(tail[last].Time) {
+				continue
+			}
+			if _, e := w.Write([]byte(l.String() + "\n")); e != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdminAuth(t *testing.T) {
+	old := AdminToken
+	defer func() { AdminToken = old }()
+	h := adminAuth(adminProcesses)
+
+	for _, c := range []struct {
+		token  string
+		header string
+		code   int
+	}{
+		{"", "Bearer ", 403},
+		{"0123456789abcdef", "", 401},
+		{"0123456789abcdef", "Bearer 0123456789abcdeX", 401},
+		{"0123456789abcdef", "Basic 0123456789abcdef", 401},
+		{"0123456789abcdef", "Bearer 0123456789abcdef", 200},
+	} {
+		AdminToken = c.token
+		req := httptest.NewRequest("GET", "/admin/processes", nil)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+		res := httptest.NewRecorder()
+		h(res, req)
+		if res.Code != c.code {
+			t.Errorf("token=%s header=%s code=%d body=%s", c.token, c.header, res.Code, res.Body.String())
+		}
+	}
+}
+
+func TestAdminProcessRestart(t *testing.T) {
+	if Running == nil {
+		Running = new(sync.Map)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	// long backoff keeps it down after the test kills it
+	ensureRunning(&wg, map[string]CmdInfo{"test-restart": {Cmd: "/bin/sh", Args: []string{"-c", "exec sleep 30"}, Restart: RestartPolicy{Backoff: time.Hour, MaxBackoff: time.Hour}}})
+	defer killProcess("test-restart")
+
+	waitState := func(expect func(p ProcState) bool) ProcState {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			p, _ := Process("test-restart")
+			if expect(p) {
+				return p
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("state=%+v", p)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	first := waitState(func(p ProcState) bool { return p.State == ProcRunning })
+
+	res := httptest.NewRecorder()
+	adminProcesses(res, httptest.NewRequest("GET", "/admin/processes", nil))
+	var list []ProcessRes
+	if e := json.Unmarshal(res.Body.Bytes(), &list); e != nil {
+		t.Fatalf("e=%s body=%s", e, res.Body.String())
+	}
+	found := false
+	for _, p := range list {
+		found = found || p.Name == "test-restart" && p.Pid == first.Pid && p.Uptime != ""
+	}
+	if !found {
+		t.Errorf("list=%s", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	adminProcessRestart(res, httptest.NewRequest("POST", "/admin/processes/test-restart/restart", nil))
+	if res.Code != 200 {
+		t.Fatalf("code=%d body=%s", res.Code, res.Body.String())
+	}
+	// restarted without the hour of backoff
+	second := waitState(func(p ProcState) bool { return p.State == ProcRunning && p.Pid != first.Pid })
+	if second.Restarts != 1 || second.LastExit != -1 {
+		t.Errorf("state=%+v", second)
+	}
+
+	res = httptest.NewRecorder()
+	adminProcessRestart(res, httptest.NewRequest("POST", "/admin/processes/nope/restart", nil))
+	if res.Code != 404 || !strings.Contains(res.Body.String(), "test-restart") {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+	res = httptest.NewRecorder()
+	adminProcessRestart(res, httptest.NewRequest("GET", "/admin/processes/test-restart/restart", nil))
+	if res.Code != 405 {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+}
+
+func TestAdminIQFeed(t *testing.T) {
+	f := fakeUpstream(t)
+	Running.Store("iqfeed", 1)
+	defer Running.Delete("iqfeed")
+
+	res := httptest.NewRecorder()
+	adminIQFeed("S,DISCONNECT")(res, httptest.NewRequest("POST", "/admin/iqfeed/disconnect", nil))
+	if res.Code != 200 {
+		t.Fatalf("code=%d body=%s", res.Code, res.Body.String())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(f.stats(), ",Not Connected,") {
+		if time.Now().After(deadline) {
+			t.Fatal("S,DISCONNECT not received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res = httptest.NewRecorder()
+	adminIQFeed("S,CONNECT")(res, httptest.NewRequest("POST", "/admin/iqfeed/connect", nil))
+	for !strings.Contains(f.stats(), ",Connected,") {
+		if time.Now().After(deadline) {
+			t.Fatal("S,CONNECT not received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Running.Delete("iqfeed")
+	res = httptest.NewRecorder()
+	adminIQFeed("S,CONNECT")(res, httptest.NewRequest("POST", "/admin/iqfeed/connect", nil))
+	if res.Code != 502 || !strings.Contains(res.Body.String(), "iqfeed not running") {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+}
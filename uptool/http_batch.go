@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** maxBatchSymbols is the maximum of symbols in one batch */
+const maxBatchSymbols = 10000
+
+/** maxBatchBody is the maximum size of the batch request */
+const maxBatchBody = 1 << 20
+
+// BatchParallel is the max concurrent upstream requests of one batch
+var BatchParallel = 8
+
+// BatchReq is the body of POST /batch/ohlc
+type BatchReq struct {
+	Symbols     []string `json:"symbols"`
+	Range       string   `json:"range"` // DAILY|WEEKLY|MONTHLY
+	Datapoints  int      `json:"datapoints"`
+	Begin       string   `json:"begin"` // range=DAILY only
+	End         string   `json:"end"`
+	TradingDays int      `json:"tradingDays"` // range=DAILY only
+	Exchange    string   `json:"exchange"`
+	Direction   string   `json:"direction"` // newest|oldest
+	Parallel    int      `json:"parallel"`  // capped by BatchParallel
+}
+
+// BatchRes is one symbol of the batch
+type BatchRes struct {
+	Symbol string
+	Bars   []DailyBar `json:",omitempty"`
+	Error  string     `json:",omitempty"`
+}
+
+// cmd returns the upstream cmd (and cache request) for asset
+func (b *BatchReq) cmd(asset string, x *Exchange) ([]byte, *CacheReq) {
+	direction := ""
+	if b.Direction == "oldest" {
+		direction = "1"
+	}
+	oldest := direction == "1"
+
+	switch {
+	case b.TradingDays > 0:
+		from := x.TradingDaysBack(b.TradingDays, time.Now())
+		return []byte(fmt.Sprintf("HDT,%s,%s,,%s,%s", asset, from.Format("20060102"), iqMaxDatapoints(b.Datapoints), direction)),
+			&CacheReq{Asset: asset, From: from, To: time.Now(), Max: b.Datapoints, Oldest: oldest}
+
+	case b.Begin != "" || b.End != "":
+		cmd := []byte(fmt.Sprintf("HDT,%s,%s,%s,%s,%s", asset, b.Begin, b.End, iqMaxDatapoints(b.Datapoints), direction))
+		if b.Begin == "" {
+			return cmd, nil
+		}
+		creq := &CacheReq{Asset: asset, Max: b.Datapoints, Oldest: oldest}
+		creq.From, _ = time.ParseInLocation("20060102", b.Begin, exchangeTZ)
+		creq.To = time.Now()
+		if b.End != "" {
+			creq.To, _ = time.ParseInLocation("20060102", b.End, exchangeTZ)
+		}
+		return cmd, creq
+
+	case b.Range == "WEEKLY":
+		return []byte(fmt.Sprintf("HWX,%s,%d,%s", asset, b.Datapoints, direction)), nil
+	case b.Range == "MONTHLY":
+		return []byte(fmt.Sprintf("HMX,%s,%d,%s", asset, b.Datapoints, direction)), nil
+	}
+	return []byte(fmt.Sprintf("HDX,%s,%d,%s", asset, b.Datapoints, direction)),
+		&CacheReq{Asset: asset, Last: b.Datapoints, Oldest: oldest}
+}
+
+// validate checks the batch and normalizes the symbols/dates
+func (b *BatchReq) validate() *writer.ErrorRes {
+	b.Symbols = parseSymbols(strings.Join(b.Symbols, ","))
+	if len(b.Symbols) == 0 {
+		return &writer.ErrorRes{Error: "symbols missing"}
+	}
+	if len(b.Symbols) > maxBatchSymbols {
+		return &writer.ErrorRes{Error: "symbols too many", Detail: fmt.Sprintf("max %d symbols", maxBatchSymbols)}
+	}
+	switch b.Range {
+	case "DAILY", "WEEKLY", "MONTHLY":
+	default:
+		return &writer.ErrorRes{Error: "range not valid, possible=DAILY|WEEKLY|MONTHLY"}
+	}
+	switch b.Direction {
+	case "", "newest", "oldest":
+	default:
+		return &writer.ErrorRes{Error: "direction invalid, possible=newest|oldest"}
+	}
+	if b.Datapoints < 0 || b.TradingDays < 0 {
+		return &writer.ErrorRes{Error: "datapoints|tradingDays not a positive number"}
+	}
+
+	var e error
+	if b.Begin != "" || b.End != "" || b.TradingDays > 0 {
+		if b.Range != "DAILY" {
+			return &writer.ErrorRes{Error: "begin|end|tradingDays only possible with range=DAILY"}
+		}
+		if b.TradingDays > 0 && (b.Begin != "" || b.End != "") {
+			return &writer.ErrorRes{Error: "tradingDays not possible with begin|end"}
+		}
+		if b.TradingDays > 0 && b.Datapoints == 0 {
+			b.Datapoints = b.TradingDays
+		}
+		if b.Begin != "" {
+			if b.Begin, e = iqDate(b.Begin); e != nil {
+				return &writer.ErrorRes{Error: "begin invalid", Detail: e.Error()}
+			}
+		}
+		if b.End != "" {
+			if b.End, e = iqDate(b.End); e != nil {
+				return &writer.ErrorRes{Error: "end invalid", Detail: e.Error()}
+			}
+		}
+	}
+	// every symbol is kept in memory until written
+	if b.Datapoints == 0 || b.Datapoints+100 > MaxDatapoints {
+		return &writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("datapoints must be 1..%d", MaxDatapoints-100)}
+	}
+
+	if b.Parallel <= 0 || b.Parallel > BatchParallel {
+		b.Parallel = BatchParallel
+	}
+	if b.Parallel < 1 {
+		b.Parallel = 1
+	}
+	return nil
+}
+
+// fetch returns the bars of asset, errors are reported inline
+func (b *BatchReq) fetch(asset string, x *Exchange) BatchRes {
+	res := BatchRes{Symbol: asset}
+	cmd, creq := b.cmd(asset, x)
+	if _, e := cachedLines(creq, cmd, b.Datapoints+100, func(bin []byte) error {
+		bar, e := parseDailyBar(bin)
+		if e != nil {
+			return e
+		}
+		res.Bars = append(res.Bars, bar)
+		return nil
+	}); e != nil {
+		res.Bars = nil
+		res.Error = e.Error()
+	}
+	return res
+}
+
+// batchWriter writes BatchRes as NDJSON or CSV tagged by symbol
+type batchWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	json    *json.Encoder
+	csv     *csv.Writer
+}
+
+func (bw *batchWriter) Write(res BatchRes) error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	defer bw.flusher.Flush()
+
+	if bw.json != nil {
+		return bw.json.Encode(res)
+	}
+
+	if res.Error != "" {
+		row := make([]string, len(DailyBar{}.CSVHeader())+2)
+		row[0] = res.Symbol
+		row[len(row)-1] = res.Error
+		if e := bw.csv.Write(row); e != nil {
+			return e
+		}
+	}
+	for _, bar := range res.Bars {
+		if e := bw.csv.Write(append(append([]string{res.Symbol}, bar.CSVRecord()...), "")); e != nil {
+			return e
+		}
+	}
+	bw.csv.Flush()
+	return bw.csv.Error()
+}
+
+func batchOHLC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		if e := writer.Err(w, r, 405, writer.ErrorRes{Error: "POST only"}); e != nil {
+			slog.Error("HTTP[batchOHLC] WriteMethod", "e", e.Error())
+		}
+		return
+	}
+
+	var req BatchReq
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&req); e != nil {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Invalid JSON", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[batchOHLC] WriteInvalidJSON", "e", e.Error())
+		}
+		return
+	}
+	if errRes := req.validate(); errRes != nil {
+		if e := writer.Err(w, r, 400, *errRes); e != nil {
+			slog.Error("HTTP[batchOHLC] WriteInvalidReq", "e", e.Error())
+		}
+		return
+	}
+	x, ok := calendar.Exchange(req.Exchange)
+	if !ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "exchange unknown", Detail: "possible=" + strings.Join(calendar.Names(), "|")}); e != nil {
+			slog.Error("HTTP[batchOHLC] WriteInvalidExchange", "e", e.Error())
+		}
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Could not get Flusher-instance"}); e != nil {
+			slog.Error("HTTP[batchOHLC] getFlusher", "e", e.Error())
+		}
+		return
+	}
+	bw := &batchWriter{w: w, flusher: flusher}
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		bw.csv = csv.NewWriter(w)
+		if e := bw.csv.Write(append(append([]string{"Symbol"}, DailyBar{}.CSVHeader()...), "Error")); e != nil {
+			slog.Error("HTTP[batchOHLC] WriteHeader", "e", e.Error())
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		bw.json = json.NewEncoder(w)
+	}
+	w.WriteHeader(200)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, req.Parallel)
+	for _, sym := range req.Symbols {
+		select {
+		case sem <- struct{}{}:
+		case <-r.Context().Done():
+			// client left, finish what's running
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(sym string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if r.Context().Err() != nil {
+				return
+			}
+			if e := bw.Write(req.fetch(sym, x)); e != nil {
+				slog.Error("HTTP[batchOHLC] Write", "symbol", sym, "e", e.Error())
+			}
+		}(sym)
+	}
+	wg.Wait()
+}
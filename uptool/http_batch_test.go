@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBatchReq(t *testing.T) {
+	req := BatchReq{Symbols: []string{"aapl", " msft", "AAPL", ""}, Range: "DAILY", Datapoints: 10, Parallel: 1000}
+	if errRes := req.validate(); errRes != nil {
+		t.Fatalf("validate=%+v", errRes)
+	}
+	if len(req.Symbols) != 2 || req.Symbols[0] != "AAPL" || req.Symbols[1] != "MSFT" {
+		t.Errorf("validate symbols=%v", req.Symbols)
+	}
+	if req.Parallel != BatchParallel {
+		t.Errorf("validate parallel=%d not capped", req.Parallel)
+	}
+	x, _ := defaultCalendar().Exchange("")
+	if cmd, creq := req.cmd("AAPL", x); string(cmd) != "HDX,AAPL,10," || creq == nil || creq.Last != 10 {
+		t.Errorf("cmd=%s creq=%+v", cmd, creq)
+	}
+
+	req = BatchReq{Symbols: []string{"AAPL"}, Range: "DAILY", Begin: "2024-01-02", Direction: "oldest"}
+	if errRes := req.validate(); errRes == nil {
+		t.Errorf("validate accepted missing datapoints")
+	}
+	req.Datapoints = 100
+	if errRes := req.validate(); errRes != nil {
+		t.Fatalf("validate=%+v", errRes)
+	}
+	if cmd, creq := req.cmd("AAPL", x); string(cmd) != "HDT,AAPL,20240102,,100,1" || creq == nil || !creq.Oldest {
+		t.Errorf("cmd=%s creq=%+v", cmd, creq)
+	}
+
+	for _, bad := range []BatchReq{
+		{Range: "DAILY", Datapoints: 1},
+		{Symbols: []string{"AAPL"}, Range: "HOURLY", Datapoints: 1},
+		{Symbols: []string{"AAPL"}, Range: "WEEKLY", TradingDays: 5},
+	} {
+		if errRes := bad.validate(); errRes == nil {
+			t.Errorf("validate accepted %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// chainArgs are the shared chain parameters
+type chainArgs struct {
+	Asset  string
+	Side   string // c|p|pc
+	Months []string
+	Years  string
+	Near   string
+
+	StrikeFrom, StrikeTo float64 // 0=unbounded
+}
+
+// parseChainArgs reads asset, side, months, years, near and strikeFrom/strikeTo
+func parseChainArgs(r *http.Request) (chainArgs, *writer.ErrorRes) {
+	var (
+		a chainArgs
+		e error
+	)
+	q := r.URL.Query()
+	if a.Asset = strings.ToUpper(q.Get("asset")); a.Asset == "" {
+		return a, &writer.ErrorRes{Error: "GET[asset] missing"}
+	}
+
+	switch q.Get("side") {
+	case "", "both":
+		a.Side = "pc"
+	case "calls":
+		a.Side = "c"
+	case "puts":
+		a.Side = "p"
+	default:
+		return a, &writer.ErrorRes{Error: "GET[side] invalid, possible=calls|puts|both"}
+	}
+
+	a.Months = parseSymbols(q.Get("months"))
+	if v := parseSymbols(q.Get("years")); len(v) > 0 {
+		if a.Years, e = yearCodes(v); e != nil {
+			return a, &writer.ErrorRes{Error: "GET[years] invalid", Detail: e.Error()}
+		}
+	}
+	if a.Near = q.Get("near"); a.Near != "" {
+		if n, e := strconv.Atoi(a.Near); e != nil || n < 0 {
+			return a, &writer.ErrorRes{Error: "GET[near] not a positive number"}
+		}
+	}
+
+	for key, dst := range map[string]*float64{"strikeFrom": &a.StrikeFrom, "strikeTo": &a.StrikeTo} {
+		if v := q.Get(key); v != "" {
+			if *dst, e = strconv.ParseFloat(v, 64); e != nil || *dst < 0 {
+				return a, &writer.ErrorRes{Error: "GET[" + key + "] not a positive number"}
+			}
+		}
+	}
+	return a, nil
+}
+
+// strikeOK returns if the strike is within strikeFrom/strikeTo
+func (a chainArgs) strikeOK(strike float64) bool {
+	return (a.StrikeFrom == 0 || strike >= a.StrikeFrom) && (a.StrikeTo == 0 || strike <= a.StrikeTo)
+}
+
+// futureMonthCodes returns the CFU/CFO/CFS month codes
+func (a chainArgs) futureMonthCodes() (string, *writer.ErrorRes) {
+	codes, e := monthCodes(a.Months, futureMonths)
+	if e != nil {
+		return "", &writer.ErrorRes{Error: "GET[months] invalid", Detail: e.Error()}
+	}
+	return codes, nil
+}
+
+// chainLookup proxies cmd and writes all decoded contracts that pass keep
+func chainLookup(w http.ResponseWriter, r *http.Request, cmd []byte, decode func(sym string) (Contract, error), keep func(c Contract) bool) {
+	var out []Contract
+	if e := proxy(cmd, -1, func(bin []byte) error {
+		for _, sym := range chainSymbols(bin) {
+			c, e := decode(sym)
+			if e != nil {
+				// unknown format, still a valid contract
+				slog.Warn("HTTP[chainLookup] decode", "e", e.Error())
+			}
+			if e == nil && keep != nil && !keep(c) {
+				continue
+			}
+			out = append(out, c)
+		}
+		return nil
+	}); e != nil {
+		slog.Error("HTTP[chainLookup] proxy", "e", e.Error())
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Upstream error", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[chainLookup] WriteUpstreamError", "e", e.Error())
+		}
+		return
+	}
+
+	if len(out) == 0 {
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "No data"}); e != nil {
+			slog.Error("HTTP[chainLookup] WriteNoData", "e", e.Error())
+		}
+		return
+	}
+	if e := writer.Encode(w, r, 200, out); e != nil {
+		slog.Error("HTTP[chainLookup] WriteEncode", "e", e.Error())
+	}
+}
+
+// chainErr writes errRes
+func chainErr(w http.ResponseWriter, r *http.Request, errRes writer.ErrorRes) {
+	if e := writer.Err(w, r, 400, errRes); e != nil {
+		slog.Error("HTTP[chains] WriteInvalidArgs", "e", e.Error())
+	}
+}
+
+func chainsOptions(w http.ResponseWriter, r *http.Request) {
+	a, errRes := parseChainArgs(r)
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+
+	// calls A-L, puts M-X, accept both (and 1-12) for either side
+	var nums []string
+	for _, m := range a.Months {
+		if i := strings.Index(callMonths+putMonths, m); i != -1 && len(m) == 1 {
+			m = strconv.Itoa(i%12 + 1)
+		}
+		nums = append(nums, m)
+	}
+	var months string
+	for _, side := range []struct{ flag, alphabet string }{{"c", callMonths}, {"p", putMonths}} {
+		if !strings.Contains(a.Side, side.flag) {
+			continue
+		}
+		codes, e := monthCodes(nums, side.alphabet)
+		if e != nil {
+			chainErr(w, r, writer.ErrorRes{Error: "GET[months] invalid", Detail: e.Error()})
+			return
+		}
+		months += codes
+	}
+
+	binary := "0"
+	if r.URL.Query().Get("binary") == "1" {
+		binary = "1"
+	}
+
+	// Filter Type 0=none, 1=strike range, 2=number of contracts in/out of the money
+	filterType, filterOne, filterTwo := "0", "", ""
+	itm, otm := r.URL.Query().Get("itm"), r.URL.Query().Get("otm")
+	if itm != "" || otm != "" {
+		if a.StrikeFrom != 0 || a.StrikeTo != 0 {
+			chainErr(w, r, writer.ErrorRes{Error: "GET[itm|otm] not possible with strikeFrom|strikeTo"})
+			return
+		}
+		for _, v := range []string{itm, otm} {
+			if n, e := strconv.Atoi(v); v != "" && (e != nil || n < 0) {
+				chainErr(w, r, writer.ErrorRes{Error: "GET[itm|otm] not a positive number"})
+				return
+			}
+		}
+		filterType, filterOne, filterTwo = "2", itm, otm
+	} else if a.StrikeFrom != 0 || a.StrikeTo != 0 {
+		filterType = "1"
+		filterOne, filterTwo = formatFloat(a.StrikeFrom), ""
+		if a.StrikeTo != 0 {
+			filterTwo = formatFloat(a.StrikeTo)
+		}
+	}
+
+	// CEO,[Symbol],[Puts/Calls],[Month Codes],[Near Months],[BinaryOptionFilter],[Filter Type],[Filter Value One],[Filter Value Two],[RequestID]
+	cmd := []byte(fmt.Sprintf("CEO,%s,%s,%s,%s,%s,%s,%s,%s", a.Asset, a.Side, months, a.Near, binary, filterType, filterOne, filterTwo))
+	chainLookup(w, r, cmd, decodeOption, nil)
+}
+
+func chainsFutures(w http.ResponseWriter, r *http.Request) {
+	a, errRes := parseChainArgs(r)
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+	months, errRes := a.futureMonthCodes()
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+	// CFU,[Symbol],[Month Codes],[Years],[Near Months],[RequestID]
+	cmd := []byte(fmt.Sprintf("CFU,%s,%s,%s,%s", a.Asset, months, a.Years, a.Near))
+	chainLookup(w, r, cmd, decodeFuture, nil)
+}
+
+func chainsFutureOptions(w http.ResponseWriter, r *http.Request) {
+	a, errRes := parseChainArgs(r)
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+	months, errRes := a.futureMonthCodes()
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+	// CFO,[Symbol],[Puts/Calls],[Month Codes],[Years],[Near Months],[RequestID]
+	cmd := []byte(fmt.Sprintf("CFO,%s,%s,%s,%s,%s", a.Asset, a.Side, months, a.Years, a.Near))
+	// no strike filter upstream
+	chainLookup(w, r, cmd, decodeFutureOption, func(c Contract) bool {
+		return a.strikeOK(c.Strike)
+	})
+}
+
+func chainsSpreads(w http.ResponseWriter, r *http.Request) {
+	a, errRes := parseChainArgs(r)
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+	months, errRes := a.futureMonthCodes()
+	if errRes != nil {
+		chainErr(w, r, *errRes)
+		return
+	}
+	// CFS,[Symbol],[Month Codes],[Years],[Near Months],[RequestID]
+	cmd := []byte(fmt.Sprintf("CFS,%s,%s,%s,%s", a.Asset, months, a.Years, a.Near))
+	chainLookup(w, r, cmd, decodeSpread, nil)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/** depthSnapshotTimeout is the max time we wait for a fresh book */
+const depthSnapshotTimeout = 5 * time.Second
+
+func depthBook(w http.ResponseWriter, r *http.Request) {
+	var (
+		asset string
+		typ   string
+	)
+	{
+		asset = strings.ToUpper(r.URL.Query().Get("asset"))
+		if asset == "" || !validSymbol(asset) {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[asset] missing or invalid"}); e != nil {
+				slog.Error("HTTP[depth] WriteAssetMissing", "e", e.Error())
+			}
+			return
+		}
+
+		switch r.URL.Query().Get("type") {
+		case "", "price":
+			typ = "WPL"
+		case "order":
+			typ = "WOR"
+		default:
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[type] not valid, possible=price|order"}); e != nil {
+				slog.Error("HTTP[depth] WriteInvalidType", "e", e.Error())
+			}
+			return
+		}
+	}
+
+	sub := depth.Subscribe(typ, asset)
+	defer depth.Unsubscribe(sub)
+
+	if r.URL.Query().Get("mode") == "stream" {
+		// Incremental updates, starting with a snapshot
+		events := make(chan DepthMsg)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			defer close(events)
+			book, queued, e := depth.Snapshot(sub, depthSnapshotTimeout)
+			if e != nil {
+				slog.Error("HTTP[depth] Snapshot", "e", e.Error())
+				select {
+				case events <- DepthMsg{Type: "error", Symbol: asset, Error: "No data: " + e.Error()}:
+				case <-done:
+				}
+				return
+			}
+			msg := DepthMsg{Type: "snapshot", Symbol: asset, Book: book}
+			for {
+				select {
+				case events <- msg:
+				case <-done:
+					return
+				}
+
+				var ok bool
+				if msg, ok = <-sub.C; !ok {
+					return
+				}
+				// already part of the snapshot
+				for ; queued > 0 && ok; queued-- {
+					msg, ok = <-sub.C
+				}
+				if !ok {
+					return
+				}
+			}
+		}()
+
+		if websocket.IsWebSocketUpgrade(r) {
+			streamWebsocket(w, r, events)
+			return
+		}
+		streamSSE(w, r, events)
+		return
+	}
+
+	book, _, e := depth.Snapshot(sub, depthSnapshotTimeout)
+	if e != nil {
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "No data", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[depth] WriteNoData", "e", e.Error())
+		}
+		return
+	}
+	if e := writer.Encode(w, r, 200, book); e != nil {
+		slog.Error("HTTP[depth] WriteEncode", "e", e.Error())
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+func fundamental(w http.ResponseWriter, r *http.Request) {
+	asset := strings.ToUpper(r.URL.Query().Get("asset"))
+	if asset == "" || strings.ContainsAny(asset, ",\r\n") {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[asset] missing or invalid"}); e != nil {
+			slog.Error("HTTP[fundamental] WriteAssetMissing", "e", e.Error())
+		}
+		return
+	}
+
+	f, hit, e := fundamentals.Get(asset, r.URL.Query().Get("cache") == "bypass")
+	if e != nil {
+		code := 502
+		if e.Error() == "!NO_DATA!" {
+			code = 404
+		}
+		if e := writer.Err(w, r, code, writer.ErrorRes{Error: "No fundamentals", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[fundamental] WriteNoData", "e", e.Error())
+		}
+		return
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	}
+	if e := writer.Encode(w, r, 200, f); e != nil {
+		slog.Error("HTTP[fundamental] WriteEncode", "e", e.Error())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/** maxNewsLimit is the maximum of headlines per request */
+const maxNewsLimit = 1000
+
+// newsArgs are the shared news parameters
+type newsArgs struct {
+	Format  string // x|t
+	Symbols string // AAPL:MSFT
+	Sources string // DTN:CPR
+	Date    string // YYYYMMDD or YYYYMMDD-YYYYMMDD
+}
+
+// parseNewsArgs reads format, symbols, sources and date or from+to
+func parseNewsArgs(r *http.Request) (newsArgs, *writer.ErrorRes) {
+	var a newsArgs
+	q := r.URL.Query()
+	switch q.Get("format") {
+	case "", "x":
+		a.Format = "x"
+	case "t":
+		a.Format = "t"
+	default:
+		return a, &writer.ErrorRes{Error: "GET[format] invalid, possible=x|t"}
+	}
+	a.Symbols = strings.Join(parseSymbols(q.Get("symbols")), ":")
+	a.Sources = strings.Join(parseSymbols(q.Get("sources")), ":")
+
+	date, from, to := q.Get("date"), q.Get("from"), q.Get("to")
+	var e error
+	if date != "" {
+		if from != "" || to != "" {
+			return a, &writer.ErrorRes{Error: "GET[date] not possible with from|to"}
+		}
+		if a.Date, e = iqDate(date); e != nil {
+			return a, &writer.ErrorRes{Error: "GET[date] invalid", Detail: e.Error()}
+		}
+	} else if from != "" || to != "" {
+		if from == "" || to == "" {
+			return a, &writer.ErrorRes{Error: "GET[from+to] both needed"}
+		}
+		if from, e = iqDate(from); e != nil {
+			return a, &writer.ErrorRes{Error: "GET[from] invalid", Detail: e.Error()}
+		}
+		if to, e = iqDate(to); e != nil {
+			return a, &writer.ErrorRes{Error: "GET[to] invalid", Detail: e.Error()}
+		}
+		a.Date = from + "-" + to
+	}
+	return a, nil
+}
+
+// newsLines proxies cmd and returns all reply lines
+func newsLines(cmd []byte) ([][]byte, error) {
+	var lines [][]byte
+	e := proxy(cmd, -1, func(bin []byte) error {
+		lines = append(lines, bytes.Clone(bin))
+		return nil
+	})
+	return lines, e
+}
+
+// newsWrite writes out or the error of the news lookup
+func newsWrite(w http.ResponseWriter, r *http.Request, name string, out interface{}, e error) {
+	if e != nil {
+		code := 400
+		if e.Error() == "!NO_DATA!" {
+			code = 404
+		}
+		slog.Error("HTTP[news] "+name, "e", e.Error())
+		if e := writer.Err(w, r, code, writer.ErrorRes{Error: "Upstream error", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[news] WriteUpstreamError", "e", e.Error())
+		}
+		return
+	}
+	if e := writer.Encode(w, r, 200, out); e != nil {
+		slog.Error("HTTP[news] WriteEncode", "name", name, "e", e.Error())
+	}
+}
+
+func newsHeadlines(w http.ResponseWriter, r *http.Request) {
+	a, errRes := parseNewsArgs(r)
+	if errRes != nil {
+		if e := writer.Err(w, r, 400, *errRes); e != nil {
+			slog.Error("HTTP[newsHeadlines] WriteInvalidArgs", "e", e.Error())
+		}
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, e := strconv.Atoi(v)
+		if e != nil || n < 1 || n > maxNewsLimit {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: fmt.Sprintf("GET[limit] must be 1..%d", maxNewsLimit)}); e != nil {
+				slog.Error("HTTP[newsHeadlines] WriteInvalidLimit", "e", e.Error())
+			}
+			return
+		}
+		limit = n
+	}
+
+	// NHL,[Sources],[Symbols],[XML/Text],[Limit],[Date],[RequestID]
+	lines, e := newsLines([]byte(fmt.Sprintf("NHL,%s,%s,%s,%d,%s", a.Sources, a.Symbols, a.Format, limit, a.Date)))
+	var out []Headline
+	if e == nil {
+		if a.Format == "x" {
+			out, e = parseHeadlinesXML(bytes.Join(lines, []byte("\n")))
+		} else {
+			for _, line := range lines {
+				h, err := parseHeadlineText(line)
+				if err != nil {
+					e = err
+					break
+				}
+				out = append(out, h)
+			}
+		}
+	}
+	if e == nil && len(out) == 0 {
+		e = fmt.Errorf("!NO_DATA!")
+	}
+	newsWrite(w, r, "headlines", out, e)
+}
+
+func newsStory(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/news/story/")
+	if id == "" || strings.ContainsAny(id, ",/") {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Story ID missing or invalid, expected /news/story/{id}"}); e != nil {
+			slog.Error("HTTP[newsStory] WriteInvalidID", "e", e.Error())
+		}
+		return
+	}
+	a, errRes := parseNewsArgs(r)
+	if errRes != nil {
+		if e := writer.Err(w, r, 400, *errRes); e != nil {
+			slog.Error("HTTP[newsStory] WriteInvalidArgs", "e", e.Error())
+		}
+		return
+	}
+
+	// NSS,[XML/Text/Email],[ID],[Deliver To],[RequestID]
+	lines, e := newsLines([]byte(fmt.Sprintf("NSS,%s,%s,", a.Format, id)))
+	var out Story
+	if e == nil {
+		if a.Format == "x" {
+			out, e = parseStoryXML(id, bytes.Join(lines, []byte("\n")))
+		} else if len(lines) == 0 {
+			e = fmt.Errorf("!NO_DATA!")
+		} else {
+			out = parseStoryText(id, lines)
+		}
+	}
+	newsWrite(w, r, "story", out, e)
+}
+
+func newsStoryCount(w http.ResponseWriter, r *http.Request) {
+	a, errRes := parseNewsArgs(r)
+	if errRes == nil && a.Symbols == "" {
+		errRes = &writer.ErrorRes{Error: "GET[symbols] missing"}
+	}
+	if errRes != nil {
+		if e := writer.Err(w, r, 400, *errRes); e != nil {
+			slog.Error("HTTP[newsStoryCount] WriteInvalidArgs", "e", e.Error())
+		}
+		return
+	}
+
+	// NSC,[Symbols],[XML/Text],[Sources],[Date Range],[RequestID]
+	lines, e := newsLines([]byte(fmt.Sprintf("NSC,%s,%s,%s,%s", a.Symbols, a.Format, a.Sources, a.Date)))
+	var out []StoryCount
+	if e == nil {
+		if a.Format == "x" {
+			out, e = parseStoryCountsXML(bytes.Join(lines, []byte("\n")))
+		} else {
+			out, e = parseStoryCountsText(bytes.Join(lines, nil))
+		}
+	}
+	newsWrite(w, r, "story-count", out, e)
+}
+
+func newsConfiguration(w http.ResponseWriter, r *http.Request) {
+	// NCG,[RequestID] (XML only)
+	lines, e := newsLines([]byte("NCG"))
+	var out []NewsCategory
+	if e == nil {
+		out, e = parseNewsConfigXML(bytes.Join(lines, []byte("\n")))
+	}
+	newsWrite(w, r, "configuration", out, e)
+}
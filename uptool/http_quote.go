@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+/** quoteFields is the default field selection of /quote */
+const quoteFields = "Most Recent Trade,Bid,Ask,Total Volume"
+
+// parseFields reads a comma separated list of update fieldnames (case sensitive)
+func parseFields(val string) []string {
+	var out []string
+	for _, name := range strings.Split(val, ",") {
+		if name = strings.TrimSpace(name); name != "" && name != "Symbol" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func quote(w http.ResponseWriter, r *http.Request) {
+	symbols := parseSymbols(r.URL.Query().Get("symbols"))
+	if len(symbols) == 0 || len(symbols) > maxQuoteSymbols {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[symbols] missing or too many", Detail: fmt.Sprintf("max %d symbols", maxQuoteSymbols)}); e != nil {
+			slog.Error("HTTP[quote] WriteSymbolsInvalid", "e", e.Error())
+		}
+		return
+	}
+	if sym, ok := invalidSymbol(symbols); ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[symbols] invalid", Detail: fmt.Sprintf("%q", sym)}); e != nil {
+			slog.Error("HTTP[quote] WriteSymbolsInvalid", "e", e.Error())
+		}
+		return
+	}
+	fieldsArg := r.URL.Query().Get("fields")
+	if fieldsArg == "" {
+		fieldsArg = quoteFields
+	}
+	fields := parseFields(fieldsArg)
+	if len(fields) == 0 {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[fields] invalid"}); e != nil {
+			slog.Error("HTTP[quote] WriteFieldsInvalid", "e", e.Error())
+		}
+		return
+	}
+
+	if e := upstream.Ready(); e != nil {
+		if e := writer.Err(w, r, 503, writer.ErrorRes{Error: "Upstream not ready", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[quote] WriteNotReady", "e", e.Error())
+		}
+		return
+	}
+
+	res, e := level1.Quote(symbols, fields)
+	if e == errQuoteBusy {
+		if e := writer.Err(w, r, 503, writer.ErrorRes{Error: "Too many quotes", Detail: fmt.Sprintf("max %d concurrent quotes, retry later", QuoteParallel)}); e != nil {
+			slog.Error("HTTP[quote] WriteBusy", "e", e.Error())
+		}
+		return
+	}
+	if e != nil {
+		slog.Error("HTTP[quote] Quote", "e", e.Error())
+		if e := writer.Err(w, r, 502, writer.ErrorRes{Error: "Upstream error", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[quote] WriteUpstreamError", "e", e.Error())
+		}
+		return
+	}
+	if e := writer.Encode(w, r, 200, res); e != nil {
+		slog.Error("HTTP[quote] WriteEncode", "e", e.Error())
+	}
+}
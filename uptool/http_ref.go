@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+)
+
+// refHandler returns a handler that writes the table from list
+func refHandler[T any](name string, list func() ([]T, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, e := list()
+		if e != nil {
+			slog.Error("HTTP[ref] List", "table", name, "e", e.Error())
+			if e := writer.Err(w, r, 502, writer.ErrorRes{Error: "Upstream error", Detail: e.Error()}); e != nil {
+				slog.Error("HTTP[ref] WriteUpstreamError", "e", e.Error())
+			}
+			return
+		}
+		if e := writer.Encode(w, r, 200, rows); e != nil {
+			slog.Error("HTTP[ref] WriteEncode", "table", name, "e", e.Error())
+		}
+	}
+}
+
+// enrichHeaders adds the listed market and security type of asset
+// as X-Listed-Market/X-Security-Type when the client asks for ?names=1
+func enrichHeaders(w http.ResponseWriter, r *http.Request, asset string) {
+	if r.URL.Query().Get("names") != "1" {
+		return
+	}
+	info, e := refTables.Symbol(asset)
+	if e != nil {
+		slog.Warn("HTTP[enrichHeaders] Symbol", "asset", asset, "e", e.Error())
+		return
+	}
+	w.Header().Set("X-Listed-Market", info.Market)
+	w.Header().Set("X-Security-Type", info.Type)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/** maxStreamSymbols is the maximum of symbols one client may watch */
+const maxStreamSymbols = 500
+
+/** streamPing is the interval we ping clients to keep proxies from closing the conn */
+const streamPing = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// No cookies/auth on this API so any origin is fine
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// parseSymbols reads a comma separated symbol list
+func parseSymbols(val string) []string {
+	var out []string
+	seen := make(map[string]struct{})
+	for _, sym := range strings.Split(val, ",") {
+		sym = strings.ToUpper(strings.TrimSpace(sym))
+		if sym == "" {
+			continue
+		}
+		if _, ok := seen[sym]; ok {
+			continue
+		}
+		seen[sym] = struct{}{}
+		out = append(out, sym)
+	}
+	return out
+}
+
+// validSymbol reports if sym can be written into an IQFeed cmd, a CR/LF
+// would inject cmds on the shared conn
+func validSymbol(sym string) bool {
+	return !strings.ContainsFunc(sym, func(c rune) bool {
+		return c == ',' || unicode.IsControl(c)
+	})
+}
+
+// invalidSymbol returns the first symbol validSymbol rejects
+func invalidSymbol(symbols []string) (string, bool) {
+	for _, sym := range symbols {
+		if !validSymbol(sym) {
+			return sym, true
+		}
+	}
+	return "", false
+}
+
+func stream(w http.ResponseWriter, r *http.Request) {
+	symbols := parseSymbols(r.URL.Query().Get("symbols"))
+	if len(symbols) == 0 {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[symbols] missing"}); e != nil {
+			slog.Error("HTTP[stream] WriteSymbolsMissing", "e", e.Error())
+		}
+		return
+	}
+	if len(symbols) > maxStreamSymbols {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[symbols] too many", Detail: fmt.Sprintf("max %d symbols", maxStreamSymbols)}); e != nil {
+			slog.Error("HTTP[stream] WriteSymbolsMax", "e", e.Error())
+		}
+		return
+	}
+	if sym, ok := invalidSymbol(symbols); ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[symbols] invalid", Detail: fmt.Sprintf("%q", sym)}); e != nil {
+			slog.Error("HTTP[stream] WriteSymbolsInvalid", "e", e.Error())
+		}
+		return
+	}
+
+	sub := level1.Subscribe(symbols)
+	defer level1.Unsubscribe(sub)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamWebsocket(w, r, sub.C)
+		return
+	}
+	streamSSE(w, r, sub.C)
+}
+
+// StreamEvent is a msg that can be streamed to HTTP clients
+type StreamEvent interface {
+	Event() string
+}
+
+// errorEvent is a StreamEvent that ends the stream with an error
+type errorEvent interface {
+	Err() string
+}
+
+// streamErr returns the error of msg when it ends the stream
+func streamErr(msg StreamEvent) string {
+	if ev, ok := msg.(errorEvent); ok && msg.Event() == "error" {
+		return ev.Err()
+	}
+	return ""
+}
+
+// Event names the msg for SSE
+func (m L1Msg) Event() string {
+	return m.Type
+}
+
+// streamSSE writes all msgs from c as Server-Sent Events until c is closed
+func streamSSE[T StreamEvent](w http.ResponseWriter, r *http.Request, c <-chan T) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Could not get Flusher-instance"}); e != nil {
+			slog.Error("HTTP[streamSSE] getFlusher", "e", e.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-httpShutdown:
+			return
+
+		case <-ping.C:
+			if _, e := w.Write([]byte(": ping\n\n")); e != nil {
+				slog.Error("HTTP[streamSSE] WritePing", "e", e.Error())
+				return
+			}
+			flusher.Flush()
+
+		case msg, ok := <-c:
+			if !ok {
+				// dropped by publisher
+				return
+			}
+			bin, e := json.Marshal(msg)
+			if e != nil {
+				slog.Error("HTTP[streamSSE] Marshal", "e", e.Error())
+				return
+			}
+			if _, e := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Event(), bin); e != nil {
+				slog.Error("HTTP[streamSSE] Write", "e", e.Error())
+				return
+			}
+			flusher.Flush()
+			if streamErr(msg) != "" {
+				return
+			}
+		}
+	}
+}
+
+// streamWebsocket writes all msgs from c as JSON text-frames until c is closed
+func streamWebsocket[T StreamEvent](w http.ResponseWriter, r *http.Request, c <-chan T) {
+	ws, e := upgrader.Upgrade(w, r, nil)
+	if e != nil {
+		// Upgrade already replied to the client
+		slog.Error("HTTP[streamWebsocket] Upgrade", "e", e.Error())
+		return
+	}
+	defer ws.Close()
+
+	// Undo http.Server.ReadTimeout, we only read control frames
+	if e := ws.SetReadDeadline(time.Time{}); e != nil {
+		slog.Error("HTTP[streamWebsocket] SetReadDeadline", "e", e.Error())
+		return
+	}
+
+	// Read until the client leaves
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, e := ws.ReadMessage(); e != nil {
+				if Verbose {
+					slog.Info("HTTP[streamWebsocket] ReadMessage", "e", e.Error())
+				}
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-httpShutdown:
+			ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(time.Second))
+			return
+
+		case <-ping.C:
+			if e := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(deadlineCmd)); e != nil {
+				slog.Error("HTTP[streamWebsocket] WritePing", "e", e.Error())
+				return
+			}
+
+		case msg, ok := <-c:
+			if !ok {
+				// dropped by publisher
+				ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+				return
+			}
+			if e := ws.SetWriteDeadline(time.Now().Add(deadlineCmd)); e != nil {
+				slog.Error("HTTP[streamWebsocket] SetWriteDeadline", "e", e.Error())
+				return
+			}
+			if e := ws.WriteJSON(msg); e != nil {
+				slog.Error("HTTP[streamWebsocket] WriteJSON", "e", e.Error())
+				return
+			}
+			if reason := streamErr(msg); reason != "" {
+				// close reasons are limited to 123 bytes
+				if len(reason) > 123 {
+					reason = reason[:123]
+				}
+				ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, reason), time.Now().Add(time.Second))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SymbolInfo is a SBF/SBS/SBN/SBC result
+type SymbolInfo struct {
+	Symbol      string
+	MarketID    int
+	Market      string `json:",omitempty"`
+	TypeID      int
+	Type        string `json:",omitempty"`
+	SIC         int    `json:",omitempty"`
+	NAICS       int    `json:",omitempty"`
+	Description string
+}
+
+func (s SymbolInfo) CSVHeader() []string {
+	return []string{"Symbol", "ListedMarketID", "ListedMarket", "SecurityTypeID", "SecurityType", "SIC", "NAICS", "Description"}
+}
+func (s SymbolInfo) CSVRecord() []string {
+	return []string{
+		s.Symbol, strconv.Itoa(s.MarketID), s.Market, strconv.Itoa(s.TypeID), s.Type,
+		strconv.Itoa(s.SIC), strconv.Itoa(s.NAICS), s.Description,
+	}
+}
+
+// parseSymbolInfo converts a lookup line
+// LS,TSLA,21,1,TESLA  INC., (SBF)
+// LC,@ESM24,34,2,E-MINI S&P 500 JUNE 2024, (SBC)
+// LI,AAPL,7,1,3571,APPLE INC., (SBS)
+// LN,AAPL,7,1,334111,APPLE INC., (SBN)
+func parseSymbolInfo(bin []byte) (SymbolInfo, error) {
+	var (
+		s SymbolInfo
+		e error
+	)
+	bin = bytes.TrimSuffix(bin, []byte(","))
+	n := 5
+	if bytes.HasPrefix(bin, []byte("LI,")) || bytes.HasPrefix(bin, []byte("LN,")) {
+		n = 6
+	}
+	buf := bytes.SplitN(bin, []byte(","), n)
+	if len(buf) < n {
+		return s, fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	}
+
+	s.Symbol = string(buf[1])
+	if s.MarketID, e = strconv.Atoi(string(buf[2])); e != nil {
+		return s, fmt.Errorf("ListedMarketID: %w", e)
+	}
+	if s.TypeID, e = strconv.Atoi(string(buf[3])); e != nil {
+		return s, fmt.Errorf("SecurityTypeID: %w", e)
+	}
+	if n == 6 {
+		code, e := strconv.Atoi(string(buf[4]))
+		if e != nil {
+			return s, fmt.Errorf("SIC/NAICS: %w", e)
+		}
+		if buf[0][1] == 'I' {
+			s.SIC = code
+		} else {
+			s.NAICS = code
+		}
+	}
+	s.Description = string(buf[n-1])
+	return s, nil
+}
+
+// joinIDs returns ids as space separated IQFeed filter value
+func joinIDs(ids []int) string {
+	var out []string
+	for _, id := range ids {
+		out = append(out, strconv.Itoa(id))
+	}
+	return strings.Join(out, " ")
+}
+
+// idSet returns ids as lookup, nil means everything matches
+func idSet(ids []int) map[int]struct{} {
+	if len(ids) == 0 {
+		return nil
+	}
+	out := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		out[id] = struct{}{}
+	}
+	return out
+}
+
+func symbols(w http.ResponseWriter, r *http.Request) {
+	var (
+		cmd       []byte
+		marketIDs []int
+		typeIDs   []int
+	)
+	{
+		search := r.URL.Query().Get("search")
+		if search == "" || strings.Contains(search, ",") {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[search] missing or invalid"}); e != nil {
+				slog.Error("HTTP[symbols] WriteSearchMissing", "e", e.Error())
+			}
+			return
+		}
+
+		var e error
+		if v := parseSymbols(r.URL.Query().Get("market")); len(v) > 0 {
+			if marketIDs, e = refTables.MarketIDs(v); e != nil {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[market] invalid", Detail: e.Error()}); e != nil {
+					slog.Error("HTTP[symbols] WriteInvalidMarket", "e", e.Error())
+				}
+				return
+			}
+		}
+		if v := parseSymbols(r.URL.Query().Get("type")); len(v) > 0 {
+			if typeIDs, e = refTables.TypeIDs(v); e != nil {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[type] invalid", Detail: e.Error()}); e != nil {
+					slog.Error("HTTP[symbols] WriteInvalidType", "e", e.Error())
+				}
+				return
+			}
+		}
+
+		switch by := r.URL.Query().Get("by"); by {
+		case "", "symbol", "description":
+			field := "s"
+			if by == "description" {
+				field = "d"
+			}
+			// SBF,[Field To Search],[Search String],[Filter Type],[Filter Value],[RequestID]
+			// only one filter upstream, the other one is applied on the results
+			filterType, filterValue := "", ""
+			if len(typeIDs) > 0 {
+				filterType, filterValue = "t", joinIDs(typeIDs)
+			} else if len(marketIDs) > 0 {
+				filterType, filterValue = "e", joinIDs(marketIDs)
+			}
+			cmd = []byte(fmt.Sprintf("SBF,%s,%s,%s,%s", field, search, filterType, filterValue))
+		case "sic":
+			// SBS,[Search String],[RequestID]
+			cmd = []byte(fmt.Sprintf("SBS,%s", search))
+		case "naics":
+			// SBN,[Search String],[RequestID]
+			cmd = []byte(fmt.Sprintf("SBN,%s", search))
+		case "chain":
+			// SBC,[Symbol],[Prefix],[Listed Markets],[Security Types],[RequestID]
+			cmd = []byte(fmt.Sprintf("SBC,%s,,%s,%s", search, joinIDs(marketIDs), joinIDs(typeIDs)))
+		default:
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[by] invalid, possible=symbol|description|sic|naics|chain"}); e != nil {
+				slog.Error("HTTP[symbols] WriteInvalidBy", "e", e.Error())
+			}
+			return
+		}
+	}
+
+	// Names are a nice-to-have, don't fail the lookup on them
+	markets, e := refTables.Markets()
+	if e != nil {
+		slog.Warn("HTTP[symbols] Markets", "e", e.Error())
+	}
+	types, e := refTables.Types()
+	if e != nil {
+		slog.Warn("HTTP[symbols] Types", "e", e.Error())
+	}
+
+	marketSet, typeSet := idSet(marketIDs), idSet(typeIDs)
+	encodedStream(w, r, cmd, func(bin []byte) (interface{}, error) {
+		s, e := parseSymbolInfo(bin)
+		if e != nil {
+			return nil, e
+		}
+		if _, ok := marketSet[s.MarketID]; marketSet != nil && !ok {
+			return nil, nil
+		}
+		if _, ok := typeSet[s.TypeID]; typeSet != nil && !ok {
+			return nil, nil
+		}
+		s.Market = markets[s.MarketID].ShortName
+		s.Type = types[s.TypeID].ShortName
+		return s, nil
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSymbolInfo(t *testing.T) {
+	s, e := parseSymbolInfo([]byte("LS,TSLA,21,1,TESLA  INC.,"))
+	if e != nil {
+		t.Fatalf("parseSymbolInfo e=%s", e.Error())
+	}
+	if s.Symbol != "TSLA" || s.MarketID != 21 || s.TypeID != 1 || s.Description != "TESLA  INC." {
+		t.Errorf("parseSymbolInfo(LS)=%+v", s)
+	}
+
+	s, e = parseSymbolInfo([]byte("LI,AAPL,7,1,3571,APPLE, INC.,"))
+	if e != nil {
+		t.Fatalf("parseSymbolInfo e=%s", e.Error())
+	}
+	if s.SIC != 3571 || s.NAICS != 0 || s.Description != "APPLE, INC." {
+		t.Errorf("parseSymbolInfo(LI)=%+v", s)
+	}
+	if s, _ := parseSymbolInfo([]byte("LN,AAPL,7,1,334111,APPLE INC.,")); s.NAICS != 334111 {
+		t.Errorf("parseSymbolInfo(LN)=%+v", s)
+	}
+	if _, e := parseSymbolInfo([]byte("LS,TSLA,abc,1,TESLA,")); e == nil {
+		t.Errorf("parseSymbolInfo accepted invalid market")
+	}
+}
+
+func TestRefTables(t *testing.T) {
+	m, e := parseListedMarket([]byte("7,NYSE,New York Stock Exchange,7,NYSE,"))
+	if e != nil || m.ID != 7 || m.ShortName != "NYSE" || m.GroupID != 7 {
+		t.Errorf("parseListedMarket=%+v e=%v", m, e)
+	}
+	st, e := parseSecurityType([]byte("LT,1,EQUITY,Equity,"))
+	if e != nil || st.ID != 1 || st.ShortName != "EQUITY" || st.LongName != "Equity" {
+		t.Errorf("parseSecurityType=%+v e=%v", st, e)
+	}
+
+	c, e := parseIndustryCode([]byte("3571,ELECTRONIC COMPUTERS, EXCEPT PARTS,"))
+	if e != nil || c.Code != 3571 || c.Description != "ELECTRONIC COMPUTERS, EXCEPT PARTS" {
+		t.Errorf("parseIndustryCode=%+v e=%v", c, e)
+	}
+	if tc, e := parseTradeCondition([]byte("61,ODDLOT,Odd lot trade,")); e != nil || tc.ID != 61 || tc.ShortName != "ODDLOT" {
+		t.Errorf("parseTradeCondition=%+v e=%v", tc, e)
+	}
+
+	ids, e := resolveIDs([]string{"NYSE", "21"}, map[int]string{7: "NYSE", 21: "NASDAQ"})
+	if e != nil || len(ids) != 2 || ids[0] != 7 || ids[1] != 21 {
+		t.Errorf("resolveIDs=%v e=%v", ids, e)
+	}
+	if _, e := resolveIDs([]string{"NOPE"}, map[int]string{7: "NYSE"}); e == nil {
+		t.Errorf("resolveIDs accepted unknown name")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIqDatetime(t *testing.T) {
+	valid := map[string]string{
+		"20240102 093000":     "20240102 093000",
+		"2024-01-02 09:30:00": "20240102 093000",
+		"2024-01-02":          "20240102 000000",
+	}
+	for in, out := range valid {
+		res, e := iqDatetime(in)
+		if e != nil {
+			t.Errorf("iqDatetime(%s) e=%s", in, e.Error())
+		}
+		if res != out {
+			t.Errorf("iqDatetime(%s)=%s expected=%s", in, res, out)
+		}
+	}
+
+	for _, in := range []string{"", "2024-13-01", "02-01-2024", "20240102,093000"} {
+		if _, e := iqDatetime(in); e == nil {
+			t.Errorf("iqDatetime(%s) accepted", in)
+		}
+	}
+}
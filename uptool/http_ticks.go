@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mpdroog/docker-iqfeed/iqapi/writer"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LH,2024-01-02 09:30:00.012345,187.1500,100,1534212,187.1400,187.1600,6789,O,11,3D87,1,2,
+type Tick struct {
+	Datetime     time.Time
+	Last         float64
+	LastSize     int64
+	TotalVolume  int64
+	Bid          float64
+	Ask          float64
+	TickID       int64
+	BasisForLast string
+	MarketCenter int64
+	Conditions   string
+	Aggressor    int64
+	DayCode      int64
+}
+
+func (t Tick) CSVHeader() []string {
+	return []string{"TimeStamp", "Last", "LastSize", "TotalVolume", "Bid", "Ask", "TickID", "BasisForLast", "TradeMarketCenter", "TradeConditions", "TradeAggressor", "DayCode"}
+}
+func (t Tick) CSVRecord() []string {
+	return []string{
+		t.Datetime.Format("2006-01-02 15:04:05.000000"),
+		formatFloat(t.Last), strconv.FormatInt(t.LastSize, 10), strconv.FormatInt(t.TotalVolume, 10),
+		formatFloat(t.Bid), formatFloat(t.Ask), strconv.FormatInt(t.TickID, 10),
+		t.BasisForLast, strconv.FormatInt(t.MarketCenter, 10), t.Conditions,
+		strconv.FormatInt(t.Aggressor, 10), strconv.FormatInt(t.DayCode, 10),
+	}
+}
+
+// parseTick converts a HTX/HTD/HTT line into a Tick
+func parseTick(bin []byte) (Tick, error) {
+	var (
+		t Tick
+		e error
+	)
+	buf := bytes.Split(bin, []byte(","))
+	if len(buf) < 13 {
+		return t, fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	}
+
+	if t.Datetime, e = time.ParseInLocation("2006-01-02 15:04:05", string(buf[1]), exchangeTZ); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing TimeStamp line=%s\n", bin)
+	}
+	if t.Last, e = parseFloat(buf[2]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing Last line=%s\n", bin)
+	}
+	if t.LastSize, e = parseInt(buf[3]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing LastSize line=%s\n", bin)
+	}
+	if t.TotalVolume, e = parseInt(buf[4]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing TotalVolume line=%s\n", bin)
+	}
+	if t.Bid, e = parseFloat(buf[5]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing Bid line=%s\n", bin)
+	}
+	if t.Ask, e = parseFloat(buf[6]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing Ask line=%s\n", bin)
+	}
+	if t.TickID, e = parseInt(buf[7]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing TickID line=%s\n", bin)
+	}
+	t.BasisForLast = string(buf[8])
+	if t.MarketCenter, e = parseInt(buf[9]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing MarketCenter line=%s\n", bin)
+	}
+	t.Conditions = string(buf[10])
+	if t.Aggressor, e = parseInt(buf[11]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing Aggressor line=%s\n", bin)
+	}
+	if t.DayCode, e = parseInt(buf[12]); e != nil {
+		return t, fmt.Errorf("WARN: Failed parsing DayCode line=%s\n", bin)
+	}
+	return t, nil
+}
+
+func ticks(w http.ResponseWriter, r *http.Request) {
+	// Collect args to construct cmd
+	var (
+		cmd  []byte
+		dp   int
+		mode string
+	)
+	{
+		asset := r.URL.Query().Get("asset")
+		if asset == "" {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[asset] missing"}); e != nil {
+				slog.Error("HTTP[ticks] WriteAssetMissing", "e", e.Error())
+			}
+			return
+		}
+
+		var e error
+		dpStr := r.URL.Query().Get("datapoints")
+		if dpStr != "" {
+			dp, e = strconv.Atoi(dpStr)
+			if e != nil {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints] not a number"}); e != nil {
+					slog.Error("HTTP[ticks] WriteDatapointsNaN", "e", e.Error())
+				}
+				return
+			}
+		}
+		opts, errRes := parseHistOpts(r)
+		if errRes != nil {
+			if e := writer.Err(w, r, 400, *errRes); e != nil {
+				slog.Error("HTTP[ticks] WriteInvalidOpts", "e", e.Error())
+			}
+			return
+		}
+
+		daysStr := r.URL.Query().Get("days")
+		begin := r.URL.Query().Get("begin")
+		end := r.URL.Query().Get("end")
+		mode = r.URL.Query().Get("mode")
+
+		if begin != "" || end != "" {
+			if begin != "" {
+				if begin, e = iqDatetime(begin); e != nil {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[begin] invalid", Detail: e.Error()}); e != nil {
+						slog.Error("HTTP[ticks] WriteInvalidBegin", "e", e.Error())
+					}
+					return
+				}
+			}
+			if end != "" {
+				if end, e = iqDatetime(end); e != nil {
+					if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[end] invalid", Detail: e.Error()}); e != nil {
+						slog.Error("HTTP[ticks] WriteInvalidEnd", "e", e.Error())
+					}
+					return
+				}
+			}
+			// HTT,[Symbol],[BeginDate Time],[EndDate Time],[MaxDatapoints],[BeginFilterTime],[EndFilterTime],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HTT,%s,%s,%s,%s,%s,%s,%s,,%s", asset, begin, end, iqMaxDatapoints(dp), opts.BeginFilter, opts.EndFilter, opts.Direction, opts.PerSend))
+		} else if daysStr != "" {
+			days, e := strconv.Atoi(daysStr)
+			if e != nil || days < 1 {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[days] not a positive number"}); e != nil {
+					slog.Error("HTTP[ticks] WriteDaysNaN", "e", e.Error())
+				}
+				return
+			}
+			// HTD,[Symbol],[Days],[MaxDatapoints],[BeginFilterTime],[EndFilterTime],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HTD,%s,%d,%s,%s,%s,%s,,%s", asset, days, iqMaxDatapoints(dp), opts.BeginFilter, opts.EndFilter, opts.Direction, opts.PerSend))
+		} else if dpStr != "" {
+			if opts.BeginFilter != "" || opts.EndFilter != "" {
+				if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[beginFilter|endFilter] only possible with days or begin+end"}); e != nil {
+					slog.Error("HTTP[ticks] WriteInvalidFilter", "e", e.Error())
+				}
+				return
+			}
+			// HTX,[Symbol],[MaxDatapoints],[DataDirection],[RequestID],[DatapointsPerSend]
+			cmd = []byte(fmt.Sprintf("HTX,%s,%d,%s,,%s", asset, dp, opts.Direction, opts.PerSend))
+		} else {
+			if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "GET[datapoints|days|begin+end] missing"}); e != nil {
+				slog.Error("HTTP[ticks] WriteRangeMissing", "e", e.Error())
+			}
+			return
+		}
+	}
+
+	if mode == "chunked" {
+		encodedStream(w, r, cmd, func(bin []byte) (interface{}, error) {
+			return parseTick(bin)
+		})
+		return
+	}
+
+	if dp == 0 || dp+100 > MaxDatapoints {
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "MAX_DATAPOINTS", Detail: fmt.Sprintf("rejecting more than %d datapoints, please set datapoints or mode=chunked", MaxDatapoints)}); e != nil {
+			slog.Error("HTTP[ticks] WriteMaxDatapoints", "e", e.Error())
+		}
+		return
+	}
+
+	// Parse lines
+	out := make([]Tick, 0, dp)
+	if e := proxy(cmd, dp+100, func(bin []byte) error {
+		t, e := parseTick(bin)
+		if e != nil {
+			return e
+		}
+		out = append(out, t)
+		return nil
+
+	}); e != nil {
+		slog.Error("HTTP[ticks] proxy", "e", e.Error())
+		if e := writer.Err(w, r, 400, writer.ErrorRes{Error: "Upstream error", Detail: e.Error()}); e != nil {
+			slog.Error("HTTP[ticks] WriteUpstreamError", "e", e.Error())
+		}
+		return
+	}
+
+	if len(out) == 0 {
+		// Nothing sent to client
+		if e := writer.Err(w, r, 404, writer.ErrorRes{Error: "No data"}); e != nil {
+			slog.Error("HTTP[ticks] WriteNoData", "e", e.Error())
+		}
+		return
+	}
+
+	if e := writer.Encode(w, r, 200, out); e != nil {
+		slog.Error("HTTP[ticks] WriteEncode", "e", e.Error())
+	}
+}
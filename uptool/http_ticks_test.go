@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTick(t *testing.T) {
+	tick, e := parseTick([]byte("LH,2024-01-02 09:30:00.012345,187.1500,100,1534212,187.1400,187.1600,6789,O,11,3D87,1,2,"))
+	if e != nil {
+		t.Fatalf("parseTick e=%s", e.Error())
+	}
+	if tick.Last != 187.15 || tick.LastSize != 100 || tick.TotalVolume != 1534212 {
+		t.Errorf("parseTick trade=%+v", tick)
+	}
+	if tick.Bid != 187.14 || tick.Ask != 187.16 || tick.BasisForLast != "O" || tick.MarketCenter != 11 || tick.Conditions != "3D87" {
+		t.Errorf("parseTick quote=%+v", tick)
+	}
+
+	if _, e := parseTick([]byte("LH,2024-01-02 09:30:00.012345,187.1500,")); e == nil {
+		t.Errorf("parseTick accepted short line")
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maurice2k/tcpserver"
+)
+
+// fakeUpstream points upstream to a FakeFeed with an empty conn pool
+func fakeUpstream(t *testing.T) *FakeFeed {
+	t.Helper()
+	f, e := NewFakeFeed()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if Running == nil {
+		Running = new(sync.Map)
+	}
+	if mutex == nil {
+		mutex = new(sync.Mutex)
+		conns = make(map[string]*PoolConn)
+	}
+
+	old := upstream
+	upstream = f.Upstream()
+	t.Cleanup(func() {
+		upstream = old
+		mutex.Lock()
+		for k, conn := range conns {
+			conn.C.Close()
+			delete(conns, k)
+		}
+		mutex.Unlock()
+		f.Close()
+	})
+	return f
+}
+
+func TestIntegrationData(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("HDX,AAPL,2,,,",
+		"LH,2024-05-09,184.6600,182.1100,182.5600,184.5700,48983000,0,",
+		"LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,",
+	)
+
+	res := httptest.NewRecorder()
+	data(res, httptest.NewRequest("GET", "/ohlc?asset=AAPL&range=DAILY&datapoints=2", nil))
+	if res.Code != 200 {
+		t.Fatalf("code=%d body=%s cmds=%v", res.Code, res.Body.String(), f.Cmds())
+	}
+	var bars []DailyBar
+	if e := json.Unmarshal(res.Body.Bytes(), &bars); e != nil {
+		t.Fatalf("e=%s body=%s", e, res.Body.String())
+	}
+	if len(bars) != 2 || bars[1].Close != 183.05 || bars[1].PeriodVolume != 50759500 {
+		t.Errorf("bars=%+v", bars)
+	}
+
+	// mode=chunked stays the upstream CSV unless a format is asked for
+	res = httptest.NewRecorder()
+	data(res, httptest.NewRequest("GET", "/ohlc?asset=AAPL&range=DAILY&datapoints=2&mode=chunked", nil))
+	if !strings.HasPrefix(res.Body.String(), "MessageID, DateStamp, High, Low, Open, Close, PeriodVolume, OpenInterest,\r\nLH,2024-05-09,184.6600,") {
+		t.Errorf("chunked body=%s", res.Body.String())
+	}
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/ohlc?asset=AAPL&range=DAILY&datapoints=2&mode=chunked", nil)
+	req.Header.Set("Accept", "application/json")
+	data(res, req)
+	if !strings.Contains(res.Body.String(), `"Close":183.05`) {
+		t.Errorf("chunked json body=%s", res.Body.String())
+	}
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/ohlc?asset=AAPL&range=DAILY&datapoints=2&mode=chunked", nil)
+	req.Header.Set("Accept", "text/csv")
+	data(res, req)
+	if res.Body.String() != "DateStamp,High,Low,Open,Close,PeriodVolume,OpenInterest\n2024-05-09,184.66,182.11,182.56,184.57,48983000,0\n2024-05-10,185.09,182.13,184.9,183.05,50759500,0\n" {
+		t.Errorf("chunked csv body=%s", res.Body.String())
+	}
+
+	// Unscripted cmd is E,!NO_DATA!
+	res = httptest.NewRecorder()
+	data(res, httptest.NewRequest("GET", "/ohlc?asset=NOPE&range=DAILY&datapoints=2", nil))
+	if res.Code != 400 || !strings.Contains(res.Body.String(), "!NO_DATA!") {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+
+	// Both requests shared one upstream conn
+	if n := f.Dials(); n != 1 {
+		t.Errorf("dials=%d, expected the pool to re-use the conn", n)
+	}
+}
+
+func TestIntegrationIntervals(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("HIX,AAPL,60,2,,,",
+		"LH,2024-05-10 09:31:00,184.7600,184.7500,184.7500,184.7600,32048,250,3,",
+		"LH,2024-05-10 09:32:00,184.9000,184.7000,184.7600,184.8000,33048,1000,9,",
+	)
+
+	res := httptest.NewRecorder()
+	intervals(res, httptest.NewRequest("GET", "/ohlc-intervals?asset=AAPL&interval=60&datapoints=2", nil))
+	if res.Code != 200 {
+		t.Fatalf("code=%d body=%s cmds=%v", res.Code, res.Body.String(), f.Cmds())
+	}
+	var bars []IntervalBar
+	if e := json.Unmarshal(res.Body.Bytes(), &bars); e != nil {
+		t.Fatalf("e=%s body=%s", e, res.Body.String())
+	}
+	if len(bars) != 2 || bars[1].NumberOfTrades != 9 {
+		t.Errorf("bars=%+v", bars)
+	}
+
+	// Whole seconds go to IQFeed as-is
+	f.Reply("HIX,AAPL,100,1,,,",
+		"LH,2024-05-10 09:31:40,184.7600,184.7500,184.7500,184.7600,32048,250,3,",
+	)
+	res = httptest.NewRecorder()
+	intervals(res, httptest.NewRequest("GET", "/ohlc-intervals?asset=AAPL&interval=100&datapoints=1", nil))
+	if res.Code != 200 || res.Header().Get("X-Aggregated") != "" {
+		t.Errorf("code=%d X-Aggregated=%s body=%s cmds=%v", res.Code, res.Header().Get("X-Aggregated"), res.Body.String(), f.Cmds())
+	}
+
+	// Aggregated bars have the same limits
+	for _, qs := range []string{
+		"interval=7h&session=rth&datapoints=1",
+		"interval=25h&source=minute&datapoints=1",
+		"interval=7m&session=eth&days=5",
+		"interval=7m&session=eth&datapoints=1&mode=chunked",
+	} {
+		res = httptest.NewRecorder()
+		intervals(res, httptest.NewRequest("GET", "/ohlc-intervals?asset=AAPL&"+qs, nil))
+		if res.Code != 400 {
+			t.Errorf("%s code=%d body=%s", qs, res.Code, res.Body.String())
+		}
+	}
+
+	// and stop reading upstream at MaxAggLines
+	defer func(n int) { MaxAggLines = n }(MaxAggLines)
+	MaxAggLines = 2
+	tick := "LH,2024-05-10 02:00:00.012345,187.1500,100,1534212,187.1400,187.1600,6789,O,11,3D87,1,2,"
+	f.Reply("HTT,AAPL,20240510 000000,20240511 000000,,,,1", tick, tick, tick)
+	res = httptest.NewRecorder()
+	intervals(res, httptest.NewRequest("GET", "/ohlc-intervals?asset=AAPL&interval=7h&source=tick&datapoints=1&begin=20240510+000000&end=20240511+000000", nil))
+	if res.Code != 400 || !strings.Contains(res.Body.String(), "MAX_DATAPOINTS") {
+		t.Errorf("code=%d body=%s cmds=%v", res.Code, res.Body.String(), f.Cmds())
+	}
+}
+
+// fakeCache points barCache to an empty cache in a tempdir
+func fakeCache(t *testing.T) {
+	t.Helper()
+	if e := BarCacheInit(filepath.Join(t.TempDir(), "bars.db")); e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		barCache.db.Close()
+		barCache = nil
+	})
+}
+
+// cachedGet calls data with path and checks X-Cache and the upstream cmds it sent
+func cachedGet(t *testing.T, f *FakeFeed, path string, status string, cmds ...string) []DailyBar {
+	t.Helper()
+	before := len(f.Cmds())
+	res := httptest.NewRecorder()
+	data(res, httptest.NewRequest("GET", path, nil))
+	if res.Code != 200 {
+		t.Fatalf("%s code=%d body=%s", path, res.Code, res.Body.String())
+	}
+	if got := res.Header().Get("X-Cache"); got != status {
+		t.Errorf("%s X-Cache=%s expected=%s", path, got, status)
+	}
+	if got := f.Cmds()[before:]; strings.Join(got, "|") != strings.Join(cmds, "|") {
+		t.Errorf("%s cmds=%q expected=%q", path, got, cmds)
+	}
+	var bars []DailyBar
+	if e := json.Unmarshal(res.Body.Bytes(), &bars); e != nil {
+		t.Fatalf("e=%s body=%s", e, res.Body.String())
+	}
+	return bars
+}
+
+func TestIntegrationCacheRange(t *testing.T) {
+	f := fakeUpstream(t)
+	fakeCache(t)
+	f.Reply("HDT,AAPL,20240102,20240105,,1",
+		"LH,2024-01-02,187.1500,183.8900,187.1500,185.6400,82488700,0,",
+		"LH,2024-01-03,185.8800,183.4300,184.2200,184.2500,58414500,0,",
+		"LH,2024-01-04,183.0900,180.8800,182.1500,181.9100,71983600,0,",
+		"LH,2024-01-05,182.7600,180.1700,181.9900,181.1800,62303300,0,",
+	)
+	f.Reply("HDT,AAPL,20231228,20240102,,1",
+		"LH,2023-12-28,194.6600,193.1700,194.1400,193.5800,34049900,0,",
+		"LH,2023-12-29,194.4000,191.7300,193.9000,192.5300,42628800,0,",
+	)
+	f.Reply("HDT,AAPL,20240105,20240109,,1",
+		"LH,2024-01-08,185.6000,181.5000,182.0900,185.5600,59144500,0,",
+		"LH,2024-01-09,185.1500,182.7300,183.9200,185.1400,42841800,0,",
+	)
+	f.Reply("HDT,AAPL,20240102,20240105,10,,,",
+		"LH,2024-01-05,182.7600,180.1700,181.9900,181.1800,62303300,0,",
+	)
+
+	// Empty cache fetches the whole range
+	bars := cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=10&begin=20240102&end=20240105", "MISS", "HDT,AAPL,20240102,20240105,,1")
+	if len(bars) != 4 || bars[0].Close != 181.18 {
+		t.Errorf("MISS bars=%+v", bars)
+	}
+	// Inside the coverage nothing is fetched
+	cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=10&begin=20240103&end=20240105", "HIT")
+	// Only the missing head and tail are fetched
+	bars = cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=10&begin=20231228&end=20240105", "PARTIAL", "HDT,AAPL,20231228,20240102,,1")
+	if len(bars) != 6 || bars[5].Close != 193.58 {
+		t.Errorf("PARTIAL head bars=%+v", bars)
+	}
+	bars = cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=3&begin=20240102&end=20240109", "PARTIAL", "HDT,AAPL,20240105,20240109,,1")
+	if len(bars) != 3 || bars[0].Close != 185.14 || bars[2].Close != 181.18 {
+		t.Errorf("PARTIAL tail bars=%+v", bars)
+	}
+	cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=10&begin=20231228&end=20240109", "HIT")
+
+	// cache=bypass always goes upstream with the original cmd
+	bars = cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=10&begin=20240102&end=20240105&cache=bypass", "BYPASS", "HDT,AAPL,20240102,20240105,10,,,")
+	if len(bars) != 1 {
+		t.Errorf("BYPASS bars=%+v", bars)
+	}
+	if barCache.Hits.Load() != 2 || barCache.Partial.Load() != 2 || barCache.Misses.Load() != 1 {
+		t.Errorf("hits=%d partial=%d misses=%d", barCache.Hits.Load(), barCache.Partial.Load(), barCache.Misses.Load())
+	}
+}
+
+func TestIntegrationCacheLast(t *testing.T) {
+	f := fakeUpstream(t)
+	fakeCache(t)
+	f.Reply("HDX,AAPL,2,1",
+		"LH,2024-05-09,184.6600,182.1100,182.5600,184.5700,48983000,0,",
+		"LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,",
+	)
+
+	// Empty cache fetches the last N
+	bars := cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=2", "MISS", "HDX,AAPL,2,1")
+	if len(bars) != 2 || bars[0].Close != 183.05 {
+		t.Errorf("MISS bars=%+v", bars)
+	}
+	cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=2", "HIT")
+
+	// After cacheRecheck only the tail since the newest bar is fetched
+	key := (&CacheReq{Asset: "AAPL"}).key()
+	cov, _, e := barCache.coverage(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cov.Checked = time.Now().Add(-2 * cacheRecheck)
+	if e := barCache.storeCoverage(key, cov); e != nil {
+		t.Fatal(e)
+	}
+	tail := fmt.Sprintf("HDT,AAPL,20240510,%s,,1", time.Now().In(exchangeTZ).Format("20060102"))
+	f.Reply(tail,
+		"LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,",
+		"LH,2024-05-13,187.1000,184.6200,185.4400,186.2800,72044800,0,",
+	)
+	bars = cachedGet(t, f, "/ohlc?asset=AAPL&range=DAILY&datapoints=2", "PARTIAL", tail)
+	if len(bars) != 2 || bars[0].Close != 186.28 || bars[1].Close != 183.05 {
+		t.Errorf("PARTIAL bars=%+v", bars)
+	}
+
+	// Not enough history fetches the last N again, a gap resets the coverage
+	f.Reply("HDX,MSFT,1,1",
+		"LH,2023-01-03,245.7500,237.4000,243.0800,239.5800,25740000,0,",
+	)
+	cachedGet(t, f, "/ohlc?asset=MSFT&range=DAILY&datapoints=1", "MISS", "HDX,MSFT,1,1")
+	f.Reply("HDX,MSFT,2,1",
+		"LH,2024-05-09,412.0700,406.7800,410.5700,412.3200,14689700,0,",
+		"LH,2024-05-10,415.3800,411.8000,412.9400,414.7400,13402300,0,",
+	)
+	bars = cachedGet(t, f, "/ohlc?asset=MSFT&range=DAILY&datapoints=2", "MISS", "HDX,MSFT,2,1")
+	if len(bars) != 2 || bars[1].Close != 412.32 {
+		t.Errorf("MISS gap bars=%+v", bars)
+	}
+	cov, _, e = barCache.coverage((&CacheReq{Asset: "MSFT"}).key())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !cov.From.Equal(time.Date(2024, 5, 9, 0, 0, 0, 0, exchangeTZ)) {
+		t.Errorf("coverage From=%s, expected the gap to reset it", cov.From)
+	}
+}
+
+func TestIntegrationSearch(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("SBF,s,AAPL,t,1",
+		"LS,AAPL,5,1,APPLE INC,",
+		"LS,AAPL.CL,5,1,APPLE INC CLASS,",
+	)
+
+	res := httptest.NewRecorder()
+	search(res, httptest.NewRequest("GET", "/search?field=SYMBOL&search=AAPL&type=EQUITY", nil))
+	if res.Code != 200 {
+		t.Fatalf("code=%d body=%s cmds=%v", res.Code, res.Body.String(), f.Cmds())
+	}
+	// chunked, one object per line
+	var lines []SearchLine
+	dec := json.NewDecoder(res.Body)
+	for dec.More() {
+		var line SearchLine
+		if e := dec.Decode(&line); e != nil {
+			t.Fatal(e)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0].Ticker != "AAPL" || lines[0].Description != "APPLE INC" {
+		t.Errorf("lines=%+v", lines)
+	}
+
+	// Upstream errors are reported to the client
+	f.Fail("SBF,s,FAIL,t,1", "Unauthorized user ID.")
+	res = httptest.NewRecorder()
+	search(res, httptest.NewRequest("GET", "/search?field=SYMBOL&search=FAIL&type=EQUITY", nil))
+	if res.Code == 200 || !strings.Contains(res.Body.String(), "Unauthorized user ID.") {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+}
+
+func TestIntegrationSymbolLookup(t *testing.T) {
+	f := fakeUpstream(t)
+	// substring search, the exact match is followed by many more
+	lines := []string{"LS,A,7,1,AGILENT TECHNOLOGIES,"}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("LS,AA%d,7,1,ALCOA,", i))
+	}
+	f.Reply("SBF,s,A,,", lines...)
+	f.Reply("SLM", "7,NYSE,New York Stock Exchange,7,NYSE,")
+	f.Reply("SST", "LT,1,EQUITY,Equity,")
+
+	dropped, deadline := poolConns.Value("dropped"), deadlineCmd
+	info, e := refTables.Symbol("A")
+	if e != nil || info.Symbol != "A" || info.Market != "NYSE" || info.Type != "EQUITY" {
+		t.Fatalf("info=%+v e=%v", info, e)
+	}
+	// stopped after the match, the conn with the unread rest isn't pooled
+	if v := poolConns.Value("dropped"); v != dropped+1 || deadlineCmd != deadline {
+		t.Errorf("dropped=%v deadlineCmd=%s", v-dropped, deadlineCmd)
+	}
+	if _, e := refTables.Symbol("A"); e != nil || len(f.Cmds()) == 0 {
+		t.Errorf("cached e=%v", e)
+	}
+}
+
+func TestIntegrationTcpProxy(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("HDX,AAPL,1", "LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go tcpProxy(&tcpserver.TCPConn{Conn: server})
+
+	if e := client.SetDeadline(time.Now().Add(5 * time.Second)); e != nil {
+		t.Fatal(e)
+	}
+	r := bufio.NewReader(client)
+	roundtrip := func(cmd string, expect ...string) {
+		t.Helper()
+		if _, e := client.Write([]byte(cmd + "\r\n")); e != nil {
+			t.Fatal(e)
+		}
+		for _, line := range expect {
+			got, e := r.ReadString('\n')
+			if e != nil {
+				t.Fatalf("cmd=%s e=%s", cmd, e)
+			}
+			if got = strings.TrimSpace(got); got != line {
+				t.Errorf("cmd=%s expected=%s got=%s", cmd, line, got)
+			}
+		}
+	}
+
+	roundtrip("S,SET PROTOCOL,6.2", "S,CURRENT PROTOCOL,6.2")
+	roundtrip("HDX,AAPL,1", "LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,")
+	roundtrip("HDX,AAPL,1", "LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,")
+	// errors end the client conn
+	roundtrip("HDX,NOPE,1", "E,!NO_DATA!")
+	if _, e := r.ReadString('\n'); e == nil {
+		t.Errorf("conn still open after error")
+	}
+
+	if n := f.Dials(); n != 1 {
+		t.Errorf("dials=%d, expected the pool to re-use the conn", n)
+	}
+}
+
+func TestIntegrationUpstreamDown(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Close()
+
+	res := httptest.NewRecorder()
+	data(res, httptest.NewRequest("GET", "/ohlc?asset=AAPL&range=DAILY&datapoints=2", nil))
+	if res.Code == 200 {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+
+	upstream.Ready = runningReady
+	Running.Delete("admin")
+	if e := proxy([]byte("HDX,AAPL,1"), -1, func([]byte) error { return nil }); e == nil {
+		t.Errorf("proxy without a ready upstream")
+	}
+}
+
+func TestIntegrationAdmin(t *testing.T) {
+	f := fakeUpstream(t)
+	Running.Store("iqfeed", 1)
+	defer Running.Delete("iqfeed")
+
+	conn, e := initConn(2 * time.Second)
+	if e != nil || conn == nil {
+		t.Fatalf("conn=%v e=%v", conn, e)
+	}
+	defer conn.C.Close()
+
+	if e := conn.IncreaseDeadline(2 * time.Second); e != nil {
+		t.Fatal(e)
+	}
+	line, e := conn.ReadLine()
+	if e != nil || !strings.HasPrefix(string(line), "S,STATS,") || !strings.Contains(string(line), ",Connected,") {
+		t.Errorf("line=%s e=%v", line, e)
+	}
+
+	f.SetConnected(false)
+	if e := conn.IncreaseDeadline(2 * time.Second); e != nil {
+		t.Fatal(e)
+	}
+	line, e = conn.ReadLine()
+	if e != nil || !strings.Contains(string(line), ",Not Connected,") {
+		t.Errorf("line=%s e=%v", line, e)
+	}
+}
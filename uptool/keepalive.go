@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"log/slog"
-	"net"
 	"time"
 )
 
@@ -28,16 +27,12 @@ func keepalive(upAddr string) {
 			slog.Info("keepalive forNext")
 		}
 
-		if _, ok := Running.Load("iqfeed"); !ok {
-			slog.Info("keepalive iqfeed not running")
-			continue
-		}
-		if _, ok := Running.Load("admin"); !ok {
-			slog.Info("keepalive admin-conn not running")
+		if e := upstream.Ready(); e != nil {
+			slog.Info("keepalive upstream not ready", "e", e.Error())
 			continue
 		}
 
-		upConn, e := net.DialTimeout("tcp", upAddr, defaultConnectTimeout)
+		upConn, e := upstream.Dial(upAddr)
 		if e != nil {
 			slog.Error("keepalive dial", "e", e.Error())
 			continue
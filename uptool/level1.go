@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** l1SubBuffer is the amount of msgs a subscriber may lag behind before it's dropped */
+const l1SubBuffer = 1024
+
+/** l1ReadTimeout is the max silence on the Level 1 conn (IQFeed sends a T-msg every second) */
+const l1ReadTimeout = 30 * time.Second
+
+// L1Msg is a Level 1 message for one symbol (Q=update, P=summary,
+// F=fundamental, n=symbol not found) or a system message (S, T, E)
+type L1Msg struct {
+	Type   string
+	Symbol string            `json:",omitempty"`
+	Fields map[string]string `json:",omitempty"`
+	Line   []byte            `json:"-" msgpack:"-"`
+}
+
+// L1Sub is a single subscriber, C is closed when the subscriber is dropped
+type L1Sub struct {
+	C       chan L1Msg
+	System  bool // also receive system messages
+	symbols map[string]struct{}
+	closed  bool
+}
+
+// Level1 keeps one shared upstream conn to the Level 1 port and fans
+// out all updates to the subscribers of a symbol.
+type Level1 struct {
+	addr string
+
+	mu                sync.Mutex
+	conn              net.Conn
+	queue             []string                       // cmds for the writer, sent outside mu
+	wake              chan struct{}                  // queue not empty
+	watches           map[string]int                 // symbol => subscriber count
+	subs              map[string]map[*L1Sub]struct{} // symbol => subscribers
+	pending           map[string][]*L1Sub            // symbol => subscribers waiting for the summary of their watch, in request order
+	system            map[*L1Sub]struct{}            // subscribers of system messages
+	updateFields      []string
+	fundamentalFields []string
+	sticky            map[string][]byte // last S,KEY and S,SERVER-state
+	quotes            chan struct{}     // running quotes, capped by QuoteParallel
+}
+
+var level1 *Level1
+
+func newLevel1(addr string) *Level1 {
+	return &Level1{
+		addr:    addr,
+		wake:    make(chan struct{}, 1),
+		watches: make(map[string]int),
+		subs:    make(map[string]map[*L1Sub]struct{}),
+		pending: make(map[string][]*L1Sub),
+		system:  make(map[*L1Sub]struct{}),
+		sticky:  make(map[string][]byte),
+		quotes:  make(chan struct{}, QuoteParallel),
+	}
+}
+
+// Level1Init starts the shared Level 1 conn in the background
+func Level1Init(addr string) {
+	level1 = newLevel1(addr)
+	go level1.run()
+}
+
+// write queues cmd for the writer, does nothing when not connected (watches
+// are replayed on connect). Caller must hold l.mu
+func (l *Level1) write(cmd string) {
+	if l.conn == nil {
+		return
+	}
+	l.queue = append(l.queue, cmd)
+	select {
+	case l.wake <- struct{}{}:
+	default:
+		// writer already woken
+	}
+}
+
+// writer sends the queued cmds to conn until stop is closed, a stalled
+// write only blocks the writer and not dispatch
+func (l *Level1) writer(conn net.Conn, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-l.wake:
+		}
+
+		l.mu.Lock()
+		cmds := l.queue
+		l.queue = nil
+		l.mu.Unlock()
+		for _, cmd := range cmds {
+			if e := conn.SetWriteDeadline(time.Now().Add(deadlineCmd)); e != nil {
+				slog.Error("level1(writer) setDeadline", "e", e.Error())
+				return
+			}
+			if _, e := conn.Write([]byte(cmd + "\r\n")); e != nil {
+				// the reader fails too and session reconnects
+				slog.Error("level1(writer)", "cmd", cmd, "e", e.Error())
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// connect marks conn connected, replays all watches and starts the
+// writer, call stop when the session ends
+func (l *Level1) connect(conn net.Conn) (stop func()) {
+	l.mu.Lock()
+	l.conn = conn
+	l.queue = nil
+	// the replayed summaries go to every subscriber
+	l.pending = make(map[string][]*L1Sub)
+	for sym := range l.watches {
+		l.write("w" + sym)
+	}
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go l.writer(conn, done)
+	return func() {
+		close(done)
+		l.mu.Lock()
+		l.conn = nil
+		l.queue = nil
+		l.mu.Unlock()
+	}
+}
+
+// Subscribe watches symbols, every new subscriber sends a fresh watch
+// so it receives a summary (F and P) message, only the new subscriber
+// gets that summary
+func (l *Level1) Subscribe(symbols []string) *L1Sub {
+	sub := &L1Sub{C: make(chan L1Msg, l1SubBuffer), symbols: make(map[string]struct{})}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, sym := range symbols {
+		l.watch(sub, sym)
+	}
+	if Verbose {
+		slog.Info("level1(Subscribe)", "symbols", symbols)
+	}
+	return sub
+}
+
+// SubscribeSystem returns a subscriber without symbols that receives
+// all system messages, starting with the last known key and server-state
+func (l *Level1) SubscribeSystem() *L1Sub {
+	sub := &L1Sub{C: make(chan L1Msg, l1SubBuffer), System: true, symbols: make(map[string]struct{})}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.sticky {
+		sub.C <- L1Msg{Type: "S", Line: line}
+	}
+	l.system[sub] = struct{}{}
+	return sub
+}
+
+// Watch adds sym to sub
+func (l *Level1) Watch(sub *L1Sub, sym string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	l.watch(sub, sym)
+}
+
+// Unwatch removes sym from sub
+func (l *Level1) Unwatch(sub *L1Sub, sym string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unwatch(sub, sym)
+}
+
+// Symbols returns all symbols sub watches
+func (l *Level1) Symbols(sub *L1Sub) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]string, 0, len(sub.symbols))
+	for sym := range sub.symbols {
+		out = append(out, sym)
+	}
+	return out
+}
+
+// FieldNames returns the last reported update and fundamental fieldnames
+func (l *Level1) FieldNames() (update []string, fundamental []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.updateFields, l.fundamentalFields
+}
+
+// Unsubscribe removes sub and unwatches symbols nobody is interested in anymore
+func (l *Level1) Unsubscribe(sub *L1Sub) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.drop(sub)
+}
+
+// watch adds sym to sub, caller must hold l.mu
+func (l *Level1) watch(sub *L1Sub, sym string) {
+	if _, ok := sub.symbols[sym]; !ok {
+		sub.symbols[sym] = struct{}{}
+		if _, ok := l.subs[sym]; !ok {
+			l.subs[sym] = make(map[*L1Sub]struct{})
+		}
+		l.subs[sym][sub] = struct{}{}
+		l.watches[sym]++
+	}
+	if l.conn != nil {
+		l.pending[sym] = append(l.pending[sym], sub)
+	}
+	l.write("w" + sym)
+}
+
+// unwatch removes sym from sub, caller must hold l.mu
+func (l *Level1) unwatch(sub *L1Sub, sym string) {
+	if _, ok := sub.symbols[sym]; !ok {
+		return
+	}
+	delete(sub.symbols, sym)
+	delete(l.subs[sym], sub)
+	l.watches[sym]--
+	if l.watches[sym] > 0 {
+		return
+	}
+
+	delete(l.watches, sym)
+	delete(l.subs, sym)
+	delete(l.pending, sym)
+	l.write("r" + sym)
+	if Verbose {
+		slog.Info("level1(unwatch)", "symbol", sym)
+	}
+}
+
+// drop removes sub, caller must hold l.mu
+func (l *Level1) drop(sub *L1Sub) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.C)
+
+	delete(l.system, sub)
+	for sym := range sub.symbols {
+		l.unwatch(sub, sym)
+	}
+}
+
+// send delivers msg to sub or drops sub when it's lagging, caller must hold l.mu
+func (l *Level1) send(sub *L1Sub, msg L1Msg) {
+	select {
+	case sub.C <- msg:
+	default:
+		slog.Warn("level1(send) subscriber too slow, dropping", "type", msg.Type, "symbol", msg.Symbol)
+		l.drop(sub)
+	}
+}
+
+// fieldMap names the fields of a Q/P/F line
+func fieldMap(names []string, tok []string) map[string]string {
+	fields := make(map[string]string, len(tok))
+	for i, val := range tok {
+		name := fmt.Sprintf("Field%d", i)
+		if i < len(names) {
+			name = names[i]
+		}
+		fields[name] = val
+	}
+	return fields
+}
+
+// dispatch handles a single upstream line
+func (l *Level1) dispatch(bin []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// S,CURRENT UPDATE FIELDNAMES,Symbol,Most Recent Trade,...
+	if bytes.HasPrefix(bin, []byte("S,CURRENT UPDATE FIELDNAMES,")) {
+		l.updateFields = strings.Split(strings.TrimSuffix(string(bin[len("S,CURRENT UPDATE FIELDNAMES,"):]), ","), ",")
+		return
+	}
+	// S,FUNDAMENTAL FIELDNAMES,Symbol,Exchange ID,... (some versions prefix CURRENT)
+	for _, prefix := range []string{"S,FUNDAMENTAL FIELDNAMES,", "S,CURRENT FUNDAMENTAL FIELDNAMES,"} {
+		if bytes.HasPrefix(bin, []byte(prefix)) {
+			l.fundamentalFields = strings.Split(strings.TrimSuffix(string(bin[len(prefix):]), ","), ",")
+			return
+		}
+	}
+
+	if len(bin) < 2 || bin[1] != ',' {
+		return
+	}
+	typ := string(bin[0])
+	switch typ {
+	case "Q", "P", "F", "n":
+	case "S", "T", "E":
+		// S,KEY,... S,SERVER CONNECTED S,STATS,...
+		if bytes.HasPrefix(bin, []byte("S,KEY,")) {
+			l.sticky["KEY"] = bin
+		} else if bytes.HasPrefix(bin, []byte("S,SERVER CONNECTED")) || bytes.HasPrefix(bin, []byte("S,SERVER DISCONNECTED")) {
+			l.sticky["SERVER"] = bin
+		}
+		msg := L1Msg{Type: typ, Line: bin}
+		for sub := range l.system {
+			l.send(sub, msg)
+		}
+		return
+	default:
+		return
+	}
+
+	tok := strings.Split(strings.TrimSuffix(string(bin[2:]), ","), ",")
+	msg := L1Msg{Type: typ, Symbol: tok[0], Line: bin}
+	if typ == "F" {
+		msg.Fields = fieldMap(l.fundamentalFields, tok)
+	} else if typ != "n" {
+		msg.Fields = fieldMap(l.updateFields, tok)
+	}
+
+	// the summary (F, P or n) of a watch only goes to the subscriber that
+	// requested it, IQFeed answers every w in order with F+P or n
+	if q := l.pending[msg.Symbol]; len(q) > 0 && typ != "Q" {
+		sub := q[0]
+		if typ != "F" {
+			if len(q) == 1 {
+				delete(l.pending, msg.Symbol)
+			} else {
+				l.pending[msg.Symbol] = q[1:]
+			}
+		}
+		if _, ok := sub.symbols[msg.Symbol]; ok {
+			l.send(sub, msg)
+		}
+		return
+	}
+
+	for sub := range l.subs[msg.Symbol] {
+		l.send(sub, msg)
+	}
+}
+
+// session prepares conn and reads from it until it fails
+func (l *Level1) session(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	if e := conn.SetWriteDeadline(time.Now().Add(deadlineCmd)); e != nil {
+		return e
+	}
+	for _, cmd := range []string{
+		"S,SET PROTOCOL,6.2",
+		"S,SET CLIENT NAME,IQAPI",
+		"S,REQUEST CURRENT UPDATE FIELDNAMES",
+		"S,REQUEST FUNDAMENTAL FIELDNAMES",
+	} {
+		if _, e := conn.Write([]byte(cmd + "\r\n")); e != nil {
+			return e
+		}
+	}
+
+	// Mark connected and replay all watches
+	stop := l.connect(conn)
+	defer stop()
+
+	for {
+		if e := conn.SetReadDeadline(time.Now().Add(l1ReadTimeout)); e != nil {
+			return e
+		}
+		bin, e := r.ReadBytes(byte('\n'))
+		if e != nil {
+			return e
+		}
+		bin = bytes.TrimSpace(bin)
+		if Verbose {
+			slog.Info("level1(session)", "stream", bin)
+		}
+		l.dispatch(bin)
+	}
+}
+
+// run is a blocking func that keeps the upstream conn open
+func (l *Level1) run() {
+	if l.addr == "" {
+		slog.Warn("level1(run) no upstream, not connecting")
+		return
+	}
+	for {
+		// Always delay 1sec so we don't flood upstream on failure
+		time.Sleep(time.Second * 1)
+
+		if e := upstream.Ready(); e != nil {
+			continue
+		}
+
+		conn, e := upstream.Dial(l.addr)
+		if e != nil {
+			slog.Error("level1(run) dial", "e", e.Error())
+			continue
+		}
+		slog.Info("level1(run) connected", "addr", l.addr)
+
+		if e := l.session(conn); e != nil {
+			slog.Error("level1(run) session", "e", e.Error())
+		}
+
+		if e := conn.Close(); e != nil {
+			slog.Error("level1(run) close", "e", e.Error())
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLevel1Watches(t *testing.T) {
+	up, down := net.Pipe()
+	defer up.Close()
+	defer down.Close()
+
+	l := newLevel1("")
+	stop := l.connect(up)
+	defer stop()
+	cmds := make(chan string, 10)
+	go func() {
+		r := bufio.NewReader(down)
+		for {
+			line, e := r.ReadString('\n')
+			if e != nil {
+				close(cmds)
+				return
+			}
+			cmds <- line[:len(line)-2]
+		}
+	}()
+	expect := func(cmd string) {
+		if got := <-cmds; got != cmd {
+			t.Fatalf("expected cmd=%s got=%s", cmd, got)
+		}
+	}
+
+	a := l.Subscribe([]string{"AAPL", "MSFT"})
+	expect("wAAPL")
+	expect("wMSFT")
+	b := l.Subscribe([]string{"AAPL"})
+	expect("wAAPL")
+
+	// the summary of a watch only goes to the subscriber that requested it
+	l.dispatch([]byte("F,AAPL,5,"))
+	l.dispatch([]byte("P,AAPL,187.10,"))
+	l.dispatch([]byte("P,MSFT,402.00,"))
+	l.dispatch([]byte("F,AAPL,5,"))
+	l.dispatch([]byte("P,AAPL,187.10,"))
+	for _, expect := range []string{"F AAPL", "P AAPL", "P MSFT"} {
+		if msg := <-a.C; msg.Type+" "+msg.Symbol != expect {
+			t.Errorf("a received=%+v expected=%s", msg, expect)
+		}
+	}
+	for _, expect := range []string{"F AAPL", "P AAPL"} {
+		if msg := <-b.C; msg.Type+" "+msg.Symbol != expect {
+			t.Errorf("b received=%+v expected=%s", msg, expect)
+		}
+	}
+	if len(a.C) != 0 || len(b.C) != 0 || len(l.pending) != 0 {
+		t.Errorf("duplicate summary a=%d b=%d pending=%v", len(a.C), len(b.C), l.pending)
+	}
+
+	l.dispatch([]byte("Q,AAPL,187.15,100,"))
+	l.dispatch([]byte("Q,MSFT,402.10,50,"))
+	if msg := <-a.C; msg.Symbol != "AAPL" || msg.Fields["Field1"] != "187.15" {
+		t.Errorf("a received=%+v", msg)
+	}
+	if msg := <-a.C; msg.Symbol != "MSFT" {
+		t.Errorf("a received=%+v", msg)
+	}
+	if msg := <-b.C; msg.Symbol != "AAPL" {
+		t.Errorf("b received=%+v", msg)
+	}
+	if len(b.C) != 0 {
+		t.Errorf("b received MSFT")
+	}
+
+	// System messages only for system subscribers, symbols only for watchers
+	l.dispatch([]byte("S,SERVER CONNECTED"))
+	sys := l.SubscribeSystem()
+	if msg := <-sys.C; string(msg.Line) != "S,SERVER CONNECTED" {
+		t.Errorf("sys sticky=%s", msg.Line)
+	}
+	l.dispatch([]byte("S,STATS,66.112.148.111,60002,1300,0,1,0,0,0,Jan 01 8:00AM,Jan 01 08:00AM,Connected,6.2.0.25,123456,1.23,0.05,0.05,0.03,0.03,"))
+	if msg := <-sys.C; msg.Type != "S" {
+		t.Errorf("sys received=%+v", msg)
+	}
+	if len(a.C) != 0 || len(b.C) != 0 {
+		t.Errorf("watchers received system message")
+	}
+	l.Watch(sys, "IBM")
+	expect("wIBM")
+	l.Unsubscribe(sys)
+	expect("rIBM")
+
+	// AAPL still watched by b
+	l.Unsubscribe(a)
+	expect("rMSFT")
+	l.Unsubscribe(a)
+	l.Unsubscribe(b)
+	expect("rAAPL")
+	if len(l.watches) != 0 || len(l.subs) != 0 {
+		t.Errorf("watches=%v subs=%v", l.watches, l.subs)
+	}
+}
+
+func TestLevel1StalledWrite(t *testing.T) {
+	// nobody reads down so every write blocks
+	up, down := net.Pipe()
+	defer up.Close()
+	defer down.Close()
+	l := newLevel1("")
+	stop := l.connect(up)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a := l.Subscribe([]string{"AAPL"})
+		b := l.Subscribe([]string{"AAPL"})
+		l.dispatch([]byte("Q,AAPL,187.15,"))
+		<-a.C
+		<-b.C
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stalled upstream write blocks subscribers")
+	}
+}
+
+func TestStreamInvalidSymbol(t *testing.T) {
+	defer func(l *Level1) { level1 = l }(level1)
+	level1 = newLevel1("")
+
+	res := httptest.NewRecorder()
+	stream(res, httptest.NewRequest("GET", "/stream?symbols=AAPL,X%0D%0ARMSFT", nil))
+	if res.Code != 400 {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+	if len(level1.watches) != 0 || len(level1.queue) != 0 {
+		t.Errorf("watches=%v queue=%v", level1.watches, level1.queue)
+	}
+}
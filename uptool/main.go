@@ -7,12 +7,20 @@ import (
 	"github.com/maurice2k/tcpserver"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 )
 
 var (
-	Running *sync.Map
-	Verbose bool
+	Running      *sync.Map
+	Verbose      bool
+	cachePath    string
+	calendarPath string
+	configPath   string
+	recordPath   string
+	replayPaths  string
 )
 
 func main() {
@@ -21,26 +29,28 @@ func main() {
 
 	Running = new(sync.Map)
 	flag.BoolVar(&Verbose, "v", false, "Show all that happens")
+	flag.StringVar(&configPath, "config", "/home/wine/iqapi.yaml", "Config file (listen ports, upstream, timeouts, pool and the supervised cmds)")
+	flag.StringVar(&cachePath, "cache", "/home/wine/bars.db", "Bar cache file (empty to disable)")
+	flag.StringVar(&calendarPath, "calendar", "/home/wine/calendar.json", "Trading calendar (holidays/early closes/sessions per exchange)")
+	flag.IntVar(&BatchParallel, "batch-parallel", BatchParallel, "Max concurrent upstream requests per /batch/ohlc")
+	flag.IntVar(&QuoteParallel, "quote-parallel", QuoteParallel, "Max concurrent /quote requests (each opens a Level 1 conn)")
+	flag.DurationVar(&RefRefresh, "ref-refresh", RefRefresh, "Refresh interval of the reference tables (markets, security types, ...)")
+	flag.DurationVar(&FundamentalTTL, "fundamentals-ttl", FundamentalTTL, "Cache time of /fundamentals snapshots")
+	flag.StringVar(&recordPath, "record", "", "Record all upstream lookups into this gzipped session file")
+	flag.StringVar(&replayPaths, "replay", "", "Serve the lookups from these recorded session files (comma separated) without Wine/IQFeed")
+	flag.DurationVar(&ShutdownTimeout, "shutdown-timeout", ShutdownTimeout, "Time the whole shutdown gets on SIGTERM (keep below docker stop -t)")
+	flag.DurationVar(&ChildStopTimeout, "child-stop-timeout", ChildStopTimeout, "Time a child process gets between SIGTERM and SIGKILL on shutdown")
 	flag.Parse()
 
-	prod := os.Getenv("PROD")
-	if prod == "" {
-		fmt.Printf("Missing env.PROD\n")
-		os.Exit(1)
-		return
-	}
-	login := os.Getenv("LOGIN")
-	if login == "" {
-		fmt.Printf("Missing env.LOGIN\n")
-		os.Exit(1)
-		return
-	}
-	pass := os.Getenv("PASS")
-	if pass == "" {
-		fmt.Printf("Missing env.PASS\n")
+	replay := replayPaths != ""
+	config, e := LoadConfig(configPath)
+	if e != nil {
+		fmt.Printf("Invalid config: %s\n", e.Error())
 		os.Exit(1)
 		return
 	}
+	config.Apply()
+
 	vv := os.Getenv("VERBOSE")
 	if vv != "" {
 		Verbose = true
@@ -48,24 +58,38 @@ func main() {
 	}
 	// TODO: Crash if " symbol is found in env-vars?
 
+	if e := CalendarInit(calendarPath); e != nil {
+		fmt.Printf("Invalid calendar: %s\n", e.Error())
+		os.Exit(1)
+		return
+	}
+
 	// Config for all cmds
-	cmds := map[string]CmdInfo{
-		"xvfb": CmdInfo{Dep: "", Cmd: "/usr/bin/Xvfb", Args: []string{":0", "-screen", "0", "1024x768x24", "-noreset"}},
-		"iqfeed": CmdInfo{Dep: "xvfb", Cmd: "wine64", Args: []string{
-			"/home/wine/.wine/drive_c/Program Files/DTN/IQFeed/iqconnect.exe",
-			"-product", prod,
-			"-version", "IQFEED_LAUNCHER",
-			"-login", login,
-			"-password", pass,
-			"-autoconnect",
-		}, PostCmd: "mv", PostArgs: []string{"/home/wine/.wine/drive_c/users/wine/Documents/DTN/IQFeed/IQConnectLog.txt", "/home/wine/IQConnectLog.crash.txt"}},
+	cmds := map[string]CmdInfo{}
+	if replay {
+		// Nothing to start, the lookups come from the recordings
+		if _, e := ReplayInit(strings.Split(replayPaths, ",")); e != nil {
+			fmt.Printf("Invalid replay: %s\n", e.Error())
+			os.Exit(1)
+			return
+		}
+	} else if cmds, e = config.Commands(); e != nil {
+		fmt.Printf("Invalid config: %s\n", e.Error())
+		os.Exit(1)
+		return
+	}
+	if recordPath != "" {
+		if e := RecorderInit(recordPath); e != nil {
+			fmt.Printf("Invalid record: %s\n", e.Error())
+			os.Exit(1)
+			return
+		}
 	}
 	if Verbose {
 		slog.Info("main[exec]", "cmds", cmds)
 	}
 
 	// TODO: Maybe add some stupdity check for infinit waiting?
-	// TODO: Some check for typo's i.e. dep to something that doesn't exist?
 
 	// Reap processing for PID1
 	if reap.IsSupported() {
@@ -81,26 +105,63 @@ func main() {
 
 	ConnKeepAliveInit()
 
+	if cachePath != "" {
+		if e := BarCacheInit(cachePath); e != nil {
+			slog.Error("main[BarCacheInit] running without cache", "path", cachePath, "e", e.Error())
+		}
+	}
+
 	// Admin monitoring
-	go admin()
+	if !replay {
+		go admin()
+	}
 	// Client that keeps everything open
 	//go keepalive("127.0.0.1:5009")
+	// Shared Level 1 conn for streaming
+	Level1Init(upstream.Level1)
+	// Shared Level 2 conn for market depth
+	DepthInit(upstream.Level2)
+	// Stop gracefully on docker stop
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+
 	// HTTP-server
-	go httpListen(":8080")
+	httpServer := httpListen(config.Listen.HTTP)
 
-	// TCP-server
-	{
-		server, e := tcpserver.NewServer(":9101")
+	// TCP-servers (Level 1 and lookup)
+	var tcpServers []*tcpserver.Server
+	for addr, handler := range map[string]tcpserver.RequestHandlerFunc{
+		config.Listen.Level1: tcpLevel1,
+		config.Listen.Lookup: tcpProxy,
+	} {
+		server, e := tcpserver.NewServer(addr)
 		if e != nil {
-			slog.Error("tcpserver.NewServer", "e", e.Error())
+			slog.Error("tcpserver.NewServer", "addr", addr, "e", e.Error())
+			os.Exit(1)
 			return
 		}
-
-		server.SetRequestHandler(tcpProxy)
-		server.Listen()
-		server.Serve()
+		server.SetRequestHandler(handler)
+		if e := server.Listen(); e != nil {
+			slog.Error("tcpserver.Listen", "addr", addr, "e", e.Error())
+			os.Exit(1)
+			return
+		}
+		go func(addr string) {
+			if e := server.Serve(); e != nil {
+				slog.Error("tcpserver.Serve", "addr", addr, "e", e.Error())
+			}
+		}(addr)
+		tcpServers = append(tcpServers, server)
 	}
 
+	s := <-sig
+	slog.Info("main[signal] shutting down", "signal", s.String())
+	go func() {
+		// a second signal skips the graceful part
+		s := <-sig
+		slog.Error("main[signal] forced exit", "signal", s.String())
+		os.Exit(2)
+	}()
 	// Wait for child processes to exit
-	wg.Wait()
+	os.Exit(shutdown(httpServer, tcpServers, cmds, &wg))
 }
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** latencyBuckets are the histogram buckets in seconds */
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// collector writes itself in the Prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []collector
+)
+
+// register adds c to /metrics
+func register(c collector) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	registry = append(registry, c)
+}
+
+// labelString formats {a="1",b="2"}
+func labelString(names []string, vals []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		val := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(vals[i])
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, val)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metric is a counter or gauge with labels
+type metric struct {
+	name, help, typ string
+	labels          []string
+
+	mu   sync.Mutex
+	vals map[string]float64  // label values joined by \xff => value
+	lvs  map[string][]string // label values joined by \xff => label values
+}
+
+func newMetric(typ, name, help string, labels ...string) *metric {
+	m := &metric{name: name, help: help, typ: typ, labels: labels, vals: make(map[string]float64), lvs: make(map[string][]string)}
+	register(m)
+	return m
+}
+
+// newCounter only goes up
+func newCounter(name, help string, labels ...string) *metric {
+	return newMetric("counter", name, help, labels...)
+}
+
+// newGauge goes up and down
+func newGauge(name, help string, labels ...string) *metric {
+	return newMetric("gauge", name, help, labels...)
+}
+
+func (m *metric) key(lvs []string) string {
+	if len(lvs) != len(m.labels) {
+		panic(fmt.Sprintf("DevErr: %s expects labels %v got %v", m.name, m.labels, lvs))
+	}
+	k := strings.Join(lvs, "\xff")
+	if _, ok := m.lvs[k]; !ok {
+		m.lvs[k] = append([]string{}, lvs...)
+	}
+	return k
+}
+
+// Add increases the value of the labels
+func (m *metric) Add(v float64, lvs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vals[m.key(lvs)] += v
+}
+
+// Inc increases the value of the labels by 1
+func (m *metric) Inc(lvs ...string) {
+	m.Add(1, lvs...)
+}
+
+// Set overwrites the value of the labels
+func (m *metric) Set(v float64, lvs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vals[m.key(lvs)] = v
+}
+
+// Reset drops all label values (i.e. the server IP changed)
+func (m *metric) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vals = make(map[string]float64)
+	m.lvs = make(map[string][]string)
+}
+
+// Value returns the value of the labels
+func (m *metric) Value(lvs ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.vals[strings.Join(lvs, "\xff")]
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+	keys := make([]string, 0, len(m.vals))
+	for k := range m.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, labelString(m.labels, m.lvs[k]), strconv.FormatFloat(m.vals[k], 'g', -1, 64))
+	}
+}
+
+// gaugeFunc is a gauge read on every scrape
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func newGaugeFunc(name, help string, fn func() float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, strconv.FormatFloat(g.fn(), 'g', -1, 64))
+}
+
+// histogram counts observations in latencyBuckets
+type histogram struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histSeries
+}
+
+type histSeries struct {
+	lvs    []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, labels ...string) *histogram {
+	h := &histogram{name: name, help: help, labels: labels, series: make(map[string]*histSeries)}
+	register(h)
+	return h
+}
+
+// Observe adds v (seconds) for the labels
+func (h *histogram) Observe(v float64, lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := strings.Join(lvs, "\xff")
+	s, ok := h.series[k]
+	if !ok {
+		s = &histSeries{lvs: append([]string{}, lvs...), counts: make([]uint64, len(latencyBuckets))}
+		h.series[k] = s
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Since observes the seconds since start
+func (h *histogram) Since(start time.Time, lvs ...string) {
+	h.Observe(time.Since(start).Seconds(), lvs...)
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	names := append(append([]string{}, h.labels...), "le")
+	for _, k := range keys {
+		s := h.series[k]
+		var cum uint64
+		for i, le := range latencyBuckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, append(append([]string{}, s.lvs...), strconv.FormatFloat(le, 'g', -1, 64))), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, append(append([]string{}, s.lvs...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, s.lvs), strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, s.lvs), s.count)
+	}
+}
+
+var (
+	httpRequests = newCounter("iqapi_http_requests_total", "HTTP requests per endpoint and status code", "path", "code")
+	httpDuration = newHistogram("iqapi_http_request_duration_seconds", "HTTP request latency per endpoint", "path")
+
+	upstreamRequests = newCounter("iqapi_upstream_requests_total", "Upstream lookups per command type and result", "cmd", "result")
+	upstreamDuration = newHistogram("iqapi_upstream_request_duration_seconds", "Upstream lookup latency per command type", "cmd")
+	upstreamInflight = newGauge("iqapi_upstream_inflight", "Running proxy() calls")
+
+	poolConns   = newCounter("iqapi_pool_conns_total", "Lookup conns created, reused from the pool or dropped", "event")
+	poolFlushes = newCounter("iqapi_pool_conntest_flushes_total", "ConnTest runs that found old data on a conn")
+	poolFlushed = newCounter("iqapi_pool_conntest_flushed_lines_total", "Old lines ConnTest flushed from conns")
+
+	processRestarts = newCounter("iqapi_process_restarts_total", "Restarts of the supervised processes", "name")
+	processState    = newGauge("iqapi_process_state", "Supervisor state per process (1=current state)", "name", "state")
+
+	iqfeedConnected    = newGauge("iqfeed_connected", "IQFeed server connection from S,STATS (1=Connected)")
+	iqfeedServer       = newGauge("iqfeed_server_info", "IQFeed server IP/port and version from S,STATS", "ip", "port", "version")
+	iqfeedKbps         = newGauge("iqfeed_kbps", "IQFeed KB/s from S,STATS", "direction")
+	iqfeedReconnects   = newGauge("iqfeed_reconnections", "IQFeed reconnections from S,STATS")
+	iqfeedSymbols      = newGauge("iqfeed_symbols", "IQFeed watched symbols from S,STATS")
+	iqfeedClients      = newGauge("iqfeed_clients", "IQFeed connected clients from S,STATS")
+	iqfeedLastUpdateAt = newGauge("iqfeed_seconds_since_last_update", "Seconds since the last IQFeed update from S,STATS")
+)
+
+func init() {
+	newGaugeFunc("iqapi_pool_size", "Idle lookup conns in the pool", func() float64 {
+		if mutex == nil {
+			return 0
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		return float64(len(conns))
+	})
+	newGaugeFunc("iqapi_deadline_cmd_seconds", "Current lookup reply deadline (grows when ConnTest flushes old data)", func() float64 {
+		return deadlineCmd.Seconds()
+	})
+}
+
+// cmdType returns the command type label of an upstream cmd (HDX, SBF, ..)
+func cmdType(cmd []byte) string {
+	name, _, _ := strings.Cut(string(cmd), ",")
+	if len(name) > 3 || strings.ToUpper(name) != name {
+		// keep the label cardinality low
+		return "other"
+	}
+	return name
+}
+
+// iqfeedStatsUpdate reads the S,STATS line of the admin port
+// S,STATS,ServerIP,ServerPort,MaxSymbols,NumberOfSymbols,ClientsConnected,SecondsSinceLastUpdate,Reconnections,
+// AttemptedReconnections,StartTime,MarketTime,Status,IQFeedVersion,LoginID,TotalKBsRecv,KBsPerSecRecv,AvgKBsPerSecRecv,
+// TotalKBsSent,KBsPerSecSent,AvgKBsPerSecSent
+func iqfeedStatsUpdate(bin []byte) {
+	tok := strings.Split(string(bin), ",")
+	if len(tok) < 20 {
+		return
+	}
+	connected := 0.0
+	if tok[12] == "Connected" {
+		connected = 1
+	}
+	iqfeedConnected.Set(connected)
+	iqfeedServer.Reset()
+	iqfeedServer.Set(1, tok[2], tok[3], tok[13])
+	for i, dst := range map[int]*metric{5: iqfeedSymbols, 6: iqfeedClients, 7: iqfeedLastUpdateAt, 8: iqfeedReconnects} {
+		if v, e := strconv.ParseFloat(tok[i], 64); e == nil {
+			dst.Set(v)
+		}
+	}
+	for i, dir := range map[int]string{16: "recv", 19: "sent"} {
+		if v, e := strconv.ParseFloat(tok[i], 64); e == nil {
+			iqfeedKbps.Set(v, dir)
+		}
+	}
+}
+
+// statusWriter keeps the status code for the metrics
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is needed for the WebSocket upgrade
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("ResponseWriter is no http.Hijacker")
+	}
+	s.code = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// instrument counts the requests of mux by the registered path (not the
+// URL so /news/story/{id} doesn't create a label per story)
+func instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, path := mux.Handler(r)
+		if path == "" {
+			path = "unknown"
+		}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		mux.ServeHTTP(sw, r)
+		httpRequests.Inc(path, strconv.Itoa(sw.code))
+		httpDuration.Since(start, path)
+	})
+}
+
+func metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	metricsMu.Lock()
+	for _, c := range registry {
+		c.write(bw)
+	}
+	metricsMu.Unlock()
+	if e := bw.Flush(); e != nil {
+		slog.Error("HTTP[metrics] Write", "e", e.Error())
+	}
+}
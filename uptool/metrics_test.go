@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	var buf strings.Builder
+	m := &metric{name: "test_total", help: "Test", typ: "counter", labels: []string{"path"}, vals: map[string]float64{}, lvs: map[string][]string{}}
+	m.Inc(`/a"b`)
+	m.Add(2, "/c")
+	m.write(&buf)
+	for _, line := range []string{"# TYPE test_total counter", `test_total{path="/a\"b"} 1`, `test_total{path="/c"} 2`} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing=%s in\n%s", line, buf.String())
+		}
+	}
+
+	buf.Reset()
+	h := &histogram{name: "test_seconds", help: "Test", labels: []string{"cmd"}, series: map[string]*histSeries{}}
+	h.Observe(0.02, "HDX")
+	h.Observe(60, "HDX")
+	h.write(&buf)
+	for _, line := range []string{`test_seconds_bucket{cmd="HDX",le="0.01"} 0`, `test_seconds_bucket{cmd="HDX",le="0.025"} 1`, `test_seconds_bucket{cmd="HDX",le="30"} 1`, `test_seconds_bucket{cmd="HDX",le="+Inf"} 2`, `test_seconds_count{cmd="HDX"} 2`} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing=%s in\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("HDX,AAPL,1,,,", "LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,")
+	iqfeedStatsUpdate([]byte("S,STATS,66.112.148.111,60002,1300,12,1,0,3,0,Jan 01 8:00AM,Jan 01 08:00AM,Connected,6.2.0.25,123456,1.23,0.05,0.05,0.03,0.02,0.03,"))
+
+	ok := upstreamRequests.Value("HDX", "ok")
+	// local mux, the global one panics on a second Add of /ohlc (-count=2)
+	local := http.NewServeMux()
+	local.HandleFunc("/ohlc", data)
+	res := httptest.NewRecorder()
+	instrument(local).ServeHTTP(res, httptest.NewRequest("GET", "/ohlc?asset=AAPL&range=DAILY&datapoints=1", nil))
+	if res.Code != 200 {
+		t.Fatalf("code=%d body=%s", res.Code, res.Body.String())
+	}
+	if v := upstreamRequests.Value("HDX", "ok"); v != ok+1 {
+		t.Errorf("upstream requests=%v", v)
+	}
+
+	res = httptest.NewRecorder()
+	metrics(res, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`iqapi_http_requests_total{path="/ohlc",code="200"}`,
+		`iqapi_upstream_request_duration_seconds_count{cmd="HDX"}`,
+		`iqapi_pool_conns_total{event="created"}`,
+		"iqapi_pool_size 1",
+		"iqapi_deadline_cmd_seconds ",
+		"iqfeed_connected 1",
+		`iqfeed_server_info{ip="66.112.148.111",port="60002",version="6.2.0.25"} 1`,
+		`iqfeed_kbps{direction="sent"} 0.02`,
+		"iqfeed_reconnections 3",
+		"iqfeed_symbols 12",
+	} {
+		if !strings.Contains(res.Body.String(), line) {
+			t.Errorf("missing=%s", line)
+		}
+	}
+}
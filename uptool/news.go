@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headline is a NHL result
+type Headline struct {
+	ID       string
+	Source   string
+	Datetime time.Time
+	Symbols  []string
+	Headline string
+}
+
+func (h Headline) CSVHeader() []string {
+	return []string{"ID", "Source", "Datetime", "Symbols", "Headline"}
+}
+func (h Headline) CSVRecord() []string {
+	return []string{h.ID, h.Source, h.Datetime.Format("2006-01-02 15:04:05"), strings.Join(h.Symbols, ":"), h.Headline}
+}
+
+// Story is a NSS result
+type Story struct {
+	ID      string
+	IsLink  bool `json:",omitempty"`
+	Symbols []string
+	Text    string
+}
+
+// StoryCount is a NSC result
+type StoryCount struct {
+	Symbol string
+	Count  int
+}
+
+func (c StoryCount) CSVHeader() []string {
+	return []string{"Symbol", "Count"}
+}
+func (c StoryCount) CSVRecord() []string {
+	return []string{c.Symbol, strconv.Itoa(c.Count)}
+}
+
+// NewsType is a news source (major or minor type) of the NCG configuration
+type NewsType struct {
+	Type     string
+	Name     string
+	AuthCode string     `json:",omitempty"`
+	IconID   string     `json:",omitempty"`
+	Minor    []NewsType `json:",omitempty"`
+}
+
+// NewsCategory groups news sources
+type NewsCategory struct {
+	Name  string
+	Types []NewsType
+}
+
+// newsSymbols splits :AAPL:MSFT: into symbols
+func newsSymbols(val string) []string {
+	var out []string
+	for _, sym := range strings.Split(val, ":") {
+		if sym = strings.TrimSpace(sym); sym != "" {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// newsDatetime parses the YYYYMMDDHHMMSS news timestamp
+func newsDatetime(val string) (time.Time, error) {
+	return time.ParseInLocation("20060102150405", strings.TrimSpace(val), exchangeTZ)
+}
+
+// newsText drops the message ID of a text reply line
+func newsText(bin []byte, ids ...string) []byte {
+	for _, id := range ids {
+		if bytes.HasPrefix(bin, []byte(id+",")) {
+			return bin[len(id)+1:]
+		}
+	}
+	return bin
+}
+
+// parseHeadlinesXML converts the NHL XML reply
+//
+//	<news_headlines><news_headline><id>22424</id><source>DTN</source><timestamp>20240110123456</timestamp>
+//	<symbols>:AAPL:</symbols><text>Headline</text></news_headline></news_headlines>
+func parseHeadlinesXML(bin []byte) ([]Headline, error) {
+	var doc struct {
+		Headlines []struct {
+			ID        string `xml:"id"`
+			Source    string `xml:"source"`
+			Timestamp string `xml:"timestamp"`
+			Symbols   string `xml:"symbols"`
+			Text      string `xml:"text"`
+		} `xml:"news_headline"`
+	}
+	if e := xml.Unmarshal(bin, &doc); e != nil {
+		return nil, e
+	}
+	out := make([]Headline, 0, len(doc.Headlines))
+	for _, h := range doc.Headlines {
+		dt, e := newsDatetime(h.Timestamp)
+		if e != nil {
+			return nil, fmt.Errorf("headline %s timestamp: %w", h.ID, e)
+		}
+		out = append(out, Headline{ID: h.ID, Source: h.Source, Datetime: dt, Symbols: newsSymbols(h.Symbols), Headline: strings.TrimSpace(h.Text)})
+	}
+	return out, nil
+}
+
+// parseHeadlineText converts a NHL text line
+// N,DTN,22424,:AAPL:,20240110123456,Headline, with commas
+func parseHeadlineText(bin []byte) (Headline, error) {
+	var h Headline
+	buf := bytes.SplitN(bytes.TrimSuffix(newsText(bin, "NH", "N"), []byte(",")), []byte(","), 5)
+	if len(buf) < 5 {
+		return h, fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	}
+	h.Source = string(buf[0])
+	h.ID = string(buf[1])
+	h.Symbols = newsSymbols(string(buf[2]))
+	var e error
+	if h.Datetime, e = newsDatetime(string(buf[3])); e != nil {
+		return h, fmt.Errorf("headline %s timestamp: %w", h.ID, e)
+	}
+	h.Headline = string(buf[4])
+	return h, nil
+}
+
+// parseStoryXML converts the NSS XML reply
+//
+//	<news_stories><news_story><is_link>N</is_link><story_text>...</story_text><symbols>:AAPL:</symbols></news_story></news_stories>
+func parseStoryXML(id string, bin []byte) (Story, error) {
+	var doc struct {
+		Stories []struct {
+			IsLink  string `xml:"is_link"`
+			Text    string `xml:"story_text"`
+			Symbols string `xml:"symbols"`
+		} `xml:"news_story"`
+	}
+	if e := xml.Unmarshal(bin, &doc); e != nil {
+		return Story{}, e
+	}
+	if len(doc.Stories) == 0 {
+		return Story{}, fmt.Errorf("!NO_DATA!")
+	}
+	s := doc.Stories[0]
+	return Story{ID: id, IsLink: s.IsLink == "Y", Symbols: newsSymbols(s.Symbols), Text: strings.TrimSpace(s.Text)}, nil
+}
+
+// parseStoryText converts the NSS text reply (the story lines)
+func parseStoryText(id string, lines [][]byte) Story {
+	var text []string
+	for _, line := range lines {
+		text = append(text, string(newsText(line, "NS")))
+	}
+	return Story{ID: id, Text: strings.TrimSpace(strings.Join(text, "\n"))}
+}
+
+// parseStoryCountsXML converts the NSC XML reply
+//
+//	<story_counts><symbol Name="AAPL" StoryCount="5"/></story_counts>
+func parseStoryCountsXML(bin []byte) ([]StoryCount, error) {
+	var doc struct {
+		Symbols []struct {
+			Name  string `xml:"Name,attr"`
+			Count int    `xml:"StoryCount,attr"`
+		} `xml:"symbol"`
+	}
+	if e := xml.Unmarshal(bin, &doc); e != nil {
+		return nil, e
+	}
+	out := make([]StoryCount, 0, len(doc.Symbols))
+	for _, s := range doc.Symbols {
+		out = append(out, StoryCount{Symbol: s.Name, Count: s.Count})
+	}
+	return out, nil
+}
+
+// parseStoryCountsText converts the NSC text reply, AAPL:5;MSFT:3;
+func parseStoryCountsText(bin []byte) ([]StoryCount, error) {
+	var out []StoryCount
+	for _, pair := range strings.Split(string(newsText(bin, "NC")), ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		sym, count, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("WARN: Failed parsing count=%s\n", pair)
+		}
+		n, e := strconv.Atoi(count)
+		if e != nil {
+			return nil, fmt.Errorf("WARN: Failed parsing count=%s\n", pair)
+		}
+		out = append(out, StoryCount{Symbol: sym, Count: n})
+	}
+	return out, nil
+}
+
+// xmlNewsType is a Major_Type/Minor_Type of the NCG reply
+type xmlNewsType struct {
+	Type     string        `xml:"type,attr"`
+	Name     string        `xml:"name,attr"`
+	AuthCode string        `xml:"auth_code,attr"`
+	IconID   string        `xml:"icon_id,attr"`
+	Minor    []xmlNewsType `xml:"Minor_Type"`
+}
+
+func (t xmlNewsType) normalize() NewsType {
+	out := NewsType{Type: t.Type, Name: t.Name, AuthCode: t.AuthCode, IconID: t.IconID}
+	for _, m := range t.Minor {
+		out.Minor = append(out.Minor, m.normalize())
+	}
+	return out
+}
+
+// parseNewsConfigXML converts the NCG XML reply
+//
+//	<DynamicNewsConf><Category name="News"><Major_Type type="DTN" name="DTN News" auth_code="1" icon_id="1">
+//	<Minor_Type type="DTN:BUS" name="Business" .../></Major_Type></Category></DynamicNewsConf>
+func parseNewsConfigXML(bin []byte) ([]NewsCategory, error) {
+	var doc struct {
+		Categories []struct {
+			Name  string        `xml:"name,attr"`
+			Types []xmlNewsType `xml:"Major_Type"`
+		} `xml:"Category"`
+	}
+	if e := xml.Unmarshal(bin, &doc); e != nil {
+		return nil, e
+	}
+	out := make([]NewsCategory, 0, len(doc.Categories))
+	for _, c := range doc.Categories {
+		cat := NewsCategory{Name: c.Name}
+		for _, t := range c.Types {
+			cat.Types = append(cat.Types, t.normalize())
+		}
+		out = append(out, cat)
+	}
+	return out, nil
+}
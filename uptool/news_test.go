@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseNews(t *testing.T) {
+	hs, e := parseHeadlinesXML([]byte(`<?xml version="1.0"?>
+<news_headlines>
+<news_headline><id>22424</id><source>DTN</source><timestamp>20240110123456</timestamp><symbols>:AAPL:MSFT:</symbols><text>Apple &amp; Microsoft</text></news_headline>
+</news_headlines>`))
+	if e != nil {
+		t.Fatalf("parseHeadlinesXML e=%s", e.Error())
+	}
+	if len(hs) != 1 || hs[0].ID != "22424" || len(hs[0].Symbols) != 2 || hs[0].Headline != "Apple & Microsoft" || hs[0].Datetime.Hour() != 12 {
+		t.Errorf("parseHeadlinesXML=%+v", hs)
+	}
+
+	h, e := parseHeadlineText([]byte("N,DTN,22424,:AAPL:,20240110123456,Apple, again,"))
+	if e != nil || h.Source != "DTN" || h.ID != "22424" || h.Symbols[0] != "AAPL" || h.Headline != "Apple, again" {
+		t.Errorf("parseHeadlineText=%+v e=%v", h, e)
+	}
+
+	s, e := parseStoryXML("22424", []byte(`<news_stories><news_story><is_link>N</is_link><story_text>Line 1
+Line 2</story_text><symbols>:AAPL:</symbols></news_story></news_stories>`))
+	if e != nil || s.IsLink || s.Text != "Line 1\nLine 2" || s.Symbols[0] != "AAPL" {
+		t.Errorf("parseStoryXML=%+v e=%v", s, e)
+	}
+
+	cs, e := parseStoryCountsXML([]byte(`<story_counts><symbol Name="AAPL" StoryCount="5"/><symbol Name="MSFT" StoryCount="0"/></story_counts>`))
+	if e != nil || len(cs) != 2 || cs[0].Count != 5 {
+		t.Errorf("parseStoryCountsXML=%+v e=%v", cs, e)
+	}
+	cs, e = parseStoryCountsText([]byte("AAPL:5;MSFT:3;"))
+	if e != nil || len(cs) != 2 || cs[1].Symbol != "MSFT" || cs[1].Count != 3 {
+		t.Errorf("parseStoryCountsText=%+v e=%v", cs, e)
+	}
+
+	conf, e := parseNewsConfigXML([]byte(`<DynamicNewsConf><Category name="News"><Major_Type type="DTN" name="DTN News" auth_code="1" icon_id="2"><Minor_Type type="DTN:BUS" name="Business"/></Major_Type></Category></DynamicNewsConf>`))
+	if e != nil || len(conf) != 1 || conf[0].Types[0].Type != "DTN" || conf[0].Types[0].Minor[0].Name != "Business" {
+		t.Errorf("parseNewsConfigXML=%+v e=%v", conf, e)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** defaultProbeInterval is the time between two probe checks */
+const defaultProbeInterval = 250 * time.Millisecond
+
+/** defaultProbeTimeout is the time a process gets to become ready */
+const defaultProbeTimeout = 30 * time.Second
+
+/** errExited is returned by Probe.wait when the process stopped before it was ready */
+var errExited = errors.New("exited before ready")
+
+// Probe decides when a started process is ready, set one of X11/TCP/Log/Cmd.
+// Without a probe a process is ready when it's still running after 1sec.
+type Probe struct {
+	X11  string   `yaml:"x11"`  // display whose socket must exist, i.e. :0
+	TCP  string   `yaml:"tcp"`  // host:port that must accept
+	Log  string   `yaml:"log"`  // regex an output line must match
+	Cmd  string   `yaml:"cmd"`  // command that must exit 0
+	Args []string `yaml:"args"` // of Cmd
+
+	Interval time.Duration `yaml:"interval"` // between checks (250ms)
+	Timeout  time.Duration `yaml:"timeout"`  // before the process is killed and dependents report an error (30s)
+}
+
+// String describes the check of p for errors
+func (p *Probe) String() string {
+	switch {
+	case p == nil:
+		return "running 1s"
+	case p.X11 != "":
+		return "x11 " + p.X11
+	case p.TCP != "":
+		return "tcp " + p.TCP
+	case p.Log != "":
+		return "log /" + p.Log + "/"
+	case p.Cmd != "":
+		return "cmd " + strings.Join(append([]string{p.Cmd}, p.Args...), " ")
+	}
+	return "running 1s"
+}
+
+// timeout returns the timeout of p (nil-safe)
+func (p *Probe) timeout() time.Duration {
+	if p == nil || p.Timeout == 0 {
+		return defaultProbeTimeout
+	}
+	return p.Timeout
+}
+
+// validate returns the problems of p prefixed with prefix (cmds.X.ready)
+func (p *Probe) validate(prefix string) []error {
+	if p == nil {
+		return nil
+	}
+	var errs []error
+	set := 0
+	for _, val := range []string{p.X11, p.TCP, p.Log, p.Cmd} {
+		if val != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		errs = append(errs, fmt.Errorf("%s needs exactly one of x11|tcp|log|cmd", prefix))
+	}
+	if p.X11 != "" {
+		if _, e := x11Socket(p.X11); e != nil {
+			errs = append(errs, fmt.Errorf("%s.x11=%s %w", prefix, p.X11, e))
+		}
+	}
+	if p.TCP != "" {
+		if _, _, e := net.SplitHostPort(p.TCP); e != nil {
+			errs = append(errs, fmt.Errorf("%s.tcp=%q invalid, expected host:port", prefix, p.TCP))
+		}
+	}
+	if p.Log != "" {
+		if _, e := regexp.Compile(p.Log); e != nil {
+			errs = append(errs, fmt.Errorf("%s.log invalid regex: %w", prefix, e))
+		}
+	}
+	for name, d := range map[string]time.Duration{"interval": p.Interval, "timeout": p.Timeout} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s=%s must be positive", prefix, name, d))
+		}
+	}
+	return errs
+}
+
+// x11Socket returns the unix socket of display (:0 or :0.0 is /tmp/.X11-unix/X0)
+func x11Socket(display string) (string, error) {
+	num, ok := strings.CutPrefix(display, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid display, expected :N")
+	}
+	num, _, _ = strings.Cut(num, ".")
+	if num == "" || strings.Trim(num, "0123456789") != "" {
+		return "", fmt.Errorf("invalid display, expected :N")
+	}
+	return "/tmp/.X11-unix/X" + num, nil
+}
+
+// check returns nil when the process is ready
+func (p *Probe) check(ctx context.Context, logs *logMatcher) error {
+	switch {
+	case p.X11 != "":
+		path, e := x11Socket(p.X11)
+		if e != nil {
+			return e
+		}
+		_, e = os.Stat(path)
+		return e
+	case p.TCP != "":
+		conn, e := net.DialTimeout("tcp", p.TCP, defaultConnectTimeout)
+		if e != nil {
+			return e
+		}
+		return conn.Close()
+	case p.Log != "":
+		if logs == nil || !logs.Matched() {
+			return fmt.Errorf("no line matched yet")
+		}
+		return nil
+	case p.Cmd != "":
+		out, e := exec.CommandContext(ctx, p.Cmd, p.Args...).CombinedOutput()
+		if e != nil {
+			return fmt.Errorf("%w %s", e, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+	return nil
+}
+
+// wait blocks until p passes, the process exited (errExited) or ctx is done
+func (p *Probe) wait(ctx context.Context, exited <-chan struct{}, logs *logMatcher) error {
+	if p == nil {
+		// Legacy: ready when still running after 1sec
+		select {
+		case <-exited:
+			return errExited
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	interval := p.Interval
+	if interval == 0 {
+		interval = defaultProbeInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		e := p.check(ctx, logs)
+		if e == nil {
+			return nil
+		}
+		select {
+		case <-exited:
+			return errExited
+		case <-ctx.Done():
+			return fmt.Errorf("%s not passing after %s: %w", p, p.timeout(), e)
+		case <-t.C:
+		}
+	}
+}
+
+// logMatcher remembers if an output line matched re
+type logMatcher struct {
+	re      *regexp.Regexp
+	mu      sync.Mutex
+	matched bool
+}
+
+func newLogMatcher(re *regexp.Regexp) *logMatcher {
+	return &logMatcher{re: re}
+}
+
+// Line checks an output line, called by the stdout and stderr writer
+func (l *logMatcher) Line(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.matched && l.re.MatchString(line) {
+		l.matched = true
+	}
+}
+
+// Matched returns if a line matched
+func (l *logMatcher) Matched() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.matched
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestX11Socket(t *testing.T) {
+	for display, expect := range map[string]string{":0": "/tmp/.X11-unix/X0", ":10.0": "/tmp/.X11-unix/X10", "0": "", ":": "", ":a": ""} {
+		path, e := x11Socket(display)
+		if path != expect || (expect == "") != (e != nil) {
+			t.Errorf("display=%s path=%s e=%v", display, path, e)
+		}
+	}
+}
+
+func TestProbeValidate(t *testing.T) {
+	for _, c := range []struct {
+		probe  Probe
+		expect string
+	}{
+		{Probe{X11: ":0"}, ""},
+		{Probe{}, "needs exactly one of x11|tcp|log|cmd"},
+		{Probe{X11: ":0", TCP: "127.0.0.1:9300"}, "needs exactly one of x11|tcp|log|cmd"},
+		{Probe{X11: "0"}, "ready.x11=0 invalid display"},
+		{Probe{TCP: "9300"}, "ready.tcp=\"9300\" invalid"},
+		{Probe{Log: "Conn(ected"}, "ready.log invalid regex"},
+		{Probe{Cmd: "true", Timeout: -time.Second}, "ready.timeout=-1s must be positive"},
+	} {
+		e := errors.Join(c.probe.validate("cmds.x.ready")...)
+		if c.expect == "" && e != nil || c.expect != "" && (e == nil || !strings.Contains(e.Error(), c.expect)) {
+			t.Errorf("probe=%+v e=%v expected=%s", c.probe, e, c.expect)
+		}
+	}
+}
+
+func TestLogMatcher(t *testing.T) {
+	l := newLogMatcher(regexp.MustCompile(`^Connected to \d+`))
+	w := ProcessLog("test-matcher").Writer("stdout", l.Line)
+	w.Write([]byte("Starting\nConnec"))
+	if l.Matched() {
+		t.Fatal("matched half a line")
+	}
+	w.Write([]byte("ted to 60002\r\n"))
+	if !l.Matched() {
+		t.Error("not matched")
+	}
+}
+
+func TestProbeWait(t *testing.T) {
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := &Probe{TCP: addr, Interval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	e = p.wait(ctx, nil, nil)
+	if e == nil || !strings.Contains(e.Error(), "tcp "+addr+" not passing after 100ms") {
+		t.Errorf("e=%v", e)
+	}
+
+	// port opens while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if ln, e := net.Listen("tcp", addr); e == nil {
+			t.Cleanup(func() { ln.Close() })
+		}
+	}()
+	p.Timeout = 2 * time.Second
+	ctx, cancel = context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	if e := p.wait(ctx, nil, nil); e != nil {
+		t.Error(e)
+	}
+
+	// process gone
+	exited := make(chan struct{})
+	close(exited)
+	p = &Probe{Cmd: "false"}
+	if e := p.wait(context.Background(), exited, nil); e != errExited {
+		t.Errorf("e=%v", e)
+	}
+}
+
+func TestRunReady(t *testing.T) {
+	if Running == nil {
+		Running = new(sync.Map)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- run("test-ready", "/bin/sh", []string{"-c", "sleep 0.1; echo listening; sleep 0.5"}, &Probe{Log: "^listening$", Interval: 10 * time.Millisecond})
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := Running.Load("test-ready"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not marked running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p, _ := Process("test-ready"); p.State != ProcRunning || p.Pid == 0 {
+		t.Errorf("state=%+v", p)
+	}
+	if e := <-done; e != nil {
+		t.Error(e)
+	}
+	if _, ok := Running.Load("test-ready"); ok {
+		t.Error("still marked running after exit")
+	}
+
+	// never ready is killed
+	e := run("test-never", "/bin/sh", []string{"-c", "sleep 30"}, &Probe{Log: "never", Timeout: 100 * time.Millisecond})
+	if e == nil || !strings.Contains(e.Error(), "[test-never] not ready: log /never/ not passing after 100ms") {
+		t.Errorf("e=%v", e)
+	}
+}
+
+func TestWaitDepFailed(t *testing.T) {
+	if Running == nil {
+		Running = new(sync.Map)
+	}
+	setProcState("test-dep", ProcFailed, func(p *ProcState) { p.LastError = "bad login" })
+	e := waitDep("test-child", "test-dep", time.Minute)
+	if e == nil || !strings.Contains(e.Error(), "dep test-dep failed: bad login") {
+		t.Errorf("e=%v", e)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLines is the amount of output lines kept per process
+var LogLines = 2000
+
+/** crashLogTime is the timestamp in crash log names (sortable, ms to not collide) */
+const crashLogTime = "20060102T150405.000"
+
+/** maxLogLine is the longest line kept, longer lines are split */
+const maxLogLine = 16 * 1024
+
+// LogLine is one line of process output
+type LogLine struct {
+	Time   time.Time
+	Stream string // stdout|stderr|supervisor
+	Line   string
+}
+
+// String formats l for the log tail and crash logs
+func (l LogLine) String() string {
+	return l.Time.UTC().Format(time.RFC3339Nano) + " " + l.Stream + " " + l.Line
+}
+
+// LogRing keeps the last LogLines output lines of a process and
+// sends new lines to the followers
+type LogRing struct {
+	name   string
+	format string // "" or iqconnect
+
+	mu    sync.Mutex
+	lines []LogLine
+	next  int // write position in lines once full
+	subs  map[chan LogLine]struct{}
+}
+
+var (
+	logRingsMu sync.Mutex
+	logRings   = make(map[string]*LogRing)
+)
+
+// ProcessLog returns the output of process name (created on first use)
+func ProcessLog(name string) *LogRing {
+	logRingsMu.Lock()
+	defer logRingsMu.Unlock()
+	r, ok := logRings[name]
+	if !ok {
+		r = &LogRing{name: name, subs: make(map[chan LogLine]struct{})}
+		logRings[name] = r
+	}
+	return r
+}
+
+// ProcessLogNames returns the processes with output sorted by name
+func ProcessLogNames() []string {
+	logRingsMu.Lock()
+	defer logRingsMu.Unlock()
+	names := make([]string, 0, len(logRings))
+	for name := range logRings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// add stores line and logs it as slog record tagged with the process name
+func (r *LogRing) add(stream, line string) {
+	l := LogLine{Time: time.Now(), Stream: stream, Line: line}
+	r.mu.Lock()
+	if len(r.lines) < LogLines {
+		r.lines = append(r.lines, l)
+	} else {
+		r.lines[r.next] = l
+		r.next = (r.next + 1) % len(r.lines)
+	}
+	format := r.format
+	for ch := range r.subs {
+		select {
+		case ch <- l:
+		default:
+			// slow follower, drop instead of blocking the process
+		}
+	}
+	r.mu.Unlock()
+
+	level, attrs := slog.LevelInfo, []any{"name", r.name, "stream", stream}
+	if format == "iqconnect" {
+		level, attrs, line = parseIQConnectLog(line, attrs)
+	}
+	slog.Log(context.Background(), level, "proc", append(attrs, "line", line)...)
+}
+
+// SetFormat sets how lines are logged ("" or iqconnect)
+func (r *LogRing) SetFormat(format string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.format = format
+}
+
+// Tail returns the last n lines (n<=0 is all)
+func (r *LogRing) Tail(n int) []LogLine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]LogLine, 0, len(r.lines))
+	out = append(out, r.lines[r.next:]...)
+	out = append(out, r.lines[:r.next]...)
+	if n > 0 && n < len(out) {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+// Follow returns a chan with the new lines, call stop when done
+func (r *LogRing) Follow() (lines <-chan LogLine, stop func()) {
+	ch := make(chan LogLine, 256)
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.subs, ch)
+		r.mu.Unlock()
+	}
+}
+
+// Writer returns an io.Writer that adds every line to r and calls
+// onLine (i.e. the ready probe), one per stream as writes aren't synced
+func (r *LogRing) Writer(stream string, onLine func(line string)) io.Writer {
+	return &ringWriter{ring: r, stream: stream, onLine: onLine}
+}
+
+type ringWriter struct {
+	ring   *LogRing
+	stream string
+	onLine func(line string)
+	buf    []byte
+}
+
+func (w *ringWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			if len(w.buf) < maxLogLine {
+				break
+			}
+			i = maxLogLine
+		}
+		line := string(bytes.TrimRight(w.buf[:i], "\r\n"))
+		w.buf = w.buf[min(i+1, len(w.buf)):]
+		w.ring.add(w.stream, line)
+		if w.onLine != nil {
+			w.onLine(line)
+		}
+	}
+	return len(p), nil
+}
+
+/** iqconnectTime matches the timestamp IQConnectLog.txt lines start with */
+var iqconnectTime = regexp.MustCompile(`^(\d{8} \d{2}:\d{2}:\d{2}(?:\.\d+)?|\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)\s+`)
+
+// parseIQConnectLog splits the timestamp off an IQConnectLog line and
+// raises the level of errors and warnings
+func parseIQConnectLog(line string, attrs []any) (slog.Level, []any, string) {
+	if m := iqconnectTime.FindStringSubmatch(line); m != nil {
+		attrs = append(attrs, "logTime", m[1])
+		line = line[len(m[0]):]
+	}
+	level := slog.LevelInfo
+	upper := strings.ToUpper(line)
+	switch {
+	case strings.Contains(upper, "ERROR") || strings.Contains(upper, "FAILED"):
+		level = slog.LevelError
+	case strings.Contains(upper, "WARN") || strings.Contains(upper, "DISCONNECT"):
+		level = slog.LevelWarn
+	}
+	return level, attrs, line
+}
+
+// CrashLog keeps the output of a stopped process in Dir
+type CrashLog struct {
+	Dir   string   `yaml:"dir"`   // empty disables
+	Keep  int      `yaml:"keep"`  // newest crash logs per process (10)
+	Files []string `yaml:"files"` // log files copied along (when regular files, not symlinked to stderr)
+}
+
+// keep returns how many crash logs are kept
+func (c CrashLog) keep() int {
+	if c.Keep == 0 {
+		return 10
+	}
+	return c.Keep
+}
+
+// Save writes the output of ring (and the Files) as <name>-<time>.log into
+// Dir and removes all but the newest Keep crash logs of ring
+func (c CrashLog) Save(ring *LogRing, now time.Time) error {
+	if c.Dir == "" {
+		return nil
+	}
+	if e := os.MkdirAll(c.Dir, 0755); e != nil {
+		return e
+	}
+	prefix := filepath.Join(c.Dir, ring.name+"-"+now.UTC().Format(crashLogTime))
+
+	var buf bytes.Buffer
+	for _, l := range ring.Tail(0) {
+		buf.WriteString(l.String() + "\n")
+	}
+	if e := os.WriteFile(prefix+".log", buf.Bytes(), 0644); e != nil {
+		return e
+	}
+	for _, path := range c.Files {
+		if e := copyRegular(path, prefix+"-"+filepath.Base(path)); e != nil {
+			slog.Warn("proclog(CrashLog) copy", "name", ring.name, "path", path, "e", e.Error())
+		}
+	}
+	return c.rotate(ring.name)
+}
+
+// copyRegular copies src to dst when src is a regular file
+func copyRegular(src, dst string) error {
+	fi, e := os.Stat(src)
+	if e != nil || !fi.Mode().IsRegular() {
+		// missing or /dev/stderr, the output is in the ring
+		return e
+	}
+	in, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+	out, e := os.Create(dst)
+	if e != nil {
+		return e
+	}
+	if _, e := io.Copy(out, in); e != nil {
+		out.Close()
+		return e
+	}
+	return out.Close()
+}
+
+// rotate removes the files of all but the newest Keep crashes of name
+func (c CrashLog) rotate(name string) error {
+	entries, e := os.ReadDir(c.Dir)
+	if e != nil {
+		return e
+	}
+	// name-<time>.log and name-<time>-<file>
+	byTime := make(map[string][]string)
+	for _, entry := range entries {
+		rest, ok := strings.CutPrefix(entry.Name(), name+"-")
+		if !ok || len(rest) < len(crashLogTime) {
+			continue
+		}
+		stamp := rest[:len(crashLogTime)]
+		if _, e := time.Parse(crashLogTime, stamp); e != nil {
+			continue
+		}
+		byTime[stamp] = append(byTime[stamp], entry.Name())
+	}
+	stamps := make([]string, 0, len(byTime))
+	for stamp := range byTime {
+		stamps = append(stamps, stamp)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(stamps)))
+
+	var errs []string
+	for _, stamp := range stamps[min(c.keep(), len(stamps)):] {
+		for _, file := range byTime[stamp] {
+			if e := os.Remove(filepath.Join(c.Dir, file)); e != nil {
+				errs = append(errs, e.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("rotate: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRing(t *testing.T) {
+	old := LogLines
+	LogLines = 3
+	defer func() { LogLines = old }()
+
+	r := &LogRing{name: "test", subs: make(map[chan LogLine]struct{})}
+	lines, stop := r.Follow()
+	w := r.Writer("stdout", nil)
+	w.Write([]byte("1\n2\n3\n4\n5"))
+	w.Write([]byte("\n"))
+
+	tail := r.Tail(0)
+	if len(tail) != 3 || tail[0].Line != "3" || tail[2].Line != "5" {
+		t.Errorf("tail=%+v", tail)
+	}
+	if tail := r.Tail(1); len(tail) != 1 || tail[0].Line != "5" || tail[0].Stream != "stdout" {
+		t.Errorf("tail(1)=%+v", tail)
+	}
+	for _, expect := range []string{"1", "2", "3", "4", "5"} {
+		if l := <-lines; l.Line != expect {
+			t.Errorf("follow=%s expected=%s", l.Line, expect)
+		}
+	}
+	stop()
+	if len(r.subs) != 0 {
+		t.Error("follower not removed")
+	}
+}
+
+func TestParseIQConnectLog(t *testing.T) {
+	level, attrs, line := parseIQConnectLog("20240510 09:31:00.123   Login failed: invalid password", nil)
+	if level != slog.LevelError || line != "Login failed: invalid password" || len(attrs) != 2 || attrs[1] != "20240510 09:31:00.123" {
+		t.Errorf("level=%s attrs=%v line=%s", level, attrs, line)
+	}
+	if level, _, line := parseIQConnectLog("Server disconnected", nil); level != slog.LevelWarn || line != "Server disconnected" {
+		t.Errorf("level=%s line=%s", level, line)
+	}
+}
+
+func TestCrashLog(t *testing.T) {
+	dir := t.TempDir()
+	extra := filepath.Join(dir, "IQConnectLog.txt")
+	if e := os.WriteFile(extra, []byte("login failed\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	c := CrashLog{Dir: filepath.Join(dir, "crash"), Keep: 2, Files: []string{extra, "/dev/stderr"}}
+
+	r := ProcessLog("test-crash")
+	r.add("stderr", "crashed")
+	now := time.Date(2024, 5, 10, 9, 31, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if e := c.Save(r, now.Add(time.Duration(i)*time.Second)); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	entries, e := os.ReadDir(c.Dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expect := "test-crash-20240510T093101.000-IQConnectLog.txt test-crash-20240510T093101.000.log test-crash-20240510T093102.000-IQConnectLog.txt test-crash-20240510T093102.000.log"
+	if strings.Join(names, " ") != expect {
+		t.Errorf("files=%v", names)
+	}
+	bin, e := os.ReadFile(filepath.Join(c.Dir, "test-crash-20240510T093102.000.log"))
+	if e != nil || !strings.Contains(string(bin), " stderr crashed\n") {
+		t.Errorf("log=%s e=%v", bin, e)
+	}
+}
+
+func TestAdminLogs(t *testing.T) {
+	r := ProcessLog("test-http")
+	r.add("stdout", "first")
+	r.add("stdout", "second")
+
+	res := httptest.NewRecorder()
+	adminLogs(res, httptest.NewRequest("GET", "/admin/logs/test-http?lines=1", nil))
+	if res.Code != 200 || !strings.HasSuffix(res.Body.String(), " stdout second\n") || strings.Contains(res.Body.String(), "first") {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	adminLogs(res, httptest.NewRequest("GET", "/admin/logs/nope", nil))
+	if res.Code != 404 || !strings.Contains(res.Body.String(), "test-http") {
+		t.Errorf("code=%d body=%s", res.Code, res.Body.String())
+	}
+
+	// follow
+	srv := httptest.NewServer(http.HandlerFunc(adminLogs))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, e := http.NewRequestWithContext(ctx, "GET", srv.URL+"/admin/logs/test-http?lines=1&follow=1", nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp, e := srv.Client().Do(req)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	if line, _ := br.ReadString('\n'); !strings.HasSuffix(line, " stdout second\n") {
+		t.Errorf("line=%s", line)
+	}
+	r.add("stderr", "third")
+	if line, _ := br.ReadString('\n'); !strings.HasSuffix(line, " stderr third\n") {
+		t.Errorf("line=%s", line)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/** quoteTimeout is the max time we wait for the summary msgs of a quote */
+const quoteTimeout = 5 * time.Second
+
+/** maxQuoteSymbols is the maximum of symbols per quote */
+const maxQuoteSymbols = 500
+
+// QuoteParallel is the max concurrent quotes, each one opens its own Level 1 conn
+var QuoteParallel = 2
+
+/** errQuoteBusy is returned by Quote when QuoteParallel quotes are running */
+var errQuoteBusy = errors.New("too many quotes running")
+
+// Quote is a snapshot of the selected update fields, numbers are converted
+// into float64 and everything else is kept as string
+type Quote map[string]interface{}
+
+// QuoteRes is the result of a quote request
+type QuoteRes struct {
+	Fields   []string
+	Quotes   map[string]Quote
+	NotFound []string `json:",omitempty"` // n-msg
+	Timeout  []string `json:",omitempty"` // no summary within quoteTimeout
+}
+
+// quoteValue converts a field into float64 when it's a number
+func quoteValue(val string) interface{} {
+	if f, e := strconv.ParseFloat(val, 64); e == nil {
+		return f
+	}
+	return val
+}
+
+// Quote opens a short-lived Level 1 conn so the selected update fields
+// don't change the layout of the shared conn, watches symbols and collects
+// the first summary (P) msg of every symbol
+func (l *Level1) Quote(symbols []string, fields []string) (QuoteRes, error) {
+	res := QuoteRes{Quotes: make(map[string]Quote)}
+
+	// IQFeed limits the conns and watched symbols
+	select {
+	case l.quotes <- struct{}{}:
+		defer func() { <-l.quotes }()
+	default:
+		return res, errQuoteBusy
+	}
+
+	conn, e := upstream.Dial(l.addr)
+	if e != nil {
+		return res, e
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(quoteTimeout)
+	if e := conn.SetDeadline(deadline); e != nil {
+		return res, e
+	}
+	cmds := []string{
+		"S,SET PROTOCOL,6.2",
+		"S,SET CLIENT NAME,IQAPI_QUOTE",
+		"S,SELECT UPDATE FIELDS," + strings.Join(fields, ","),
+	}
+	for _, sym := range symbols {
+		cmds = append(cmds, "w"+sym)
+	}
+	if _, e := conn.Write([]byte(strings.Join(cmds, "\r\n") + "\r\n")); e != nil {
+		return res, e
+	}
+
+	pending := make(map[string]struct{}, len(symbols))
+	for _, sym := range symbols {
+		pending[sym] = struct{}{}
+	}
+	var names []string
+	r := bufio.NewReader(conn)
+	for len(pending) > 0 {
+		bin, e := r.ReadBytes(byte('\n'))
+		if e != nil {
+			if ne, ok := e.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return res, e
+		}
+		bin = bytes.TrimSpace(bin)
+		if Verbose {
+			slog.Info("level1(Quote)", "stream", bin)
+		}
+
+		switch {
+		case bytes.HasPrefix(bin, []byte("S,CURRENT UPDATE FIELDNAMES,")):
+			names = strings.Split(strings.TrimSuffix(string(bin[len("S,CURRENT UPDATE FIELDNAMES,"):]), ","), ",")
+			res.Fields = names
+		case bytes.HasPrefix(bin, []byte("E,")):
+			// E,Invalid field: Foo
+			return res, fmt.Errorf("%s", bin[2:])
+		case bytes.HasPrefix(bin, []byte("n,")):
+			sym := strings.TrimSuffix(string(bin[2:]), ",")
+			if _, ok := pending[sym]; ok {
+				delete(pending, sym)
+				res.NotFound = append(res.NotFound, sym)
+			}
+		case bytes.HasPrefix(bin, []byte("P,")):
+			if names == nil {
+				// summary before the fieldnames, can't name the fields
+				continue
+			}
+			tok := strings.Split(strings.TrimSuffix(string(bin[2:]), ","), ",")
+			if _, ok := pending[tok[0]]; !ok {
+				continue
+			}
+			delete(pending, tok[0])
+			q := make(Quote, len(tok))
+			for name, val := range fieldMap(names, tok) {
+				q[name] = quoteValue(val)
+			}
+			q["Symbol"] = tok[0]
+			res.Quotes[tok[0]] = q
+		}
+	}
+
+	for _, sym := range symbols {
+		if _, ok := pending[sym]; ok {
+			res.Timeout = append(res.Timeout, sym)
+		}
+	}
+	return res, nil
+}
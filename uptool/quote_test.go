@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevel1Quote(t *testing.T) {
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, e := ln.Accept()
+		if e != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, e := r.ReadString('\n')
+			if e != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "S,SELECT UPDATE FIELDS,"):
+				conn.Write([]byte("S,CURRENT UPDATE FIELDNAMES,Symbol," + line[len("S,SELECT UPDATE FIELDS,"):] + "\r\n"))
+			case line == "wAAPL":
+				conn.Write([]byte("P,AAPL,187.15,187.10,187.20,1200,\r\n"))
+			case line == "wNOPE":
+				conn.Write([]byte("n,NOPE\r\n"))
+			}
+			// wSLOW never answers
+		}
+	}()
+
+	l := newLevel1(ln.Addr().String())
+	res, e := l.Quote([]string{"AAPL", "NOPE", "SLOW"}, []string{"Most Recent Trade", "Bid", "Ask", "Total Volume"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	q, ok := res.Quotes["AAPL"]
+	if !ok || q["Symbol"] != "AAPL" || q["Bid"] != 187.10 || q["Total Volume"] != float64(1200) {
+		t.Errorf("quote=%+v", res.Quotes)
+	}
+	if len(res.NotFound) != 1 || res.NotFound[0] != "NOPE" {
+		t.Errorf("notFound=%v", res.NotFound)
+	}
+	if len(res.Timeout) != 1 || res.Timeout[0] != "SLOW" {
+		t.Errorf("timeout=%v", res.Timeout)
+	}
+}
+
+func TestQuoteLimits(t *testing.T) {
+	defer func(u *Upstream, l *Level1) { upstream, level1 = u, l }(upstream, level1)
+	upstream = DefaultUpstream()
+	level1 = newLevel1("")
+
+	// not connected to IQFeed
+	upstream.Ready = func() error { return fmt.Errorf("iqfeed not running") }
+	res := httptest.NewRecorder()
+	quote(res, httptest.NewRequest("GET", "/quote?symbols=AAPL", nil))
+	if res.Code != 503 {
+		t.Errorf("not ready code=%d body=%s", res.Code, res.Body.String())
+	}
+
+	// all quote slots taken
+	upstream.Ready = func() error { return nil }
+	for i := 0; i < QuoteParallel; i++ {
+		level1.quotes <- struct{}{}
+	}
+	res = httptest.NewRecorder()
+	quote(res, httptest.NewRequest("GET", "/quote?symbols=AAPL", nil))
+	if res.Code != 503 {
+		t.Errorf("busy code=%d body=%s", res.Code, res.Body.String())
+	}
+}
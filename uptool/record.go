@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordEvent is one line of a session file, all events of one
+// proxy() share the ID as proxies run concurrently
+type RecordEvent struct {
+	Time time.Time
+	ID   uint64
+	Type string // cmd|line|end
+	Data string `json:",omitempty"` // cmd or reply line
+	Err  string `json:",omitempty"` // end with error
+}
+
+// Recorder writes all proxy() traffic to a gzipped NDJSON session file
+type Recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+	id  uint64
+}
+
+var recorder *Recorder
+
+// RecorderInit starts recording into path (appending would break gzip so it's truncated)
+func RecorderInit(path string) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	gz := gzip.NewWriter(f)
+	recorder = &Recorder{f: f, gz: gz, enc: json.NewEncoder(gz)}
+	slog.Info("record(RecorderInit) recording upstream", "path", path)
+	return nil
+}
+
+// write adds ev to the session file, caller must hold r.mu
+func (r *Recorder) write(ev RecordEvent) {
+	ev.Time = time.Now()
+	if e := r.enc.Encode(ev); e != nil {
+		slog.Error("record(write)", "e", e.Error())
+	}
+}
+
+// Cmd records cmd and returns the ID for the reply lines (nil-safe)
+func (r *Recorder) Cmd(cmd []byte) uint64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.id++
+	r.write(RecordEvent{ID: r.id, Type: "cmd", Data: string(cmd)})
+	return r.id
+}
+
+// Line records a reply line of cmd ID (nil-safe)
+func (r *Recorder) Line(id uint64, line []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(RecordEvent{ID: id, Type: "line", Data: string(line)})
+}
+
+// End records the end of cmd ID and flushes so a crash keeps the session (nil-safe)
+func (r *Recorder) End(id uint64, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev := RecordEvent{ID: id, Type: "end"}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	r.write(ev)
+	if e := r.gz.Flush(); e != nil {
+		slog.Error("record(End) flush", "e", e.Error())
+	}
+}
+
+// Close finishes the gzip stream
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e := r.gz.Close(); e != nil {
+		r.f.Close()
+		return e
+	}
+	return r.f.Close()
+}
+
+// readRecording reads a session file into cmd => reply lines (without EOM),
+// a cmd recorded more than once keeps the last reply
+func readRecording(path string, replies map[string][]string) error {
+	f, e := os.Open(path)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+	gz, e := gzip.NewReader(f)
+	if e != nil {
+		return fmt.Errorf("%s: %w", path, e)
+	}
+
+	type exchange struct {
+		cmd   string
+		lines []string
+	}
+	open := make(map[uint64]*exchange)
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	for {
+		var ev RecordEvent
+		if e := dec.Decode(&ev); e != nil {
+			if e == io.EOF || errors.Is(e, io.ErrUnexpectedEOF) {
+				// a session that wasn't closed ends after the last flush
+				break
+			}
+			return fmt.Errorf("%s: %w", path, e)
+		}
+		switch ev.Type {
+		case "cmd":
+			open[ev.ID] = &exchange{cmd: ev.Data}
+		case "line":
+			if x, ok := open[ev.ID]; ok && ev.Data != EOM {
+				x.lines = append(x.lines, ev.Data)
+			}
+		case "end":
+			x, ok := open[ev.ID]
+			if !ok {
+				continue
+			}
+			delete(open, ev.ID)
+			// skip replies cut short on our side (client gone, timeout), upstream errors are E-lines
+			upstreamErr := len(x.lines) > 0 && strings.HasPrefix(x.lines[len(x.lines)-1], "E,")
+			if ev.Err != "" && !upstreamErr {
+				continue
+			}
+			replies[x.cmd] = x.lines
+		}
+	}
+	return nil
+}
+
+// ReplayInit serves the lookup port from the session files with a FakeFeed
+func ReplayInit(paths []string) (*FakeFeed, error) {
+	replies := make(map[string][]string)
+	for _, path := range paths {
+		if e := readRecording(path, replies); e != nil {
+			return nil, e
+		}
+	}
+
+	f, e := NewFakeFeed()
+	if e != nil {
+		return nil, e
+	}
+	for cmd, lines := range replies {
+		f.Reply(cmd, lines...)
+	}
+	upstream = f.Upstream()
+	// Level 1/2 aren't recorded
+	upstream.Level1 = ""
+	upstream.Level2 = ""
+	slog.Info("record(ReplayInit) replaying upstream", "files", len(paths), "cmds", len(replies))
+	return f, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("HDX,AAPL,2", "LH,2024-05-09,184.6600,182.1100,182.5600,184.5700,48983000,0,", "LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,")
+
+	path := filepath.Join(t.TempDir(), "session.ndjson.gz")
+	if e := RecorderInit(path); e != nil {
+		t.Fatal(e)
+	}
+	lines := func(cmd string) ([]string, error) {
+		var out []string
+		e := proxy([]byte(cmd), -1, func(bin []byte) error {
+			out = append(out, string(bin))
+			return nil
+		})
+		return out, e
+	}
+	recorded, e := lines("HDX,AAPL,2")
+	if e != nil || len(recorded) != 2 {
+		t.Fatalf("lines=%v e=%v", recorded, e)
+	}
+	if _, e := lines("HDX,NOPE,2"); e == nil || e.Error() != "!NO_DATA!" {
+		t.Fatalf("e=%v", e)
+	}
+	if e := recorder.Close(); e != nil {
+		t.Fatal(e)
+	}
+	recorder = nil
+
+	// Replay without the FakeFeed we recorded from
+	f.Close()
+	old := upstream
+	replay, e := ReplayInit([]string{path})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() {
+		upstream = old
+		replay.Close()
+	}()
+
+	replayed, e := lines("HDX,AAPL,2")
+	if e != nil || strings.Join(replayed, "\n") != strings.Join(recorded, "\n") {
+		t.Errorf("replayed=%v recorded=%v e=%v", replayed, recorded, e)
+	}
+	if _, e := lines("HDX,NOPE,2"); e == nil || e.Error() != "!NO_DATA!" {
+		t.Errorf("e=%v", e)
+	}
+	if upstream.Level1 != "" || upstream.Level2 != "" {
+		t.Errorf("replay dials level1/2")
+	}
+}
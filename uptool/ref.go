@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListedMarket is a SLM line
+type ListedMarket struct {
+	ID        int
+	ShortName string
+	LongName  string
+	GroupID   int
+	GroupName string
+}
+
+// SecurityType is a SST line
+type SecurityType struct {
+	ID        int
+	ShortName string
+	LongName  string
+}
+
+// refFields splits a reference line into n fields, the
+// message ID (non-numeric first field) is dropped
+func refFields(bin []byte, n int) ([][]byte, error) {
+	bin = bytes.TrimSuffix(bin, []byte(","))
+	if i := bytes.IndexByte(bin, ','); i > 0 {
+		if _, e := strconv.Atoi(string(bin[:i])); e != nil {
+			bin = bin[i+1:]
+		}
+	}
+	buf := bytes.SplitN(bin, []byte(","), n)
+	if len(buf) < n {
+		return nil, fmt.Errorf("WARN: Failed parsing line=%s\n", bin)
+	}
+	return buf, nil
+}
+
+// parseListedMarket converts a SLM line
+func parseListedMarket(bin []byte) (ListedMarket, error) {
+	var m ListedMarket
+	buf, e := refFields(bin, 5)
+	if e != nil {
+		return m, e
+	}
+	if m.ID, e = strconv.Atoi(string(buf[0])); e != nil {
+		return m, fmt.Errorf("ID: %w", e)
+	}
+	m.ShortName = string(buf[1])
+	m.LongName = string(buf[2])
+	if m.GroupID, e = strconv.Atoi(string(buf[3])); e != nil {
+		return m, fmt.Errorf("GroupID: %w", e)
+	}
+	m.GroupName = string(buf[4])
+	return m, nil
+}
+
+// parseSecurityType converts a SST line
+func parseSecurityType(bin []byte) (SecurityType, error) {
+	var t SecurityType
+	buf, e := refFields(bin, 3)
+	if e != nil {
+		return t, e
+	}
+	if t.ID, e = strconv.Atoi(string(buf[0])); e != nil {
+		return t, fmt.Errorf("ID: %w", e)
+	}
+	t.ShortName = string(buf[1])
+	t.LongName = string(buf[2])
+	return t, nil
+}
+
+// TradeCondition is a STC line
+type TradeCondition struct {
+	ID        int
+	ShortName string
+	LongName  string
+}
+
+// IndustryCode is a SSC (SIC) or SNC (NAICS) line
+type IndustryCode struct {
+	Code        int
+	Description string
+}
+
+func (m ListedMarket) CSVHeader() []string {
+	return []string{"ListedMarketID", "ShortName", "LongName", "GroupID", "GroupName"}
+}
+func (m ListedMarket) CSVRecord() []string {
+	return []string{strconv.Itoa(m.ID), m.ShortName, m.LongName, strconv.Itoa(m.GroupID), m.GroupName}
+}
+
+func (t SecurityType) CSVHeader() []string {
+	return []string{"SecurityTypeID", "ShortName", "LongName"}
+}
+func (t SecurityType) CSVRecord() []string {
+	return []string{strconv.Itoa(t.ID), t.ShortName, t.LongName}
+}
+
+func (c TradeCondition) CSVHeader() []string {
+	return []string{"TradeConditionID", "ShortName", "LongName"}
+}
+func (c TradeCondition) CSVRecord() []string {
+	return []string{strconv.Itoa(c.ID), c.ShortName, c.LongName}
+}
+
+func (c IndustryCode) CSVHeader() []string {
+	return []string{"Code", "Description"}
+}
+func (c IndustryCode) CSVRecord() []string {
+	return []string{strconv.Itoa(c.Code), c.Description}
+}
+
+// parseTradeCondition converts a STC line
+func parseTradeCondition(bin []byte) (TradeCondition, error) {
+	st, e := parseSecurityType(bin)
+	return TradeCondition(st), e
+}
+
+// parseIndustryCode converts a SSC/SNC line
+func parseIndustryCode(bin []byte) (IndustryCode, error) {
+	var c IndustryCode
+	buf, e := refFields(bin, 2)
+	if e != nil {
+		return c, e
+	}
+	if c.Code, e = strconv.Atoi(string(buf[0])); e != nil {
+		return c, fmt.Errorf("Code: %w", e)
+	}
+	c.Description = string(buf[1])
+	return c, nil
+}
+
+// RefRefresh is how long we keep a reference table before fetching it again
+var RefRefresh = 24 * time.Hour
+
+/** symbolLookupLimit is the max lines of the SBF search for one exact symbol */
+const symbolLookupLimit = 5000
+
+// refTable is one lookup table fetched with cmd
+type refTable[T any] struct {
+	cmd   string
+	parse func(bin []byte) (int, T, error)
+
+	mu     sync.Mutex
+	rows   map[int]T
+	loaded time.Time
+}
+
+// Get returns the table, fetched when missing or older than RefRefresh.
+// When the refresh fails we keep serving the old table.
+func (t *refTable[T]) Get() (map[int]T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rows != nil && time.Since(t.loaded) < RefRefresh {
+		return t.rows, nil
+	}
+
+	rows := make(map[int]T)
+	if e := proxy([]byte(t.cmd), -1, func(bin []byte) error {
+		id, row, e := t.parse(bin)
+		if e != nil {
+			return e
+		}
+		rows[id] = row
+		return nil
+	}); e != nil {
+		if t.rows != nil {
+			slog.Warn("ref(Get) refresh failed, keeping old table", "cmd", t.cmd, "e", e.Error())
+			return t.rows, nil
+		}
+		return nil, fmt.Errorf("%s: %w", t.cmd, e)
+	}
+	t.rows = rows
+	t.loaded = time.Now()
+	return rows, nil
+}
+
+// List returns the table sorted by ID
+func (t *refTable[T]) List() ([]T, error) {
+	rows, e := t.Get()
+	if e != nil {
+		return nil, e
+	}
+	ids := make([]int, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	out := make([]T, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, rows[id])
+	}
+	return out, nil
+}
+
+// RefTables keeps the IQFeed reference tables in memory
+type RefTables struct {
+	markets    refTable[ListedMarket]
+	types      refTable[SecurityType]
+	conditions refTable[TradeCondition]
+	sic        refTable[IndustryCode]
+	naics      refTable[IndustryCode]
+
+	symbolsMu sync.Mutex
+	symbols   map[string]symbolEntry
+}
+
+// symbolEntry is a cached SymbolInfo
+type symbolEntry struct {
+	info   SymbolInfo
+	loaded time.Time
+}
+
+var refTables = &RefTables{
+	markets: refTable[ListedMarket]{cmd: "SLM", parse: func(bin []byte) (int, ListedMarket, error) {
+		m, e := parseListedMarket(bin)
+		return m.ID, m, e
+	}},
+	types: refTable[SecurityType]{cmd: "SST", parse: func(bin []byte) (int, SecurityType, error) {
+		t, e := parseSecurityType(bin)
+		return t.ID, t, e
+	}},
+	conditions: refTable[TradeCondition]{cmd: "STC", parse: func(bin []byte) (int, TradeCondition, error) {
+		c, e := parseTradeCondition(bin)
+		return c.ID, c, e
+	}},
+	sic: refTable[IndustryCode]{cmd: "SSC", parse: func(bin []byte) (int, IndustryCode, error) {
+		c, e := parseIndustryCode(bin)
+		return c.Code, c, e
+	}},
+	naics: refTable[IndustryCode]{cmd: "SNC", parse: func(bin []byte) (int, IndustryCode, error) {
+		c, e := parseIndustryCode(bin)
+		return c.Code, c, e
+	}},
+	symbols: make(map[string]symbolEntry),
+}
+
+// Markets returns all listed markets by ID
+func (t *RefTables) Markets() (map[int]ListedMarket, error) {
+	return t.markets.Get()
+}
+
+// Types returns all security types by ID
+func (t *RefTables) Types() (map[int]SecurityType, error) {
+	return t.types.Get()
+}
+
+// Symbol returns the listed market/security type of asset (exact match)
+func (t *RefTables) Symbol(asset string) (SymbolInfo, error) {
+	t.symbolsMu.Lock()
+	entry, ok := t.symbols[asset]
+	t.symbolsMu.Unlock()
+	if ok && time.Since(entry.loaded) < RefRefresh {
+		return entry.info, nil
+	}
+
+	var (
+		info  SymbolInfo
+		found bool
+	)
+	// SBF is a substring search (A matches thousands), stop on the exact match
+	if e := proxy([]byte(fmt.Sprintf("SBF,s,%s,,", asset)), symbolLookupLimit, func(bin []byte) error {
+		s, e := parseSymbolInfo(bin)
+		if e != nil {
+			return e
+		}
+		if s.Symbol == asset {
+			info, found = s, true
+			return errStopProxy
+		}
+		return nil
+	}); e != nil {
+		return info, e
+	}
+	if !found {
+		return info, fmt.Errorf("!NO_DATA!")
+	}
+	if markets, e := t.Markets(); e == nil {
+		info.Market = markets[info.MarketID].ShortName
+	}
+	if types, e := t.Types(); e == nil {
+		info.Type = types[info.TypeID].ShortName
+	}
+
+	t.symbolsMu.Lock()
+	// drop expired entries so the map doesn't grow forever
+	for sym, entry := range t.symbols {
+		if time.Since(entry.loaded) >= RefRefresh {
+			delete(t.symbols, sym)
+		}
+	}
+	t.symbols[asset] = symbolEntry{info: info, loaded: time.Now()}
+	t.symbolsMu.Unlock()
+	return info, nil
+}
+
+// resolveIDs converts names (or IDs) into IDs using names=ID=>ShortName
+func resolveIDs(vals []string, names map[int]string) ([]int, error) {
+	var out []int
+	for _, val := range vals {
+		if id, e := strconv.Atoi(val); e == nil {
+			out = append(out, id)
+			continue
+		}
+		found := false
+		for id, name := range names {
+			if strings.EqualFold(name, val) {
+				out = append(out, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown %q", val)
+		}
+	}
+	return out, nil
+}
+
+// MarketIDs resolves listed market names (NYSE, NASDAQ) or IDs
+func (t *RefTables) MarketIDs(vals []string) ([]int, error) {
+	markets, e := t.Markets()
+	if e != nil {
+		return nil, e
+	}
+	names := make(map[int]string, len(markets))
+	for id, m := range markets {
+		names[id] = m.ShortName
+	}
+	return resolveIDs(vals, names)
+}
+
+// TypeIDs resolves security type names (EQUITY, INDEX) or IDs
+func (t *RefTables) TypeIDs(vals []string) ([]int, error) {
+	types, e := t.Types()
+	if e != nil {
+		return nil, e
+	}
+	names := make(map[int]string, len(types))
+	for id, st := range types {
+		names[id] = st.ShortName
+	}
+	return resolveIDs(vals, names)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RestartPolicy decides how ensureRunning respawns a stopped process,
+// zero values (nil Jitter) use the defaults below
+type RestartPolicy struct {
+	Backoff     time.Duration `yaml:"backoff"`     // first delay, doubled on every crash (1s)
+	MaxBackoff  time.Duration `yaml:"maxBackoff"`  // delay cap (5m)
+	Jitter      *float64      `yaml:"jitter"`      // +/- fraction of the delay, 0 disables (0.2)
+	MaxRestarts int           `yaml:"maxRestarts"` // restarts within Window before giving up, -1 is unlimited (10)
+	Window      time.Duration `yaml:"window"`      // (15m)
+	ResetAfter  time.Duration `yaml:"resetAfter"`  // uptime that resets the backoff (2m)
+}
+
+/** defaultJitter is the Jitter of DefaultRestartPolicy */
+var defaultJitter = 0.2
+
+// DefaultRestartPolicy is used for every zero field of a RestartPolicy
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Backoff:     time.Second,
+		MaxBackoff:  5 * time.Minute,
+		Jitter:      &defaultJitter,
+		MaxRestarts: 10,
+		Window:      15 * time.Minute,
+		ResetAfter:  2 * time.Minute,
+	}
+}
+
+// withDefaults returns p with the zero fields set to the defaults
+func (p RestartPolicy) withDefaults() RestartPolicy {
+	d := DefaultRestartPolicy()
+	if p.Backoff == 0 {
+		p.Backoff = d.Backoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = d.MaxBackoff
+	}
+	if p.Jitter == nil {
+		p.Jitter = d.Jitter
+	}
+	if p.MaxRestarts == 0 {
+		p.MaxRestarts = d.MaxRestarts
+	}
+	if p.Window == 0 {
+		p.Window = d.Window
+	}
+	if p.ResetAfter == 0 {
+		p.ResetAfter = d.ResetAfter
+	}
+	return p
+}
+
+// validate returns the problems of p prefixed with prefix (cmds.X.restart)
+func (p RestartPolicy) validate(prefix string) []error {
+	var errs []error
+	for name, d := range map[string]time.Duration{"backoff": p.Backoff, "maxBackoff": p.MaxBackoff, "window": p.Window, "resetAfter": p.ResetAfter} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s=%s must be positive", prefix, name, d))
+		}
+	}
+	if p.Jitter != nil && (*p.Jitter < 0 || *p.Jitter > 1) {
+		errs = append(errs, fmt.Errorf("%s.jitter=%g must be between 0 and 1", prefix, *p.Jitter))
+	}
+	if p.MaxRestarts < -1 {
+		errs = append(errs, fmt.Errorf("%s.maxRestarts=%d must be positive or -1 (unlimited)", prefix, p.MaxRestarts))
+	}
+	if full := p.withDefaults(); full.MaxBackoff < full.Backoff {
+		errs = append(errs, fmt.Errorf("%s.maxBackoff=%s lower than backoff=%s", prefix, full.MaxBackoff, full.Backoff))
+	}
+	return errs
+}
+
+// backoff tracks the crashes of one process
+type backoff struct {
+	policy   RestartPolicy
+	attempt  int         // crashes since the last sustained uptime
+	restarts []time.Time // restarts within policy.Window
+	rnd      func() float64
+}
+
+func newBackoff(p RestartPolicy) *backoff {
+	return &backoff{policy: p.withDefaults(), rnd: rand.Float64}
+}
+
+// next returns the delay before restarting a process that ran for uptime,
+// or an error when it restarted too often and should be given up
+func (b *backoff) next(uptime time.Duration, now time.Time) (time.Duration, error) {
+	p := b.policy
+	if uptime >= p.ResetAfter {
+		b.attempt = 0
+	}
+
+	// forget restarts outside the window
+	keep := b.restarts[:0]
+	for _, t := range b.restarts {
+		if now.Sub(t) < p.Window {
+			keep = append(keep, t)
+		}
+	}
+	b.restarts = keep
+	if p.MaxRestarts >= 0 && len(b.restarts) >= p.MaxRestarts {
+		return 0, fmt.Errorf("restarted %d times within %s", len(b.restarts), p.Window)
+	}
+	b.restarts = append(b.restarts, now)
+
+	d := time.Duration(float64(p.Backoff) * math.Pow(2, float64(b.attempt)))
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	b.attempt++
+	// spread d by +/- Jitter so processes don't restart in lockstep
+	d += time.Duration(float64(d) * *p.Jitter * (b.rnd()*2 - 1))
+	return d, nil
+}
+
+// reset forgets all crashes, after a manual restart
+func (b *backoff) reset() {
+	b.attempt = 0
+	b.restarts = nil
+}
+
+/** Process states */
+const (
+	ProcWaiting  = "waiting"  // for its dep
+	ProcStarting = "starting" // until its ready probe passes
+	ProcRunning  = "running"
+	ProcBackoff  = "backoff" // stopped, restarting at NextStart
+	ProcFailed   = "failed"  // gave up restarting
+	ProcStopped  = "stopped" // by shutdown
+)
+
+// ProcState is the supervisor state of one process
+type ProcState struct {
+	Name      string
+	State     string
+	Since     time.Time // last state change
+	Started   time.Time // last start
+	Pid       int       `json:",omitempty"` // when State=running
+	NextStart time.Time // when State=backoff
+	Restarts  int
+	LastExit  int    // exit code, -1 when killed by a signal or not started
+	LastError string `json:",omitempty"`
+}
+
+var (
+	procMu      sync.Mutex
+	procs       = make(map[string]*ProcState)
+	procRestart = make(map[string]chan struct{}) // manual restart requests per supervised process
+)
+
+/** errUnknownProcess is returned by RestartProcess for a name that isn't supervised */
+var errUnknownProcess = errors.New("process unknown")
+
+// restartChan returns the manual restart requests of name
+func restartChan(name string) chan struct{} {
+	procMu.Lock()
+	defer procMu.Unlock()
+	ch, ok := procRestart[name]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		procRestart[name] = ch
+	}
+	return ch
+}
+
+// RestartProcess stops the running process name (SIGTERM, SIGKILL after
+// ChildStopTimeout) and lets ensureRunning start it again without backoff.
+// A process in backoff is started now, a failed process gets a new chance.
+func RestartProcess(name string) error {
+	procMu.Lock()
+	ch, ok := procRestart[name]
+	state := ""
+	if p, found := procs[name]; found {
+		state = p.State
+	}
+	procMu.Unlock()
+	if !ok {
+		return errUnknownProcess
+	}
+
+	switch state {
+	case ProcRunning, ProcBackoff, ProcFailed:
+	default:
+		return fmt.Errorf("[%s] can't restart while %s", name, state)
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+		// already requested
+	}
+	if state != ProcRunning {
+		return nil
+	}
+	return stopProcess(name, ChildStopTimeout)
+}
+
+// setProcState changes the state of name, update is called with the lock held
+func setProcState(name, state string, update func(p *ProcState)) {
+	procMu.Lock()
+	defer procMu.Unlock()
+	p, ok := procs[name]
+	if !ok {
+		p = &ProcState{Name: name, LastExit: -1}
+		procs[name] = p
+	}
+	if p.State != state {
+		p.Since = time.Now()
+		if p.State != "" {
+			processState.Set(0, name, p.State)
+		}
+		processState.Set(1, name, state)
+	}
+	p.State = state
+	if update != nil {
+		update(p)
+	}
+}
+
+// Process returns the state of the supervised process name
+func Process(name string) (ProcState, bool) {
+	procMu.Lock()
+	defer procMu.Unlock()
+	p, ok := procs[name]
+	if !ok {
+		return ProcState{}, false
+	}
+	return *p, true
+}
+
+// Processes returns the state of all supervised processes sorted by name
+func Processes() []ProcState {
+	procMu.Lock()
+	defer procMu.Unlock()
+	out := make([]ProcState, 0, len(procs))
+	for _, p := range procs {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// exitCode returns the exit code in e of run(), -1 when there is none
+func exitCode(e error) int {
+	if e == nil {
+		return 0
+	}
+	var exit *exec.ExitError
+	if errors.As(e, &exit) {
+		return exit.ExitCode()
+	}
+	return -1
+}
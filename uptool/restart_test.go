@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second, MaxRestarts: 5, Window: time.Minute, ResetAfter: 30 * time.Second})
+	b.rnd = func() float64 { return 0.5 } // no jitter
+
+	now := time.Now()
+	for i, expect := range []time.Duration{1, 2, 4, 5} {
+		d, e := b.next(time.Second, now)
+		if e != nil || d != expect*time.Second {
+			t.Errorf("crash=%d delay=%s e=%v expected=%ds", i, d, e, expect)
+		}
+		now = now.Add(d)
+	}
+
+	// sustained uptime resets the delay but not the restart rate
+	if d, e := b.next(time.Minute, now); e != nil || d != time.Second {
+		t.Errorf("after uptime delay=%s e=%v", d, e)
+	}
+	if _, e := b.next(time.Second, now); e == nil || !strings.Contains(e.Error(), "restarted 5 times within 1m0s") {
+		t.Errorf("expected to give up, e=%v", e)
+	}
+
+	// restarts outside the window are forgotten
+	if _, e := b.next(time.Second, now.Add(2*time.Minute)); e != nil {
+		t.Errorf("window not applied, e=%v", e)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	jitter := 0.2
+	b := newBackoff(RestartPolicy{Backoff: 10 * time.Second, Jitter: &jitter, MaxRestarts: -1})
+	for _, c := range []struct {
+		rnd    float64
+		expect time.Duration
+	}{{0, 8 * time.Second}, {1, 24 * time.Second}} {
+		b.rnd = func() float64 { return c.rnd }
+		if d, _ := b.next(0, time.Now()); d != c.expect {
+			t.Errorf("rnd=%g delay=%s expected=%s", c.rnd, d, c.expect)
+		}
+	}
+
+	// unlimited restarts
+	for i := 0; i < 100; i++ {
+		if _, e := b.next(0, time.Now()); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	// jitter: 0 turns it off instead of using the default
+	off := 0.0
+	b = newBackoff(RestartPolicy{Backoff: 10 * time.Second, Jitter: &off})
+	b.rnd = func() float64 { return 1 }
+	if d, _ := b.next(0, time.Now()); d != 10*time.Second {
+		t.Errorf("jitter=0 delay=%s", d)
+	}
+}
+
+func TestRestartPolicyValidate(t *testing.T) {
+	c := DefaultConfig()
+	info := c.Cmds["xvfb"]
+	jitter := 2.0
+	info.Restart = RestartPolicy{Backoff: time.Minute, MaxBackoff: time.Second, Jitter: &jitter, MaxRestarts: -2}
+	c.Cmds["xvfb"] = info
+	e := c.Validate()
+	for _, expect := range []string{"cmds.xvfb.restart.jitter=2", "cmds.xvfb.restart.maxRestarts=-2", "cmds.xvfb.restart.maxBackoff=1s lower than backoff=1m0s"} {
+		if e == nil || !strings.Contains(e.Error(), expect) {
+			t.Errorf("expected=%s e=%v", expect, e)
+		}
+	}
+}
+
+func TestProcState(t *testing.T) {
+	setProcState("test", ProcRunning, nil)
+	setProcState("test", ProcBackoff, func(p *ProcState) { p.LastExit = 3 })
+	p, ok := Process("test")
+	if !ok || p.State != ProcBackoff || p.LastExit != 3 {
+		t.Errorf("state=%+v", p)
+	}
+	if processState.Value("test", ProcRunning) != 0 || processState.Value("test", ProcBackoff) != 1 {
+		t.Errorf("metric not updated")
+	}
+	if _, ok := Process("nope"); ok {
+		t.Errorf("unknown process found")
+	}
+}
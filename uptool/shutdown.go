@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/maurice2k/tcpserver"
+)
+
+var (
+	// ShutdownTimeout is how long the whole shutdown takes at most on SIGTERM,
+	// below the 10s docker waits before it sends SIGKILL
+	ShutdownTimeout = 9 * time.Second
+	// ChildStopTimeout is how long a child gets between SIGTERM and SIGKILL
+	ChildStopTimeout = 5 * time.Second
+)
+
+/** errShuttingDown is returned by proxy() once the shutdown started */
+var errShuttingDown = errors.New("shutting down")
+
+// drainGroup counts running calls and refuses new ones once closed
+type drainGroup struct {
+	mu     sync.Mutex
+	n      int
+	closed bool
+	idle   chan struct{} // closed when n drops to 0 after Close
+}
+
+// Enter registers a call, false when the group is closed
+func (d *drainGroup) Enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	d.n++
+	return true
+}
+
+// Leave unregisters a call
+func (d *drainGroup) Leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.n--
+	if d.n == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// Close refuses new calls and waits until ctx is done for the running ones
+func (d *drainGroup) Close(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	if d.n == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return fmt.Errorf("%d still running: %w", d.n, ctx.Err())
+	}
+}
+
+/** proxies are the running proxy() calls */
+var proxies = new(drainGroup)
+
+// closePool sends QUIT to all idle lookup conns so IQFeed frees them
+func closePool() {
+	if mutex == nil {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for k, conn := range conns {
+		if _, e := conn.WriteLine([]byte("QUIT")); e != nil {
+			slog.Error("shutdown(closePool) QUIT", "e", e.Error())
+		}
+		if e := conn.C.Close(); e != nil {
+			slog.Error("shutdown(closePool) Close", "e", e.Error())
+		}
+		delete(conns, k)
+		poolConns.Inc("dropped")
+	}
+}
+
+// stopOrder returns the cmds with dependents before their dependency (iqfeed before xvfb)
+func stopOrder(cmds map[string]CmdInfo) []string {
+	depth := func(name string) int {
+		n := 0
+		// the config validation rejects cycles, len(cmds) is the safety net
+		for dep := cmds[name].Dep; dep != "" && n < len(cmds); dep = cmds[dep].Dep {
+			n++
+		}
+		return n
+	}
+
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := depth(names[i]), depth(names[j])
+		if di != dj {
+			return di > dj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// stopProcess sends SIGTERM to the running process name and SIGKILL
+// when it's still running after timeout
+func stopProcess(name string, timeout time.Duration) error {
+	v, ok := Running.Load(name)
+	if !ok {
+		// not running (or not marked running yet)
+		return nil
+	}
+	pid := v.(int)
+	p, e := os.FindProcess(pid)
+	if e != nil {
+		return e
+	}
+	slog.Info("shutdown(stopProcess) SIGTERM", "name", name, "pid", pid)
+	if e := p.Signal(syscall.SIGTERM); e != nil {
+		return e
+	}
+
+	// run() removes it from Running when it exits
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if v, ok := Running.Load(name); !ok || v.(int) != pid {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	slog.Warn("shutdown(stopProcess) SIGKILL", "name", name, "pid", pid, "timeout", timeout.String())
+	if e := p.Kill(); e != nil {
+		return e
+	}
+	return fmt.Errorf("[%s] killed, no exit within %s after SIGTERM", name, timeout)
+}
+
+// remaining returns the time left until the deadline of ctx
+func remaining(ctx context.Context) time.Duration {
+	deadline, _ := ctx.Deadline()
+	return max(time.Until(deadline), 0)
+}
+
+// shutdown stops the servers, drains the running lookups and stops the
+// children in reverse dependency order, all within ShutdownTimeout. It
+// returns the exit status, 0 when everything stopped in time.
+func shutdown(httpServer *http.Server, tcpServers []*tcpserver.Server, cmds map[string]CmdInfo, wg *sync.WaitGroup) int {
+	status := 0
+	fail := func(step string, e error) {
+		slog.Error("shutdown("+step+")", "e", e.Error())
+		status = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	// keep ChildStopTimeout for the children, the servers get at least half
+	drainCtx, drainCancel := context.WithTimeout(ctx, max(ShutdownTimeout-ChildStopTimeout, ShutdownTimeout/2))
+	defer drainCancel()
+
+	// 1. stop accepting, the streams end on httpShutdown
+	for _, server := range tcpServers {
+		if e := server.Shutdown(remaining(drainCtx)); e != nil {
+			fail("tcpserver", e)
+		}
+	}
+	if httpServer != nil {
+		if e := httpServer.Shutdown(drainCtx); e != nil {
+			fail("http", e)
+			httpServer.Close()
+		}
+	}
+
+	// 2. drain lookups and free the upstream conns
+	if e := proxies.Close(drainCtx); e != nil {
+		fail("proxies", e)
+	}
+	closePool()
+	if recorder != nil {
+		if e := recorder.Close(); e != nil {
+			fail("recorder", e)
+		}
+	}
+
+	// 3. children
+	close(stopSupervisor)
+	for _, name := range stopOrder(cmds) {
+		if e := stopProcess(name, min(ChildStopTimeout, remaining(ctx))); e != nil {
+			fail("stopProcess", e)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		fail("ensureRunning", fmt.Errorf("supervisor still running after %s", ShutdownTimeout))
+	}
+
+	if barCache != nil {
+		if e := barCache.db.Close(); e != nil {
+			fail("barCache", e)
+		}
+	}
+	slog.Info("shutdown done", "status", status)
+	return status
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainGroup(t *testing.T) {
+	d := new(drainGroup)
+	if !d.Enter() || !d.Enter() {
+		t.Fatal("Enter refused")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d.Leave()
+		d.Leave()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if e := d.Close(ctx); e != nil {
+		t.Error(e)
+	}
+	if d.Enter() {
+		t.Error("Enter after Close")
+	}
+
+	d = new(drainGroup)
+	d.Enter()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if e := d.Close(ctx); e == nil || !strings.Contains(e.Error(), "1 still running") {
+		t.Errorf("e=%v", e)
+	}
+}
+
+func TestProxyShuttingDown(t *testing.T) {
+	fakeUpstream(t)
+	old := proxies
+	proxies = new(drainGroup)
+	defer func() { proxies = old }()
+
+	proxies.Close(context.Background())
+	if e := proxy([]byte("HDX,AAPL,1"), -1, func([]byte) error { return nil }); e != errShuttingDown {
+		t.Errorf("e=%v", e)
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	order := stopOrder(map[string]CmdInfo{
+		"xvfb":    {Cmd: "Xvfb"},
+		"iqfeed":  {Cmd: "wine64", Dep: "xvfb"},
+		"monitor": {Cmd: "monitor", Dep: "iqfeed"},
+		"other":   {Cmd: "other"},
+	})
+	if expect := []string{"monitor", "iqfeed", "other", "xvfb"}; !reflect.DeepEqual(order, expect) {
+		t.Errorf("order=%v expected=%v", order, expect)
+	}
+}
+
+func TestStopProcess(t *testing.T) {
+	fakeUpstream(t)
+	start := func(name string, script string) <-chan struct{} {
+		t.Helper()
+		cmd := exec.Command("sh", "-c", script)
+		if e := cmd.Start(); e != nil {
+			t.Skip(e)
+		}
+		Running.Store(name, cmd.Process.Pid)
+		done := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			Running.Delete(name)
+			close(done)
+		}()
+		// let sh start (and install the trap)
+		time.Sleep(100 * time.Millisecond)
+		return done
+	}
+
+	done := start("test-term", "exec sleep 30")
+	if e := stopProcess("test-term", time.Second); e != nil {
+		t.Error(e)
+	}
+	<-done
+
+	done = start("test-kill", "trap '' TERM; while :; do sleep 0.05; done")
+	if e := stopProcess("test-kill", 200*time.Millisecond); e == nil || !strings.Contains(e.Error(), "killed") {
+		t.Errorf("e=%v", e)
+	}
+	<-done
+
+	if e := stopProcess("test-none", time.Second); e != nil {
+		t.Errorf("not running e=%v", e)
+	}
+}
+
+func TestClosePool(t *testing.T) {
+	f := fakeUpstream(t)
+	f.Reply("HDX,AAPL,1", "LH,2024-05-10,185.0900,182.1300,184.9000,183.0500,50759500,0,")
+	if e := proxy([]byte("HDX,AAPL,1"), -1, func([]byte) error { return nil }); e != nil {
+		t.Fatal(e)
+	}
+	mutex.Lock()
+	n := len(conns)
+	mutex.Unlock()
+	if n != 1 {
+		t.Fatalf("pool=%d", n)
+	}
+
+	closePool()
+	mutex.Lock()
+	n = len(conns)
+	mutex.Unlock()
+	if n != 0 {
+		t.Errorf("pool=%d after closePool", n)
+	}
+	if cmds := f.Cmds(); len(cmds) != 1 {
+		t.Errorf("cmds=%v", cmds)
+	}
+}
+
+func TestStreamShutdown(t *testing.T) {
+	old := httpShutdown
+	httpShutdown = make(chan struct{})
+	defer func() { httpShutdown = old }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streamSSE(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream?symbols=AAPL", nil), make(chan L1Msg))
+	}()
+	close(httpShutdown)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SSE stream still running after the shutdown")
+	}
+}
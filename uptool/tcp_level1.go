@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/maurice2k/tcpserver"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** tcpLevel1 is the conn.Accept handler that offers the Level 1 protocol
+ * to many clients that all share the one level1 upstream conn.
+ * Watches are merged, every client only receives what it asked for */
+func tcpLevel1(conn tcpserver.Connection) {
+	defer func() {
+		if e := conn.Close(); e != nil {
+			slog.Error("tcp_level1 close", "e", e.Error())
+		}
+		if Verbose {
+			slog.Info("tcp_level1 dropped conn")
+		}
+	}()
+	if Verbose {
+		slog.Info("tcp_level1 new req")
+	}
+
+	var mu sync.Mutex
+	w := bufio.NewWriterSize(conn, 1024*1024)
+	// writeLine writes+flushes a line, used for both replies and updates
+	writeLine := func(line []byte, flush bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if e := conn.SetWriteDeadline(time.Now().Add(deadlineCmd)); e != nil {
+			return e
+		}
+		if _, e := w.Write(line); e != nil {
+			return e
+		}
+		if _, e := w.Write([]byte("\r\n")); e != nil {
+			return e
+		}
+		if flush {
+			return w.Flush()
+		}
+		return nil
+	}
+
+	sub := level1.SubscribeSystem()
+	defer level1.Unsubscribe(sub)
+
+	// Updates to client
+	go func() {
+		for msg := range sub.C {
+			// only flush when we've caught up
+			if e := writeLine(msg.Line, len(sub.C) == 0); e != nil {
+				slog.Error("tcp_level1 writeUpdate", "e", e.Error())
+				break
+			}
+		}
+		// Dropped by level1 or client gone, unblock the reader
+		conn.Close()
+	}()
+
+	// Commands from client
+	r := bufio.NewReader(conn)
+	for {
+		bin, e := r.ReadBytes(byte('\n'))
+		if e != nil {
+			if Verbose {
+				slog.Info("tcp_level1 readBytes", "e", e.Error())
+			}
+			return
+		}
+		bin = bytes.TrimSpace(bin)
+		if Verbose {
+			slog.Info("tcp_level1", "bin", bin)
+		}
+
+		reply := tcpLevel1Cmd(sub, string(bin))
+		if reply == "" {
+			continue
+		}
+		if e := writeLine([]byte(reply), true); e != nil {
+			slog.Error("tcp_level1 writeReply", "e", e.Error())
+			return
+		}
+	}
+}
+
+// tcpLevel1Cmd applies a single client command to sub and returns the reply (if any)
+func tcpLevel1Cmd(sub *L1Sub, cmd string) string {
+	if cmd == "" {
+		return ""
+	}
+
+	switch {
+	case cmd[0] == 'w' || cmd[0] == 't':
+		// DevNote: trades-only watches are merged into regular watches
+		sym := strings.ToUpper(strings.TrimSpace(cmd[1:]))
+		if sym == "" {
+			return "E,!SYNTAX_ERROR!,"
+		}
+		level1.Watch(sub, sym)
+		return ""
+
+	case cmd[0] == 'r':
+		sym := strings.ToUpper(strings.TrimSpace(cmd[1:]))
+		if sym == "" {
+			return "E,!SYNTAX_ERROR!,"
+		}
+		level1.Unwatch(sub, sym)
+		return ""
+
+	case strings.HasPrefix(cmd, "S,SET PROTOCOL"):
+		// fake the responsive, we're already taking care of this
+		if !strings.HasSuffix(cmd, "6.2") {
+			return "E,PROTOCOL_DEPRECATED_NEED_6.2"
+		}
+		return "S,CURRENT PROTOCOL,6.2"
+
+	case strings.HasPrefix(cmd, "S,SET CLIENT NAME"):
+		return ""
+
+	case cmd == "S,UNWATCH ALL":
+		for _, sym := range level1.Symbols(sub) {
+			level1.Unwatch(sub, sym)
+		}
+		return ""
+
+	case cmd == "S,REQUEST WATCHES":
+		return "S,WATCHES," + strings.Join(level1.Symbols(sub), ",")
+
+	case cmd == "S,REQUEST CURRENT UPDATE FIELDNAMES":
+		update, _ := level1.FieldNames()
+		return "S,CURRENT UPDATE FIELDNAMES," + strings.Join(update, ",")
+
+	case cmd == "S,REQUEST FUNDAMENTAL FIELDNAMES":
+		_, fundamental := level1.FieldNames()
+		return "S,FUNDAMENTAL FIELDNAMES," + strings.Join(fundamental, ",")
+	}
+
+	// Anything else changes the shared upstream conn (field selection, connect/disconnect)
+	return "E,SHARED_CONN_CMD_UNSUPPORTED," + cmd
+}
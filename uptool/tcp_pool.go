@@ -35,6 +35,13 @@ func (p *PoolConn) IncreaseDeadline(deadline time.Duration) error {
 	return nil
 }
 
+var (
+	// PoolMaxReuse is how often a conn is re-used before we QUIT it
+	PoolMaxReuse = 2000
+	// PoolKeepAlive is the interval we test the idle conns
+	PoolKeepAlive = 40 * time.Second
+)
+
 var (
 	conns   map[string]*PoolConn
 	counter int
@@ -89,6 +96,8 @@ func ConnTest(conn *PoolConn, origin string) error {
 		}
 		if bytes.Equal(bin, []byte("E,!SYNTAX_ERROR!,")) {
 			if flushed > 0 {
+				poolFlushes.Inc()
+				poolFlushed.Add(float64(flushed))
 				slog.Warn("tcp_pool(ConnTest) remaining data", "origin", origin, "n", flushed, "timeout_extend", "+2sec")
 				// Increasing timeout to account for data that sticks in the queue
 				deadlineCmd = deadlineCmd + (time.Second * 2)
@@ -106,7 +115,7 @@ func ConnTest(conn *PoolConn, origin string) error {
 // ConnKeepAlive is a blocking func to keep 'cached' conns alive.
 func ConnKeepAlive() {
 	for {
-		time.Sleep(40 * time.Second)
+		time.Sleep(PoolKeepAlive)
 		mutex.Lock()
 		if Verbose {
 			slog.Info("tcp_pool(ConnKeepAlive) start")
@@ -116,12 +125,14 @@ func ConnKeepAlive() {
 			if e := conn.IncreaseDeadline(deadlineCmd); e != nil {
 				slog.Error("tcp_pool(ConnKeepAlive) SetDeadline", "e", e.Error())
 				delete(conns, k)
+				poolConns.Inc("dropped")
 				continue
 			}
 
 			if e := ConnTest(conn, "ConnKeepAlive"); e != nil {
 				slog.Error("tcp_pool(ConnKeepAlive) ConnTest", "e", e.Error())
 				delete(conns, k)
+				poolConns.Inc("dropped")
 				continue
 			}
 		}
@@ -164,20 +175,23 @@ func GetConn() (*PoolConn, error) {
 
 		if e := conn.IncreaseDeadline(deadlineCmd); e != nil {
 			slog.Warn("tcp_pool(GetConn) setDeadline", "e", e)
+			poolConns.Inc("dropped")
 			continue
 		}
 
 		// Ensure the conn is good
 		if e := ConnTest(conn, "GetConn"); e != nil {
 			slog.Warn("tcp_pool(GetConn) ConnTest", "e", e)
+			poolConns.Inc("dropped")
 			continue
 		}
+		poolConns.Inc("reused")
 		return conn, nil
 	}
 
 	// 2. new conn
 	{
-		upConn, e := net.DialTimeout("tcp", "127.0.0.1:9100", defaultConnectTimeout)
+		upConn, e := upstream.Dial(upstream.Lookup)
 		if e != nil {
 			return nil, e
 		}
@@ -193,6 +207,7 @@ func GetConn() (*PoolConn, error) {
 			return nil, e
 		}
 
+		poolConns.Inc("created")
 		return conn, nil
 	}
 }
@@ -203,6 +218,7 @@ func FreeConn(n *PoolConn) {
 	if e := n.IncreaseDeadline(deadlineCmd); e != nil {
 		n.C.Close()
 		slog.Error("tcp_pool(FreeConn) setDeadline", "e", e.Error())
+		poolConns.Inc("dropped")
 		return
 	}
 
@@ -210,17 +226,19 @@ func FreeConn(n *PoolConn) {
 	if e := ConnTest(n, "FreeConn"); e != nil {
 		n.C.Close()
 		slog.Error("tcp_pool(FreeConn) ConnTest", "e", e.Error())
+		poolConns.Inc("dropped")
 		return
 	}
 
-	if n.ReUse > 2000 {
-		slog.Info("tcp_pool(FreeConn) Reuse over 2000, dropping conn")
+	if n.ReUse > PoolMaxReuse {
+		slog.Info("tcp_pool(FreeConn) Reuse limit reached, dropping conn", "reuse", n.ReUse)
 		if _, e := n.WriteLine([]byte("QUIT")); e != nil {
 			slog.Error("tcp_pool(FreeConn) QUIT", "e", e.Error())
 		}
 		if e := n.C.Close(); e != nil {
 			slog.Error("tcp_pool(FreeConn) Close", "e", e.Error())
 		}
+		poolConns.Inc("dropped")
 		return
 	}
 
@@ -3,14 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/maurice2k/tcpserver"
 	"log/slog"
 	"time"
 )
 
-/** maxDatapoints is the maximum of data we allow in non-chunked mode (else you get timeouts) */
-const MaxDatapoints = 10000
+/** MaxDatapoints is the maximum of data we allow in non-chunked mode (else you get timeouts) */
+var MaxDatapoints = 10000
 
 /** defaultConnectTimeout is the default upstream.Connect timeout */
 const defaultConnectTimeout = 3 * time.Second
@@ -34,6 +35,7 @@ func init() {
 		"HDX": struct{}{},
 		"HWX": struct{}{},
 		"HMX": struct{}{},
+		"HTX": struct{}{},
 		"HTD": struct{}{},
 		"HTT": struct{}{},
 		"HIX": struct{}{},
@@ -41,6 +43,19 @@ func init() {
 		"HIT": struct{}{},
 		"HDT": struct{}{},
 		"SBF": struct{}{},
+		"SBS": struct{}{},
+		"SBN": struct{}{},
+		"SBC": struct{}{},
+		"SLM": struct{}{},
+		"SST": struct{}{},
+		"CEO": struct{}{},
+		"CFU": struct{}{},
+		"CFO": struct{}{},
+		"CFS": struct{}{},
+		"NCG": struct{}{},
+		"NHL": struct{}{},
+		"NSS": struct{}{},
+		"NSC": struct{}{},
 	}
 }
 
@@ -58,20 +73,50 @@ func isError(bin []byte) [][]byte {
 // LineFunc is called on every line read and stops the proxy on error
 type LineFunc func(line []byte) error
 
+/** errStopProxy is returned by a LineFunc to stop the proxy without error, the conn
+ * is dropped as the rest of the reply is unread */
+var errStopProxy = errors.New("stop proxy")
+
 // proxy opens an upstream connection and calls cb on every line it reads
-func proxy(cmd []byte, lineLimit int, cb LineFunc) error {
-	if _, ok := Running.Load("iqfeed"); !ok {
-		return fmt.Errorf("iqfeed not running")
+func proxy(cmd []byte, lineLimit int, cb LineFunc) (err error) {
+	if !proxies.Enter() {
+		return errShuttingDown
 	}
-	if _, ok := Running.Load("admin"); !ok {
-		return fmt.Errorf("admin not ready")
+	defer proxies.Leave()
+
+	start := time.Now()
+	upstreamInflight.Add(1)
+	defer func() {
+		upstreamInflight.Add(-1)
+		result := "ok"
+		if err != nil && err.Error() == "!NO_DATA!" {
+			result = "no_data"
+		} else if err != nil {
+			result = "error"
+		}
+		upstreamRequests.Inc(cmdType(cmd), result)
+		upstreamDuration.Since(start, cmdType(cmd))
+	}()
+
+	if e := upstream.Ready(); e != nil {
+		return e
 	}
 
 	conn, e := GetConn()
 	if e != nil {
 		return e
 	}
-	defer FreeConn(conn)
+	stopped := false
+	defer func() {
+		if !stopped {
+			FreeConn(conn)
+			return
+		}
+		if e := conn.C.Close(); e != nil {
+			slog.Error("tcp_proxy(proxy) Close", "e", e.Error())
+		}
+		poolConns.Inc("dropped")
+	}()
 
 	if e := conn.IncreaseDeadline(deadlineCmd); e != nil {
 		return fmt.Errorf("tcp_pool(GetConn) setDeadline e=" + e.Error())
@@ -83,6 +128,8 @@ func proxy(cmd []byte, lineLimit int, cb LineFunc) error {
 	if _, e := conn.WriteLine(cmd); e != nil {
 		return e
 	}
+	id := recorder.Cmd(cmd)
+	defer func() { recorder.End(id, err) }()
 
 	i := 0
 	for {
@@ -103,6 +150,7 @@ func proxy(cmd []byte, lineLimit int, cb LineFunc) error {
 		if e != nil {
 			return fmt.Errorf("ReadLine e=%s", e.Error())
 		}
+		recorder.Line(id, bin)
 
 		if tok := isError(bin); len(tok) > 0 {
 			if Verbose {
@@ -128,7 +176,10 @@ func proxy(cmd []byte, lineLimit int, cb LineFunc) error {
 			return fmt.Errorf("CRIT: loopLimit(%d) reached, bin=%s\n", lineLimit, string(bin))
 		}
 
-		if e := cb(bin); e != nil {
+		if e := cb(bin); e == errStopProxy {
+			stopped = true
+			return nil
+		} else if e != nil {
 			if Verbose {
 				slog.Info("tcp_proxy(proxy) cbError", "stream", bin)
 			}
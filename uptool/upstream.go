@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// Upstream is where we find the IQFeed ports, tests swap it for a FakeFeed
+type Upstream struct {
+	Lookup string // 9100
+	Level1 string // 5009
+	Level2 string // 9200
+	Admin  string // 9300
+
+	// Dial connects to one of the ports
+	Dial func(addr string) (net.Conn, error)
+	// Ready returns why upstream can't be used (nil when it can)
+	Ready func() error
+}
+
+var upstream = DefaultUpstream()
+
+// DefaultUpstream is the IQFeed (iqconnect.exe) we start ourselves
+func DefaultUpstream() *Upstream {
+	return &Upstream{
+		Lookup: "127.0.0.1:9100",
+		Level1: "127.0.0.1:5009",
+		Level2: "127.0.0.1:9200",
+		Admin:  "127.0.0.1:9300",
+		Dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, defaultConnectTimeout)
+		},
+		Ready: runningReady,
+	}
+}
+
+// runningReady requires the iqfeed process and a connected admin-conn
+func runningReady() error {
+	if _, ok := Running.Load("iqfeed"); !ok {
+		return fmt.Errorf("iqfeed not running")
+	}
+	if _, ok := Running.Load("admin"); !ok {
+		return fmt.Errorf("admin not ready")
+	}
+	return nil
+}
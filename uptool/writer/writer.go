@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strings"
 
 	prettyjson "github.com/hokaccha/go-prettyjson"
@@ -21,6 +22,12 @@ type FlushEncoder interface {
 	Flush()
 }
 
+// CSVMarshaler is implemented by structs that can be written as CSV-row
+type CSVMarshaler interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
 type PrettyJSONEncoder struct {
 	r     *http.Request
 	w     http.ResponseWriter
@@ -100,7 +107,27 @@ func Encode(w http.ResponseWriter, r *http.Request, httpCode int, data interface
 		w.Write(s)
 		return nil
 	}
-	// TODO: Some CSV writer?
+	if strings.Contains(accept, "text/csv") {
+		if rows, ok := csvRows(data); ok {
+			w.Header().Set("Content-Type", "text/csv")
+			w.WriteHeader(httpCode)
+
+			enc := csv.NewWriter(w)
+			if len(rows) > 0 {
+				if e := enc.Write(rows[0].CSVHeader()); e != nil {
+					return e
+				}
+			}
+			for _, row := range rows {
+				if e := enc.Write(row.CSVRecord()); e != nil {
+					return e
+				}
+			}
+			enc.Flush()
+			return enc.Error()
+		}
+		// No CSV-representation, fallback to JSON
+	}
 
 	// JSON
 	isCurl := strings.Contains(r.Header.Get("User-Agent"), "curl/")
@@ -130,6 +157,32 @@ func Encode(w http.ResponseWriter, r *http.Request, httpCode int, data interface
 	return nil
 }
 
+// csvRows returns data as CSVMarshaler-list if data (or every item of it) supports it
+func csvRows(data interface{}) ([]CSVMarshaler, bool) {
+	if row, ok := data.(CSVMarshaler); ok {
+		return []CSVMarshaler{row}, true
+	}
+
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+	rows := make([]CSVMarshaler, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		row, ok := v.Index(i).Interface().(CSVMarshaler)
+		if !ok {
+			return nil, false
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		// empty slice, check the element type instead
+		_, ok := reflect.Zero(v.Type().Elem()).Interface().(CSVMarshaler)
+		return rows, ok
+	}
+	return rows, true
+}
+
 func ChunkedEncoder(w http.ResponseWriter, r *http.Request) Encoder {
 	accept := r.Header.Get("Accept")
 	if strings.Contains(accept, "application/json") {